@@ -20,18 +20,29 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
+	"github.com/matrix-org/waterfall/pkg/admin"
 	"github.com/matrix-org/waterfall/pkg/config"
 	"github.com/matrix-org/waterfall/pkg/profiling"
 	"github.com/matrix-org/waterfall/pkg/routing"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
-	"maunium.net/go/mautrix/event"
 )
 
+// How long to wait for the conferences to hang up on their participants when the SFU is stopped.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	// Subcommands that talk to a running SFU.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	// Parse command line flags.
 	var (
 		configFilePath = flag.String("config", "config.yaml", "configuration file path")
@@ -53,17 +64,6 @@ func main() {
 		deferred_functions = append(deferred_functions, profiling.InitMemoryProfiling(memProfile))
 	}
 
-	// Handle signal interruptions.
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		for _, function := range deferred_functions {
-			function()
-		}
-		os.Exit(0)
-	}()
-
 	// Load the config file from the environment variable or path.
 	config, err := config.LoadConfig(*configFilePath)
 	if err != nil {
@@ -90,8 +90,26 @@ func main() {
 		logrus.Fatalf("unrecognised log level: %s", config.LogLevel)
 	}
 
-	// Create matrix client.
-	matrixClient := signaling.NewMatrixClient(config.Matrix)
+	// File where the seen messages are persisted across the restarts (if enabled).
+	var seenMessagesPath string
+	if config.DataDirectory != "" {
+		if err := os.MkdirAll(config.DataDirectory, 0o700); err != nil {
+			logrus.WithError(err).Fatal("could not create data directory")
+			return
+		}
+
+		seenMessagesPath = filepath.Join(config.DataDirectory, "seen_messages")
+	}
+
+	logrus.WithField("session_id", signaling.LocalSessionID).Info("starting SFU session")
+
+	// Create the signaling transport (Matrix client by default).
+	var transport signaling.Transport
+	if config.Transport == signaling.TransportWebSocket {
+		transport = signaling.NewWebSocketServer(config.WebSocket)
+	} else {
+		transport = signaling.NewMatrixClient(config.Matrix, config.DataDirectory)
+	}
 
 	// Create a pre-configured factory for the peer connections.
 	connectionFactory, err := webrtc_ext.NewPeerConnectionFactory(config.WebRTC)
@@ -100,16 +118,44 @@ func main() {
 		return
 	}
 
-	// Create a channel which we'll use to send events to the router.
-	matrixEvents := make(chan *event.Event)
-	defer close(matrixEvents)
+	// Create a channel which we'll use to send messages to the router.
+	messages := make(chan signaling.IncomingMessage)
+	defer close(messages)
+
+	// Start a router that will receive messages from the transport and route them to the appropriate conference.
+	router, err := routing.StartRouter(
+		transport,
+		connectionFactory,
+		messages,
+		config.Conference,
+		config.Authorization,
+		seenMessagesPath,
+	)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not start router")
+		return
+	}
 
-	// Start a router that will receive events from the matrix client and route them to the appropriate conference.
-	routing.StartRouter(matrixClient, connectionFactory, matrixEvents, config.Conference)
+	// Handle signal interruptions: hang up all calls, so that the clients don't wait for ICE to time out.
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		router.Shutdown(shutdownTimeout)
+		for _, function := range deferred_functions {
+			function()
+		}
+		os.Exit(0)
+	}()
+
+	// Start the admin API (if enabled) that allows to move the conferences to other SFUs.
+	if config.Admin.ListenAddress != "" {
+		admin.StartServer(config.Admin, router)
+	}
 
-	// Start matrix client sync. This function will block until the sync fails.
-	if err := matrixClient.RunSync(func(e *event.Event) { matrixEvents <- e }); err != nil {
-		logrus.WithError(err).Fatal("matrix client sync failed")
+	// Start the transport (e.g. Matrix client sync). This function will block until the transport fails.
+	if err := transport.Run(func(msg signaling.IncomingMessage) { messages <- msg }); err != nil {
+		logrus.WithError(err).Fatal("signaling transport failed")
 		return
 	}
 
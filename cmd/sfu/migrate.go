@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/matrix-org/waterfall/pkg/admin"
+	"github.com/matrix-org/waterfall/pkg/config"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/id"
+)
+
+// Handles `sfu migrate`: asks a running SFU (over its admin API) to move the conferences to another focus.
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var (
+		configFilePath = flags.String("config", "config.yaml", "configuration file path (admin API address and token)")
+		conferenceID   = flags.String("conf", "", "conference to move (all conferences if empty)")
+		userID         = flags.String("user", "", "user ID of the focus to move to")
+		deviceID       = flags.String("device", "", "device ID of the focus to move to")
+	)
+	flags.Parse(args)
+
+	if *userID == "" || *deviceID == "" {
+		logrus.Fatal("-user and -device of the focus are required")
+	}
+
+	config, err := config.LoadConfig(*configFilePath)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not load config")
+	}
+
+	if config.Admin.ListenAddress == "" {
+		logrus.Fatal("the admin API is not enabled in the config")
+	}
+
+	focus := signaling.Focus{UserID: id.UserID(*userID), DeviceID: id.DeviceID(*deviceID)}
+	if err := admin.RequestMigration(config.Admin, *conferenceID, focus); err != nil {
+		logrus.WithError(err).Fatal("could not migrate")
+	}
+
+	logrus.Infof("moving to %s (%s)", focus.UserID, focus.DeviceID)
+}
@@ -10,12 +10,17 @@ require (
 	github.com/pion/rtp v1.7.13
 	github.com/sirupsen/logrus v1.9.0
 	golang.org/x/exp v0.0.0-20230116083435-1de6713980de
+	golang.org/x/net v0.5.0
 	gopkg.in/yaml.v3 v3.0.1
 	maunium.net/go/mautrix v0.11.0
 )
 
 require (
 	github.com/google/uuid v1.3.0 // indirect
+	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/pion/datachannel v1.5.2 // indirect
 	github.com/pion/dtls/v2 v2.2.4 // indirect
 	github.com/pion/ice/v2 v2.2.3 // indirect
@@ -30,13 +35,14 @@ require (
 	github.com/pion/transport/v2 v2.0.0 // indirect
 	github.com/pion/turn/v2 v2.0.8 // indirect
 	github.com/pion/udp v0.1.4 // indirect
+	github.com/rs/zerolog v1.27.0 // indirect
 	github.com/tidwall/gjson v1.14.1 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	github.com/tidwall/sjson v1.2.4 // indirect
 	golang.org/x/crypto v0.5.0 // indirect
-	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
+	maunium.net/go/maulogger/v2 v2.3.2 // indirect
 )
 
 replace maunium.net/go/mautrix => github.com/matrix-org/mautrix-go v0.0.0-20221213094344-43c13b516216
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+)
+
+// How long to wait for the admin API to respond.
+const clientTimeout = 10 * time.Second
+
+// Asks the SFU whose admin API listens on a given address to move a conference (or all conferences if the
+// conference ID is empty) to another focus.
+func RequestMigration(config Config, conferenceID string, focus signaling.Focus) error {
+	body, err := json.Marshal(migrateRequest{ConferenceID: conferenceID, Focus: focus})
+	if err != nil {
+		return err
+	}
+
+	address := config.ListenAddress
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	request, err := http.NewRequest(http.MethodPost, address+"/admin/migrate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+config.Token)
+	request.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: clientTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("admin API responded with %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
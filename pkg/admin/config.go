@@ -0,0 +1,9 @@
+package admin
+
+// Configuration of the admin API.
+type Config struct {
+	// The address that the admin API listens on, e.g. "127.0.0.1:8090". The admin API is disabled if empty.
+	ListenAddress string `yaml:"listen"`
+	// The token that must be sent in the `Authorization: Bearer <token>` header with each request.
+	Token string `yaml:"token"`
+}
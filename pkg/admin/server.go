@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/matrix-org/waterfall/pkg/routing"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
+)
+
+// Operations on the running conferences that the admin API exposes.
+type Conferences interface {
+	Migrate(conferenceID string, focus signaling.Focus) error
+	MigrateAll(focus signaling.Focus) error
+}
+
+// Body of the `POST /admin/migrate` request.
+type migrateRequest struct {
+	// The conference to move. All conferences are moved (the SFU is drained) if empty.
+	ConferenceID string `json:"conf_id"`
+	// The focus to move the conference(s) to.
+	Focus signaling.Focus `json:"focus"`
+}
+
+// Starts the admin API in the background.
+func StartServer(config Config, conferences Conferences) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/migrate", authorized(config.Token, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request migrateRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if request.Focus.UserID == "" || request.Focus.DeviceID == "" {
+			http.Error(w, "focus.user_id and focus.device_id are required", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if request.ConferenceID == "" {
+			err = conferences.MigrateAll(request.Focus)
+		} else {
+			err = conferences.Migrate(request.ConferenceID, request.Focus)
+		}
+
+		switch {
+		case errors.Is(err, routing.ErrUnknownConference):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+
+	go func() {
+		logrus.Infof("starting admin API on %s", config.ListenAddress)
+		if err := http.ListenAndServe(config.ListenAddress, mux); err != nil {
+			logrus.WithError(err).Fatal("admin API failed")
+		}
+	}()
+}
+
+// Wraps a handler so that it's only called for the requests that carry the right bearer token.
+func authorized(token string, handler http.HandlerFunc) http.HandlerFunc {
+	expected := []byte("Bearer " + token)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"golang.org/x/exp/slices"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	ErrNotAllowed         = errors.New("the user is not allowed to use the SFU")
+	ErrNotRoomMember      = errors.New("the user is not a member of the room")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Decides who may start and join the conferences.
+type Authorizer struct {
+	config Config
+	// Used to check the room membership (nil if the transport does not know about the rooms).
+	rooms signaling.MembershipChecker
+	// Used to check the OpenID tokens.
+	openID *openIDVerifier
+}
+
+func NewAuthorizer(config Config, rooms signaling.MembershipChecker) *Authorizer {
+	return &Authorizer{config: config, rooms: rooms, openID: newOpenIDVerifier()}
+}
+
+// Checks if the sender of a message may start or join a conference. Returns one of
+// `ErrNotAllowed`, `ErrNotRoomMember` or `ErrInvalidCredentials` (wrapped) if not.
+func (a *Authorizer) Authorize(msg signaling.IncomingMessage) error {
+	if !a.isAllowed(msg.UserID) {
+		return ErrNotAllowed
+	}
+
+	if a.config.SharedSecret != "" {
+		secret := msg.Credentials.SharedSecret
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(a.config.SharedSecret)) != 1 {
+			return fmt.Errorf("%w: wrong shared secret", ErrInvalidCredentials)
+		}
+	}
+
+	if a.config.RequireOpenID {
+		if msg.Credentials.OpenID == nil {
+			return fmt.Errorf("%w: no OpenID token", ErrInvalidCredentials)
+		}
+
+		if err := a.openID.verify(msg.UserID, *msg.Credentials.OpenID); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+		}
+	}
+
+	if a.config.RequireRoomMembership {
+		if msg.RoomID == "" || a.rooms == nil {
+			return fmt.Errorf("%w: unknown room", ErrNotRoomMember)
+		}
+
+		joined, err := a.rooms.IsJoined(msg.RoomID, msg.UserID)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrNotRoomMember, err)
+		} else if !joined {
+			return ErrNotRoomMember
+		}
+	}
+
+	return nil
+}
+
+// Checks the user against the allow and deny lists.
+func (a *Authorizer) isAllowed(userID id.UserID) bool {
+	if matches(a.config.Deny, userID) {
+		return false
+	}
+
+	return len(a.config.Allow) == 0 || matches(a.config.Allow, userID)
+}
+
+// Checks if the user or their server is in the list.
+func matches(list []string, userID id.UserID) bool {
+	return slices.Contains(list, userID.String()) || slices.Contains(list, userID.Homeserver())
+}
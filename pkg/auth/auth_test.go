@@ -0,0 +1,129 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/auth"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/id"
+)
+
+type rooms map[id.RoomID][]id.UserID
+
+func (r rooms) IsJoined(roomID id.RoomID, userID id.UserID) (bool, error) {
+	for _, member := range r[roomID] {
+		if member == userID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func TestAuthorize(t *testing.T) {
+	members := rooms{"!room:example.org": {"@alice:example.org"}}
+
+	cases := []struct {
+		name   string
+		config auth.Config
+		msg    signaling.IncomingMessage
+		err    error
+	}{
+		{
+			name: "everyone allowed by default",
+			msg:  signaling.IncomingMessage{UserID: "@mallory:evil.org"},
+		},
+		{
+			name:   "allowed server",
+			config: auth.Config{Allow: []string{"example.org"}},
+			msg:    signaling.IncomingMessage{UserID: "@alice:example.org"},
+		},
+		{
+			name:   "allowed user",
+			config: auth.Config{Allow: []string{"@bob:other.org"}},
+			msg:    signaling.IncomingMessage{UserID: "@bob:other.org"},
+		},
+		{
+			name:   "not in the allow list",
+			config: auth.Config{Allow: []string{"example.org"}},
+			msg:    signaling.IncomingMessage{UserID: "@mallory:evil.org"},
+			err:    auth.ErrNotAllowed,
+		},
+		{
+			name:   "deny list takes precedence",
+			config: auth.Config{Allow: []string{"example.org"}, Deny: []string{"@mallory:example.org"}},
+			msg:    signaling.IncomingMessage{UserID: "@mallory:example.org"},
+			err:    auth.ErrNotAllowed,
+		},
+		{
+			name:   "denied server",
+			config: auth.Config{Deny: []string{"evil.org"}},
+			msg:    signaling.IncomingMessage{UserID: "@mallory:evil.org"},
+			err:    auth.ErrNotAllowed,
+		},
+		{
+			name:   "room member",
+			config: auth.Config{RequireRoomMembership: true},
+			msg:    signaling.IncomingMessage{UserID: "@alice:example.org", RoomID: "!room:example.org"},
+		},
+		{
+			name:   "not a room member",
+			config: auth.Config{RequireRoomMembership: true},
+			msg:    signaling.IncomingMessage{UserID: "@bob:example.org", RoomID: "!room:example.org"},
+			err:    auth.ErrNotRoomMember,
+		},
+		{
+			name:   "unknown room",
+			config: auth.Config{RequireRoomMembership: true},
+			msg:    signaling.IncomingMessage{UserID: "@alice:example.org"},
+			err:    auth.ErrNotRoomMember,
+		},
+		{
+			name:   "correct secret",
+			config: auth.Config{SharedSecret: "secret"},
+			msg: signaling.IncomingMessage{
+				UserID:      "@alice:example.org",
+				Credentials: signaling.Credentials{SharedSecret: "secret"},
+			},
+		},
+		{
+			name:   "wrong secret",
+			config: auth.Config{SharedSecret: "secret"},
+			msg: signaling.IncomingMessage{
+				UserID:      "@alice:example.org",
+				Credentials: signaling.Credentials{SharedSecret: "guess"},
+			},
+			err: auth.ErrInvalidCredentials,
+		},
+		{
+			name:   "missing OpenID token",
+			config: auth.Config{RequireOpenID: true},
+			msg:    signaling.IncomingMessage{UserID: "@alice:example.org"},
+			err:    auth.ErrInvalidCredentials,
+		},
+		{
+			name:   "OpenID token from another server",
+			config: auth.Config{RequireOpenID: true},
+			msg: signaling.IncomingMessage{
+				UserID: "@alice:example.org",
+				Credentials: signaling.Credentials{
+					OpenID: &signaling.OpenIDToken{AccessToken: "token", MatrixServerName: "evil.org"},
+				},
+			},
+			err: auth.ErrInvalidCredentials,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := auth.NewAuthorizer(c.config, members).Authorize(c.msg)
+			if c.err == nil && err != nil {
+				t.Fatalf("expected the caller to be authorized, got %v", err)
+			}
+			if c.err != nil && !errors.Is(err, c.err) {
+				t.Fatalf("expected %v, got %v", c.err, err)
+			}
+		})
+	}
+}
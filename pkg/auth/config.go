@@ -0,0 +1,18 @@
+package auth
+
+// Configuration of the authorization of the callers. All checks that are configured must pass.
+type Config struct {
+	// Users (e.g. "@alice:example.org") or servers (e.g. "example.org") that may use the SFU.
+	// Everyone may use the SFU if empty.
+	Allow []string `yaml:"allow"`
+	// Users or servers that may not use the SFU (takes precedence over `allow`).
+	Deny []string `yaml:"deny"`
+	// Whether the callers must be joined to the room that the conference belongs to.
+	// The SFU must be able to see the state of the room.
+	RequireRoomMembership bool `yaml:"requireRoomMembership"`
+	// The secret that the invites must carry in `sfu_secret`. Not required if empty.
+	SharedSecret string `yaml:"sharedSecret"`
+	// Whether the invites must carry an OpenID token of the caller in `sfu_openid`
+	// (verified with the caller's homeserver).
+	RequireOpenID bool `yaml:"requireOpenId"`
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/id"
+)
+
+// Timeout of the requests to the callers' homeservers.
+const openIDRequestTimeout = 10 * time.Second
+
+var ErrWrongServer = errors.New("the token was not issued by the user's homeserver")
+
+// Verifies the OpenID tokens with the homeservers that issued them (over the federation API).
+type openIDVerifier struct {
+	client *http.Client
+}
+
+func newOpenIDVerifier() *openIDVerifier {
+	return &openIDVerifier{client: &http.Client{Timeout: openIDRequestTimeout}}
+}
+
+// Checks that the token belongs to a given user.
+func (v *openIDVerifier) verify(userID id.UserID, token signaling.OpenIDToken) error {
+	if token.MatrixServerName == "" || token.MatrixServerName != userID.Homeserver() {
+		return ErrWrongServer
+	}
+
+	server := v.resolveServer(token.MatrixServerName)
+	userInfoURL := fmt.Sprintf(
+		"https://%s/_matrix/federation/v1/openid/userinfo?access_token=%s",
+		server,
+		url.QueryEscape(token.AccessToken),
+	)
+
+	response, err := v.client.Get(userInfoURL)
+	if err != nil {
+		return fmt.Errorf("failed to verify OpenID token: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("OpenID token rejected by %s: %s", server, response.Status)
+	}
+
+	var userInfo struct {
+		Sub id.UserID `json:"sub"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&userInfo); err != nil {
+		return fmt.Errorf("invalid OpenID user info: %w", err)
+	}
+
+	if userInfo.Sub != userID {
+		return fmt.Errorf("OpenID token belongs to %s", userInfo.Sub)
+	}
+
+	return nil
+}
+
+// Finds the address of the federation API of a server. This is a simplified version of the server
+// discovery: the `.well-known` delegation is respected, SRV records are not.
+func (v *openIDVerifier) resolveServer(serverName string) string {
+	if _, _, err := net.SplitHostPort(serverName); err == nil {
+		return serverName
+	}
+
+	if response, err := v.client.Get("https://" + serverName + "/.well-known/matrix/server"); err == nil {
+		defer response.Body.Close()
+
+		var wellKnown struct {
+			Server string `json:"m.server"`
+		}
+		if response.StatusCode == http.StatusOK &&
+			json.NewDecoder(response.Body).Decode(&wellKnown) == nil &&
+			wellKnown.Server != "" {
+			if _, _, err := net.SplitHostPort(wellKnown.Server); err == nil {
+				return wellKnown.Server
+			}
+
+			return net.JoinHostPort(wellKnown.Server, "8448")
+		}
+	}
+
+	return net.JoinHostPort(serverName, "8448")
+}
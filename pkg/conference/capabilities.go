@@ -0,0 +1,62 @@
+package conference
+
+import (
+	"encoding/json"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/event"
+)
+
+// Version of the focus protocol (the data channel messages) that the SFU speaks. It's increased once the
+// messages change in a way that the older clients would not understand; new message types don't need that,
+// since they are listed in the capabilities.
+const focusProtocolVersion = 1
+
+// Data channel message that advertises the capabilities of the sender (`participant.Capabilities`). Both the SFU
+// and the participant send it once the data channel is open, so that they know what the other side understands.
+var focusCallCapabilities = event.Type{Type: "m.call.capabilities", Class: event.FocusEventType}
+
+// Returns the capabilities of the SFU.
+func (c *Conference) capabilities() participant.Capabilities {
+	return participant.Capabilities{
+		Version: focusProtocolVersion,
+		MessageTypes: []string{
+			focusCallCapabilities.Type,
+			event.FocusCallTrackSubscription.Type,
+			focusCallTrackSubscriptionError.Type,
+			event.FocusCallNegotiate.Type,
+			event.FocusCallSDPStreamMetadataChanged.Type,
+			event.FocusCallPing.Type,
+			event.FocusCallPong.Type,
+		},
+		Simulcast: c.connectionFactory.Simulcast(),
+		Codecs:    c.connectionFactory.Codecs(),
+		Limits: participant.CapabilityLimits{
+			MaxQueuedMessages: participant.MaxQueuedDataChannelMessages,
+			KeepAliveTimeout:  c.config.HeartbeatConfig.Timeout,
+		},
+	}
+}
+
+func (c *Conference) sendCapabilities(p *participant.Participant) {
+	p.SendDataChannelMessage(event.Event{
+		Type:    focusCallCapabilities,
+		Content: event.Content{Parsed: c.capabilities()},
+	})
+}
+
+// Records the capabilities that the participant has advertised.
+func (c *Conference) processCapabilitiesMessage(p *participant.Participant, content event.Content) {
+	var capabilities participant.Capabilities
+	if err := json.Unmarshal(content.VeryRaw, &capabilities); err != nil {
+		p.Logger.WithError(err).Warn("Ignoring invalid capabilities")
+		return
+	}
+
+	if capabilities.Version > focusProtocolVersion {
+		p.Logger.Infof("Participant speaks a newer protocol version (%d)", capabilities.Version)
+	}
+
+	p.Logger.WithField("version", capabilities.Version).Debug("Received capabilities")
+	p.Capabilities = &capabilities
+}
@@ -0,0 +1,132 @@
+package conference
+
+import (
+	"math"
+
+	"github.com/matrix-org/waterfall/pkg/channel"
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/util"
+)
+
+// Links always get the best quality that is available, since they forward
+// the tracks to the participants whose requirements we don't know.
+var linkRequirements = participant.TrackMetadata{MaxWidth: math.MaxInt16, MaxHeight: math.MaxInt16}
+
+// Links the conference to the same conference on all configured foci (except us).
+func (c *Conference) linkFoci() {
+	self := c.matrixWorker.focus()
+
+	for _, focus := range c.config.Cascade.Foci {
+		if focus == self {
+			continue
+		}
+
+		c.linkFocus(focus)
+	}
+}
+
+// Calls a remote focus, i.e. sends an invite to the same conference on another SFU. Once the link
+// is established, the link is treated as a participant that publishes the tracks of the remote focus.
+func (c *Conference) linkFocus(focus signaling.Focus) {
+	id := participant.ID{UserID: focus.UserID, DeviceID: focus.DeviceID, CallID: util.RandomString(16)}
+	logger := c.newLogger(id)
+	logger.Info("Linking to a remote focus")
+
+	messageSink := channel.NewSink(id, c.peerMessages)
+
+	peerConnection, offer, err := peer.NewOutgoingPeer(c.connectionFactory, c.config.peerConfig(), messageSink, logger)
+	if err != nil {
+		logger.WithError(err).Error("Failed to create a link to a remote focus")
+		return
+	}
+
+	// The session ID of the remote focus is not known until it answers.
+	p := c.newParticipant(id, peerConnection, messageSink, logger, "")
+	p.FocusLink = true
+	p.Outgoing = true
+	c.tracker.AddParticipant(p)
+
+	c.matrixWorker.sendSignalingMessage(
+		p.AsMatrixRecipient(),
+		signaling.Invite{
+			StreamMetadata: c.getAvailableStreamsFor(id),
+			SDP:            offer.SDP,
+		},
+	)
+}
+
+// Checks if an incoming link from another focus shall be accepted. If both foci try to link to each other
+// at the same time, only the link initiated by the focus that comes first (see `Focus.Less()`) survives.
+func (c *Conference) acceptFocusLink(id participant.ID) bool {
+	remote := signaling.Focus{UserID: id.UserID, DeviceID: id.DeviceID}
+
+	var existing *participant.Participant
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if p.Outgoing && remote.Is(p.ID.UserID, p.ID.DeviceID) {
+			existing = p
+		}
+	})
+
+	if existing == nil {
+		return true
+	}
+
+	if c.matrixWorker.focus().Less(remote) {
+		return false
+	}
+
+	existing.Logger.Info("Replacing our link with the one initiated by the remote focus")
+	c.matrixWorker.sendSignalingMessage(existing.AsMatrixRecipient(), signaling.Hangup{Reason: event.CallHangupUserHangup})
+	c.removeParticipant(existing.ID)
+
+	return true
+}
+
+// Subscribes a focus link to all tracks that are published by the end-users of this conference.
+// The tracks published by other links are not forwarded, otherwise the tracks would go in circles.
+func (c *Conference) subscribeLinkToUserTracks(link *participant.Participant) {
+	c.tracker.ForEachPublishedTrackInfo(func(owner participant.ID, info webrtc_ext.TrackInfo) {
+		if !c.isFocusLink(owner) {
+			c.subscribeLink(link, info.TrackID)
+		}
+	})
+}
+
+// Subscribes all established focus links to a given track.
+func (c *Conference) subscribeLinksToTrack(trackID participant.TrackID) {
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if p.FocusLink && p.Peer.DataChannelReady() {
+			c.subscribeLink(p, trackID)
+		}
+	})
+}
+
+func (c *Conference) subscribeLink(link *participant.Participant, trackID participant.TrackID) {
+	if err := c.tracker.Subscribe(link.ID, trackID, linkRequirements); err != nil {
+		link.Logger.Debugf("Not subscribing the link to track %s: %v", trackID, err)
+	}
+}
+
+// Hangs up all links to other foci.
+func (c *Conference) unlinkFoci() {
+	links := []*participant.Participant{}
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if p.FocusLink {
+			links = append(links, p)
+		}
+	})
+
+	c.hangUpOn(links, func(link *participant.Participant) signaling.Hangup {
+		link.Logger.Info("Hanging up the link to the remote focus")
+		return signaling.Hangup{Reason: event.CallHangupUserHangup}
+	})
+}
+
+func (c *Conference) isFocusLink(id participant.ID) bool {
+	p := c.tracker.GetParticipant(id)
+	return p != nil && p.FocusLink
+}
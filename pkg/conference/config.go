@@ -1,5 +1,13 @@
 package conference
 
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/id"
+)
+
 type Heartbeat struct {
 	// Timeout for WebRTC connections. If the client doesn't respond to an
 	// `m.call.ping` with an `m.call.pong` for this amount of time, the
@@ -10,7 +18,97 @@ type Heartbeat struct {
 	Interval int `yaml:"interval"`
 }
 
+// Configuration of the cascading, i.e. of the links between the SFUs.
+type Cascade struct {
+	// Other foci (SFUs) that the conferences are linked with. Each conference
+	// links to the same conference on these foci, so that the participants
+	// connected to different foci can see each other.
+	Foci []signaling.Focus `yaml:"foci"`
+}
+
+// Checks if a given user and device belong to one of the foci that we're cascading with.
+func (c Cascade) IsFocus(userID id.UserID, deviceID id.DeviceID) bool {
+	for _, focus := range c.Foci {
+		if focus.Is(userID, deviceID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// What to do with the invites if we can't tell which focus the caller must use.
+const (
+	// Accept the invite, so that the clients that don't support the focus selection could still join (default).
+	FocusFallbackAccept = "accept"
+	// Reject the invite, so that nobody joins a conference on a focus that the room has not chosen.
+	FocusFallbackReject = "reject"
+)
+
+// Configuration of the focus selection (MSC3898) that decides if an end-user may call us.
+type FocusSelection struct {
+	// What to do with an invite if the room state does not tell which focus the caller must use, e.g. the invite
+	// has no room ID, the room state could not be fetched or it does not mention any focus. ("accept" if not set)
+	Fallback string `yaml:"fallback"`
+}
+
+// Returns true if the invites must be rejected when the focus of the caller is not known.
+func (f FocusSelection) RejectsUnknown() bool {
+	return f.Fallback == FocusFallbackReject
+}
+
+// How long the local ICE candidates are buffered by default before they are sent.
+const defaultCandidateBatchWindow = 50 * time.Millisecond
+
+// Default timing of the ICE restarts.
+const (
+	defaultICERestartDelay   = 2 * time.Second
+	defaultICERestartTimeout = 15 * time.Second
+)
+
 // Configuration for the group conferences (calls).
 type Config struct {
 	HeartbeatConfig Heartbeat `yaml:"heartbeat"`
+	Cascade         Cascade   `yaml:"cascade"`
+	// Focus selection configuration.
+	FocusSelection FocusSelection `yaml:"focusSelection"`
+	// How long the gathered local ICE candidates are buffered, so that several of them could be sent
+	// in a single `m.call.candidates` event. (in milliseconds, 50 if not set, 0 sends them one by one)
+	CandidateBatchWindow *int `yaml:"candidateBatchWindow"`
+	// ICE restart configuration.
+	ICERestart ICERestart `yaml:"iceRestart"`
+}
+
+// Configuration of the ICE restarts that are done when a peer loses its connection.
+type ICERestart struct {
+	// How long to wait for the connection to recover on its own before restarting ICE.
+	// (in milliseconds, 2000 if not set)
+	Delay int `yaml:"delay"`
+	// How long to wait for the peer to reconnect after the ICE restart before hanging up.
+	// (in seconds, 15 if not set)
+	Timeout int `yaml:"timeout"`
+}
+
+// Returns the configuration for the peers of the conference.
+func (c Config) peerConfig() peer.Config {
+	window := defaultCandidateBatchWindow
+	if c.CandidateBatchWindow != nil {
+		window = time.Duration(*c.CandidateBatchWindow) * time.Millisecond
+	}
+
+	restartDelay := defaultICERestartDelay
+	if c.ICERestart.Delay > 0 {
+		restartDelay = time.Duration(c.ICERestart.Delay) * time.Millisecond
+	}
+
+	restartTimeout := defaultICERestartTimeout
+	if c.ICERestart.Timeout > 0 {
+		restartTimeout = time.Duration(c.ICERestart.Timeout) * time.Second
+	}
+
+	return peer.Config{
+		CandidateBatchWindow: window,
+		ICERestartDelay:      restartDelay,
+		ICERestartTimeout:    restartTimeout,
+	}
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 type MessageContent interface{}
@@ -19,11 +20,48 @@ type MatrixMessage struct {
 	Content MessageContent
 }
 
+// Messages that control the conference as a whole (sent by the router, not by the participants).
+type ControlMessage interface{}
+
+// Asks the conference to move to another focus.
+type MigrateTo struct {
+	Focus signaling.Focus
+}
+
+// Subscribes a participant that another focus has handed over to us to the tracks
+// that it has been subscribed to there.
+type RestoreSubscriptions struct {
+	Participant   participant.ID
+	Subscriptions []signaling.HandoffSubscription
+}
+
+// The SFU is shutting down: the conference hangs up on everyone and ends. The channel
+// is closed once the hangups are sent.
+type Shutdown struct {
+	Flushed chan<- struct{}
+}
+
 // New participant tries to join the conference.
 func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.CallInviteEventContent) error {
 	logger := c.newLogger(id)
 	logger.Info("Incoming participant")
 
+	// Other foci are allowed to link to us, but we must make sure that there is only one link between us.
+	isFocus := c.config.Cascade.IsFocus(id.UserID, id.DeviceID)
+	if isFocus && !c.acceptFocusLink(id) {
+		logger.Info("Rejecting the link since we have already linked to this focus")
+		c.matrixWorker.sendSignalingMessage(
+			signaling.MatrixRecipient{
+				UserID:          id.UserID,
+				DeviceID:        id.DeviceID,
+				CallID:          id.CallID,
+				RemoteSessionID: inviteEvent.SenderSessionID,
+			},
+			signaling.Hangup{Reason: event.CallHangupUserHangup},
+		)
+		return nil
+	}
+
 	// As per MSC3401, when the `session_id` field changes from an incoming `m.call.member` event,
 	// any existing calls from this device in this call should be terminated.
 	if participant := c.tracker.GetParticipant(id); participant != nil {
@@ -49,31 +87,20 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 	} else {
 		messageSink := channel.NewSink(id, c.peerMessages)
 
-		peerConnection, answer, err := peer.NewPeer(c.connectionFactory, inviteEvent.Offer.SDP, messageSink, logger)
+		peerConnection, answer, err := peer.NewPeer(
+			c.connectionFactory,
+			c.config.peerConfig(),
+			inviteEvent.Offer.SDP,
+			messageSink,
+			logger,
+		)
 		if err != nil {
 			logger.WithError(err).Errorf("Failed to process SDP offer")
 			return err
 		}
 
-		pingEvent := event.Event{
-			Type:    event.FocusCallPing,
-			Content: event.Content{},
-		}
-
-		heartbeat := participant.HeartbeatConfig{
-			Interval:  time.Duration(c.config.HeartbeatConfig.Interval) * time.Second,
-			Timeout:   time.Duration(c.config.HeartbeatConfig.Timeout) * time.Second,
-			SendPing:  func() bool { return p.SendDataChannelMessage(pingEvent) == nil },
-			OnTimeout: func() { messageSink.Send(peer.LeftTheCall{event.CallHangupKeepAliveTimeout}) },
-		}
-
-		p = &participant.Participant{
-			ID:              id,
-			Peer:            peerConnection,
-			Logger:          logger,
-			RemoteSessionID: inviteEvent.SenderSessionID,
-			Pong:            heartbeat.Start(),
-		}
+		p = c.newParticipant(id, peerConnection, messageSink, logger, inviteEvent.SenderSessionID)
+		p.FocusLink = isFocus
 
 		c.tracker.AddParticipant(p)
 		sdpAnswer = answer
@@ -95,6 +122,41 @@ func (c *Conference) onNewParticipant(id participant.ID, inviteEvent *event.Call
 	return nil
 }
 
+// Creates a new participant for a given peer. The caller is responsible for adding it to the tracker.
+func (c *Conference) newParticipant(
+	id participant.ID,
+	peerConnection *peer.Peer[participant.ID],
+	messageSink *channel.SinkWithSender[participant.ID, peer.MessageContent],
+	logger *logrus.Entry,
+	remoteSessionID id.SessionID,
+) *participant.Participant {
+	var p *participant.Participant
+
+	pingEvent := event.Event{
+		Type:    event.FocusCallPing,
+		Content: event.Content{},
+	}
+
+	heartbeat := participant.HeartbeatConfig{
+		Interval:  time.Duration(c.config.HeartbeatConfig.Interval) * time.Second,
+		Timeout:   time.Duration(c.config.HeartbeatConfig.Timeout) * time.Second,
+		SendPing:  func() bool { return p.SendDataChannelMessage(pingEvent) == nil },
+		OnTimeout: func() { messageSink.Send(peer.LeftTheCall{Reason: event.CallHangupKeepAliveTimeout}) },
+	}
+
+	p = &participant.Participant{
+		ID:              id,
+		Peer:            peerConnection,
+		Logger:          logger,
+		RemoteSessionID: remoteSessionID,
+		Pong:            heartbeat.Start(),
+
+		SubscriptionRequirements: make(map[participant.TrackID]participant.TrackMetadata),
+	}
+
+	return p
+}
+
 // Process new ICE candidates received from Matrix signaling (from the remote peer) and forward them to
 // our internal peer connection.
 func (c *Conference) onCandidates(id participant.ID, ev *event.CallCandidatesEventContent) {
@@ -117,6 +179,25 @@ func (c *Conference) onCandidates(id participant.ID, ev *event.CallCandidatesEve
 	}
 }
 
+// Process an SDP answer from a focus that we've linked to (i.e. that we've sent an invite to).
+func (c *Conference) onAnswer(id participant.ID, ev *event.CallAnswerEventContent) {
+	if participant := c.getParticipant(id); participant != nil {
+		if !participant.Outgoing {
+			participant.Logger.Warn("Ignoring an answer from a participant that we have not called")
+			return
+		}
+
+		participant.Logger.Info("Received remote answer")
+		participant.RemoteSessionID = ev.SenderSessionID
+		c.updateMetadata(ev.SDPStreamMetadata)
+
+		if err := participant.Peer.ProcessSDPAnswer(ev.Answer.SDP); err != nil {
+			participant.Logger.WithError(err).Error("Failed to process SDP answer, removing the link")
+			c.removeParticipant(id)
+		}
+	}
+}
+
 // Process an acknowledgement from the remote peer that the SDP answer has been received
 // and that the call can now proceed.
 func (c *Conference) onSelectAnswer(id participant.ID, ev *event.CallSelectAnswerEventContent) {
@@ -140,3 +221,52 @@ func (c *Conference) onHangup(id participant.ID, ev *event.CallHangupEventConten
 		c.removeParticipant(id)
 	}
 }
+
+// Process a renegotiation that the participant sends over Matrix since its data channel is not available.
+func (c *Conference) onNegotiate(id participant.ID, ev *event.CallNegotiateEventContent) {
+	if participant := c.getParticipant(id); participant != nil {
+		participant.Logger.Info("Received renegotiation over Matrix")
+		c.processNegotiateMessage(participant, event.FocusCallNegotiateEventContent{
+			Description:       ev.Description,
+			SDPStreamMetadata: ev.SDPStreamMetadata,
+		})
+	}
+}
+
+// Process a metadata update that the participant sends over Matrix since its data channel is not available.
+func (c *Conference) onMetadataChanged(id participant.ID, ev *signaling.CallSDPStreamMetadataChangedEventContent) {
+	if participant := c.getParticipant(id); participant != nil {
+		participant.Logger.Debug("Received metadata over Matrix")
+		c.processMetadataMessage(id, event.FocusCallSDPStreamMetadataChangedEventContent{
+			SDPStreamMetadata: ev.SDPStreamMetadata,
+		})
+	}
+}
+
+// Called by the matrix worker when some messages could not be delivered. Since the worker runs
+// in its own goroutine, the failures are handed over to the main loop of the conference.
+func (c *Conference) reportDeliveryFailure(failed []signaling.MatrixMessage) {
+	select {
+	case c.deliveryFailures <- failed:
+	default:
+		c.logger.Warnf("Dropping the report about %d undelivered messages", len(failed))
+	}
+}
+
+// Removes the participants that we could not deliver the signaling messages to. Without them the call
+// can't be established or kept alive anyway (e.g. an SDP answer got lost), so the participant is expected
+// to call us again.
+func (c *Conference) processDeliveryFailure(failed []signaling.MatrixMessage) {
+	for _, msg := range failed {
+		// The participant is gone anyway.
+		if _, ok := msg.Message.(signaling.Hangup); ok {
+			continue
+		}
+
+		id := participant.ID{UserID: msg.Recipient.UserID, DeviceID: msg.Recipient.DeviceID, CallID: msg.Recipient.CallID}
+		if p := c.tracker.GetParticipant(id); p != nil {
+			p.Logger.Warnf("Failed to deliver %T, removing the participant", msg.Message)
+			c.removeParticipant(id)
+		}
+	}
+}
@@ -1,46 +1,100 @@
 package conference
 
 import (
-	"time"
+	"sync"
 
 	"github.com/matrix-org/waterfall/pkg/signaling"
-	"github.com/matrix-org/waterfall/pkg/worker"
-	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/id"
 )
 
+// Sends the signaling messages of the conference in the background. The queue is unbounded, so that no message
+// gets lost if the homeserver is slow or temporarily unavailable. The messages that were queued while the previous
+// ones were being sent are handed over to the signaler at once, so that they can be sent in a single request.
 type matrixWorker struct {
-	worker   *worker.Worker[signaling.MatrixMessage]
+	signaler signaling.MatrixSignaler
+	userID   id.UserID
 	deviceID id.DeviceID
-}
 
-func newMatrixWorker(handler signaling.MatrixSignaler) *matrixWorker {
-	workerConfig := worker.Config[signaling.MatrixMessage]{
-		ChannelSize: 128,
-		Timeout:     time.Hour,
-		OnTimeout:   func() {},
-		OnTask:      func(msg signaling.MatrixMessage) { handler.SendMessage(msg) },
-	}
+	mutex   sync.Mutex
+	queue   []signaling.MatrixMessage
+	stopped bool
+	wakeup  chan struct{}
+	// Closed once the worker is stopped and all queued messages are sent.
+	done chan struct{}
 
+	// Called (from the worker) with the messages that could not be delivered.
+	onFailure func([]signaling.MatrixMessage)
+}
+
+func newMatrixWorker(handler signaling.MatrixSignaler, onFailure func([]signaling.MatrixMessage)) *matrixWorker {
 	matrixWorker := &matrixWorker{
-		worker:   worker.StartWorker(workerConfig),
-		deviceID: handler.DeviceID(),
+		signaler:  handler,
+		userID:    handler.UserID(),
+		deviceID:  handler.DeviceID(),
+		wakeup:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		onFailure: onFailure,
 	}
 
+	go matrixWorker.run()
+
 	return matrixWorker
 }
 
+// Returns the focus that this worker sends the messages on behalf of (i.e. us).
+func (w *matrixWorker) focus() signaling.Focus {
+	return signaling.Focus{UserID: w.userID, DeviceID: w.deviceID}
+}
+
+// Stops the worker once all queued messages are sent.
 func (w *matrixWorker) stop() {
-	w.worker.Stop()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.stopped = true
+	w.notify()
 }
 
 func (w *matrixWorker) sendSignalingMessage(recipient signaling.MatrixRecipient, content interface{}) {
-	msg := signaling.MatrixMessage{
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped {
+		return
+	}
+
+	w.queue = append(w.queue, signaling.MatrixMessage{
 		Recipient: recipient,
 		Message:   content,
+	})
+	w.notify()
+}
+
+func (w *matrixWorker) notify() {
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
 	}
+}
+
+func (w *matrixWorker) run() {
+	defer close(w.done)
+
+	for range w.wakeup {
+		w.mutex.Lock()
+		messages, stopped := w.queue, w.stopped
+		w.queue = nil
+		w.mutex.Unlock()
+
+		if len(messages) > 0 {
+			if failed := w.signaler.SendMessages(messages); len(failed) > 0 {
+				w.onFailure(failed)
+			}
+		}
 
-	if err := w.worker.Send(msg); err != nil {
-		logrus.Errorf("Really bad, dropping matrix message since the matrix queue is full! Home server down? %s", err)
+		// No messages are queued after the worker is stopped, so we're done.
+		if stopped {
+			return
+		}
 	}
 }
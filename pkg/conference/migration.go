@@ -0,0 +1,199 @@
+package conference
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"maunium.net/go/mautrix/event"
+)
+
+// How long the participants have to move to the new focus before we hang up on them.
+const migrationTimeout = time.Minute
+
+// Starts moving the conference to another focus. The new focus is told which participants are going to call it
+// and what they are subscribed to (the handoff), so that it accepts them and restores their subscriptions. Once it
+// knows, the participants are asked to renegotiate with it and leave us once they've moved. If both foci are
+// cascaded, the participants that already moved and the ones that did not move yet can see each other meanwhile.
+func (c *Conference) migrate(focus signaling.Focus) {
+	if c.migratingTo != nil && *c.migratingTo == focus {
+		c.logger.Infof("The conference is already moving to %s (%s)", focus.UserID, focus.DeviceID)
+		return
+	}
+
+	c.logger.Infof("Migrating the conference to %s (%s)", focus.UserID, focus.DeviceID)
+
+	c.migratingTo = &focus
+	c.migrationDeadline = time.After(migrationTimeout)
+
+	handedOver := []signaling.HandoffParticipant{}
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if !p.FocusLink {
+			handedOver = append(handedOver, c.handoffOf(p))
+		}
+	})
+
+	// The participants must not call the new focus before it knows about them, otherwise it would send them
+	// back to us (the room state names us until they've moved), so the handoff is not queued with the rest.
+	handoff := signaling.MatrixMessage{
+		Recipient: signaling.MatrixRecipient{UserID: focus.UserID, DeviceID: focus.DeviceID},
+		Message:   signaling.Handoff{Participants: handedOver},
+	}
+	go func() {
+		c.handoffResults <- len(c.matrixWorker.signaler.SendMessages([]signaling.MatrixMessage{handoff})) == 0
+	}()
+}
+
+// Describes a participant and its subscriptions for the focus that it's handed over to.
+func (c *Conference) handoffOf(p *participant.Participant) signaling.HandoffParticipant {
+	subscriptions := []signaling.HandoffSubscription{}
+	c.tracker.ForEachSubscribedTrackInfo(p.ID, func(info webrtc_ext.TrackInfo) {
+		requirements := p.SubscriptionRequirements[info.TrackID]
+		subscriptions = append(subscriptions, signaling.HandoffSubscription{
+			TrackID:      info.TrackID,
+			Width:        requirements.MaxWidth,
+			Height:       requirements.MaxHeight,
+			MaxFrameRate: requirements.MaxFrameRate,
+		})
+	})
+
+	return signaling.HandoffParticipant{
+		UserID:        p.ID.UserID,
+		DeviceID:      p.ID.DeviceID,
+		Subscriptions: subscriptions,
+	}
+}
+
+// Asks the participants to move once the new focus knows about them. The participants that can't renegotiate
+// with another focus are hung up right away, the hangup tells them which focus to call instead.
+func (c *Conference) onHandoffSent(delivered bool) {
+	if !delivered {
+		c.logger.Warn("The new focus did not get the handoff, it may send the participants back to us")
+	}
+
+	incapable := []*participant.Participant{}
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if p.FocusLink {
+			return
+		}
+
+		if p.Capabilities.SupportsMigration() {
+			c.sendMigrationRequest(p)
+		} else {
+			incapable = append(incapable, p)
+		}
+	})
+
+	c.hangUpOn(incapable, func(p *participant.Participant) signaling.Hangup {
+		p.Logger.Info("Participant does not support the migration, hanging up")
+		return signaling.Hangup{Reason: signaling.CallHangupFocusMismatch, Focus: c.migratingTo}
+	})
+}
+
+// Asks the participant to renegotiate the call with another focus. It's the usual `m.call.negotiate`, but instead
+// of an SDP it names the focus that the participant must call (the same way as it has called us).
+func (c *Conference) sendMigrationRequest(p *participant.Participant) {
+	p.Logger.Info("Asking the participant to move to another focus")
+	p.SendDataChannelMessage(event.Event{
+		Type: event.FocusCallNegotiate,
+		Content: event.Content{
+			Parsed: event.FocusCallNegotiateEventContent{
+				SDPStreamMetadata: c.getAvailableStreamsFor(p.ID),
+			},
+			Raw: map[string]interface{}{"focus": c.migratingTo},
+		},
+	})
+}
+
+// Hangs up on the participants that did not move to the new focus in time.
+func (c *Conference) onMigrationDeadline() {
+	c.logger.Warn("Migration timed out, hanging up on the remaining participants")
+
+	remaining := []*participant.Participant{}
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		if !p.FocusLink {
+			remaining = append(remaining, p)
+		}
+	})
+
+	c.hangUpOn(remaining, func(*participant.Participant) signaling.Hangup {
+		return signaling.Hangup{Reason: signaling.CallHangupFocusMismatch, Focus: c.migratingTo}
+	})
+}
+
+// Subscribes a participant that another focus has handed over to us to the tracks that it has been subscribed
+// to there. The tracks whose owners have not moved to us yet are subscribed to once they're published.
+func (c *Conference) restoreSubscriptions(id participant.ID, subscriptions []signaling.HandoffSubscription) {
+	p := c.getParticipant(id)
+	if p == nil {
+		return
+	}
+
+	p.Logger.Infof("Restoring %d subscriptions of the participant", len(subscriptions))
+
+	pending := []signaling.HandoffSubscription{}
+	for _, subscription := range subscriptions {
+		if !c.restoreSubscription(p, subscription) {
+			pending = append(pending, subscription)
+		}
+	}
+
+	if len(pending) > 0 {
+		c.pendingSubscriptions[id] = pending
+	}
+}
+
+// Restores the subscriptions to a track that has just been published.
+func (c *Conference) restorePendingSubscriptions(trackID participant.TrackID) {
+	for id, subscriptions := range c.pendingSubscriptions {
+		p := c.tracker.GetParticipant(id)
+		if p == nil {
+			delete(c.pendingSubscriptions, id)
+			continue
+		}
+
+		pending := []signaling.HandoffSubscription{}
+		for _, subscription := range subscriptions {
+			if subscription.TrackID != trackID || !c.restoreSubscription(p, subscription) {
+				pending = append(pending, subscription)
+			}
+		}
+
+		if len(pending) == 0 {
+			delete(c.pendingSubscriptions, id)
+		} else {
+			c.pendingSubscriptions[id] = pending
+		}
+	}
+}
+
+// Subscribes a participant to a track as it has been subscribed to it on another focus.
+// Returns false if the track is not published (yet).
+func (c *Conference) restoreSubscription(p *participant.Participant, subscription signaling.HandoffSubscription) bool {
+	if !c.isPublished(subscription.TrackID) {
+		return false
+	}
+
+	requirements := participant.TrackMetadata{
+		MaxWidth:     subscription.Width,
+		MaxHeight:    subscription.Height,
+		MaxFrameRate: subscription.MaxFrameRate,
+	}
+	if err := c.tracker.Subscribe(p.ID, subscription.TrackID, requirements); err != nil {
+		p.Logger.Errorf("Failed to restore the subscription to track %s: %v", subscription.TrackID, err)
+		return true
+	}
+
+	p.SubscriptionRequirements[subscription.TrackID] = requirements
+	return true
+}
+
+func (c *Conference) isPublished(trackID participant.TrackID) bool {
+	published := false
+	c.tracker.ForEachPublishedTrackInfo(func(_ participant.ID, info webrtc_ext.TrackInfo) {
+		published = published || info.TrackID == trackID
+	})
+
+	return published
+}
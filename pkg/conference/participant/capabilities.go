@@ -0,0 +1,55 @@
+package participant
+
+import (
+	"golang.org/x/exp/slices"
+	"maunium.net/go/mautrix/event"
+)
+
+// Types of the data channel messages of the first version of the protocol, i.e. the ones
+// that the older clients (that don't advertise their capabilities) understand.
+var V1MessageTypes = []string{
+	event.FocusCallTrackSubscription.Type,
+	event.FocusCallNegotiate.Type,
+	event.FocusCallSDPStreamMetadataChanged.Type,
+	event.FocusCallPing.Type,
+	event.FocusCallPong.Type,
+}
+
+// Features of the focus protocol (data channel messages) that a participant or the SFU supports.
+// Both sides advertise them once the data channel is open.
+type Capabilities struct {
+	// Version of the protocol.
+	Version int `json:"version"`
+	// Types of the data channel messages that are understood.
+	MessageTypes []string `json:"message_types"`
+	// Whether simulcast is supported.
+	Simulcast bool `json:"simulcast"`
+	// MIME types of the supported codecs, e.g. "video/VP8".
+	Codecs []string `json:"codecs"`
+	// Whether the sender calls another focus if `m.call.negotiate` names one (instead of carrying an SDP).
+	Migration bool `json:"migration,omitempty"`
+	// Limits of the sender (if any).
+	Limits CapabilityLimits `json:"limits"`
+}
+
+type CapabilityLimits struct {
+	// How many data channel messages are kept while the data channel is not available.
+	MaxQueuedMessages int `json:"max_queued_messages,omitempty"`
+	// How long (in seconds) the sender waits for a pong before hanging up.
+	KeepAliveTimeout int `json:"keep_alive_timeout,omitempty"`
+}
+
+// Checks if a given data channel message is understood. The participants that have not advertised
+// their capabilities (older clients) are assumed to understand the messages of the first version.
+func (c *Capabilities) Supports(messageType string) bool {
+	if c == nil {
+		return slices.Contains(V1MessageTypes, messageType)
+	}
+
+	return slices.Contains(c.MessageTypes, messageType)
+}
+
+// Checks if the participant can be asked to move to another focus.
+func (c *Capabilities) SupportsMigration() bool {
+	return c != nil && c.Migration
+}
@@ -0,0 +1,38 @@
+package participant_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestCapabilitiesSupports(t *testing.T) {
+	cases := []struct {
+		name         string
+		capabilities *participant.Capabilities
+		messageType  string
+		supported    bool
+	}{
+		{"no capabilities, first version", nil, event.FocusCallNegotiate.Type, true},
+		{"no capabilities, newer message", nil, "m.call.capabilities", false},
+		{
+			"advertised",
+			&participant.Capabilities{MessageTypes: []string{"m.call.capabilities"}},
+			"m.call.capabilities",
+			true,
+		},
+		{
+			"not advertised",
+			&participant.Capabilities{MessageTypes: []string{event.FocusCallPing.Type}},
+			event.FocusCallNegotiate.Type,
+			false,
+		},
+	}
+
+	for _, testCase := range cases {
+		if supported := testCase.capabilities.Supports(testCase.messageType); supported != testCase.supported {
+			t.Errorf("%s: expected %v, got %v", testCase.name, testCase.supported, supported)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package participant
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix/event"
+)
+
+// The maximal number of messages that are kept while the data channel is unavailable.
+const MaxQueuedDataChannelMessages = 64
+
+var ErrDataChannelMessageQueued = errors.New("data channel unavailable, message queued")
+
+// Messages of these types are superseded by the newer ones, so only the latest one is kept in the queue.
+var coalescedDataChannelMessages = map[string]bool{
+	event.FocusCallSDPStreamMetadataChanged.Type: true,
+}
+
+// Messages of these types are of no use later (e.g. a late pong would only distort the heartbeat),
+// so they are dropped instead of being queued.
+var droppedDataChannelMessages = map[string]bool{
+	event.FocusCallPing.Type: true,
+	event.FocusCallPong.Type: true,
+}
+
+type queuedDataChannelMessage struct {
+	eventType string
+	json      string
+}
+
+// Messages that could not be sent since the data channel was not available (yet).
+// The data channel is passed as a function that sends a message over it.
+type dataChannelQueue struct {
+	messages []queuedDataChannelMessage
+}
+
+// Sends a message after the queued ones. If the data channel is not available, the message is queued
+// (and `ErrDataChannelMessageQueued` is returned) unless it's of no use later.
+func (q *dataChannelQueue) send(message queuedDataChannelMessage, send func(string) error, logger *logrus.Entry) error {
+	if droppedDataChannelMessages[message.eventType] {
+		return q.trySend(message, send)
+	}
+
+	// The message is queued even if the data channel is available, so that it's not sent before the older ones.
+	q.enqueue(message, logger)
+	if err := q.flush(send); err != nil {
+		logger.WithError(err).Debugf("Queued %d data channel messages", len(q.messages))
+		return ErrDataChannelMessageQueued
+	}
+
+	return nil
+}
+
+// Sends a message after the queued ones if the data channel is available right now. The message is not queued
+// otherwise and the queued messages of the same type are dropped, so that they don't arrive after the one that
+// replaces them.
+func (q *dataChannelQueue) trySend(message queuedDataChannelMessage, send func(string) error) error {
+	if err := q.flush(send); err != nil {
+		q.drop(message.eventType)
+		return err
+	}
+
+	return send(message.json)
+}
+
+// Adds a message to the queue, replacing the message that it supersedes (if any).
+// The oldest message is dropped if the queue is full.
+func (q *dataChannelQueue) enqueue(message queuedDataChannelMessage, logger *logrus.Entry) {
+	if coalescedDataChannelMessages[message.eventType] {
+		for i, queued := range q.messages {
+			if queued.eventType == message.eventType {
+				q.messages = append(q.messages[:i], q.messages[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if len(q.messages) == MaxQueuedDataChannelMessages {
+		logger.WithField("type", q.messages[0].eventType).Warn("Data channel queue is full, dropping message")
+		q.messages = q.messages[1:]
+	}
+
+	q.messages = append(q.messages, message)
+}
+
+// Removes the queued messages of a given type.
+func (q *dataChannelQueue) drop(eventType string) {
+	remaining := q.messages[:0]
+	for _, queued := range q.messages {
+		if queued.eventType != eventType {
+			remaining = append(remaining, queued)
+		}
+	}
+
+	q.messages = remaining
+}
+
+// Sends the queued messages in order until the data channel fails.
+func (q *dataChannelQueue) flush(send func(string) error) error {
+	for len(q.messages) > 0 {
+		if err := send(q.messages[0].json); err != nil {
+			return err
+		}
+
+		q.messages = q.messages[1:]
+	}
+
+	return nil
+}
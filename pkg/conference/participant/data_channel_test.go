@@ -0,0 +1,168 @@
+package participant_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"golang.org/x/exp/slices"
+	"maunium.net/go/mautrix/event"
+)
+
+var errClosed = errors.New("data channel closed")
+
+// Data channel that records the sent messages while it's open.
+type fakeDataChannel struct {
+	open bool
+	sent []string
+}
+
+func (c *fakeDataChannel) send(json string) error {
+	if !c.open {
+		return errClosed
+	}
+
+	c.sent = append(c.sent, json)
+	return nil
+}
+
+// A message sent over the data channel (`try` is set if it must not be queued).
+type message struct {
+	eventType string
+	json      string
+	try       bool
+}
+
+func TestDataChannelQueue(t *testing.T) {
+	metadata := event.FocusCallSDPStreamMetadataChanged.Type
+	negotiate := event.FocusCallNegotiate.Type
+	ping := event.FocusCallPing.Type
+	pong := event.FocusCallPong.Type
+
+	many := []message{}
+	for i := 0; i < participant.MaxQueuedDataChannelMessages+2; i++ {
+		many = append(many, message{eventType: negotiate, json: strconv.Itoa(i)})
+	}
+
+	cases := []struct {
+		name string
+		// Messages sent while the data channel is closed.
+		whileClosed []message
+		// Messages sent once the data channel is open again.
+		whileOpen []message
+		// The messages that the remote peer gets once the data channel is open.
+		expected []string
+	}{
+		{
+			name:        "queued messages are sent in order",
+			whileClosed: []message{{eventType: negotiate, json: "1"}, {eventType: metadata, json: "2"}},
+			whileOpen:   []message{{eventType: negotiate, json: "3"}},
+			expected:    []string{"1", "2", "3"},
+		},
+		{
+			name:        "flushed once the data channel is available",
+			whileClosed: []message{{eventType: negotiate, json: "1"}, {eventType: negotiate, json: "2"}},
+			expected:    []string{"1", "2"},
+		},
+		{
+			name: "metadata is coalesced",
+			whileClosed: []message{
+				{eventType: metadata, json: "old metadata"},
+				{eventType: negotiate, json: "negotiate"},
+				{eventType: metadata, json: "new metadata"},
+			},
+			expected: []string{"negotiate", "new metadata"},
+		},
+		{
+			name:        "negotiation is not coalesced",
+			whileClosed: []message{{eventType: negotiate, json: "1"}, {eventType: negotiate, json: "2"}},
+			whileOpen:   []message{{eventType: negotiate, json: "3"}},
+			expected:    []string{"1", "2", "3"},
+		},
+		{
+			name:        "the oldest messages are dropped on overflow",
+			whileClosed: many,
+			expected:    jsonOf(many[2:]),
+		},
+		{
+			name:        "pings and pongs are dropped",
+			whileClosed: []message{{eventType: ping, json: "ping"}, {eventType: pong, json: "pong"}},
+			whileOpen:   []message{{eventType: ping, json: "new ping"}},
+			expected:    []string{"new ping"},
+		},
+		{
+			name: "unqueued messages replace the queued ones",
+			whileClosed: []message{
+				{eventType: metadata, json: "queued metadata"},
+				{eventType: negotiate, json: "negotiate"},
+				{eventType: metadata, json: "sent over Matrix", try: true},
+			},
+			expected: []string{"negotiate"},
+		},
+		{
+			name:        "unqueued messages are sent after the queued ones",
+			whileClosed: []message{{eventType: negotiate, json: "1"}},
+			whileOpen:   []message{{eventType: metadata, json: "2", try: true}},
+			expected:    []string{"1", "2"},
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			queue := participant.NewDataChannelQueue()
+			channel := &fakeDataChannel{}
+
+			send := func(msg message) error {
+				if msg.try {
+					return queue.TrySend(msg.eventType, msg.json, channel.send)
+				}
+
+				return queue.Send(msg.eventType, msg.json, channel.send)
+			}
+
+			for _, msg := range testCase.whileClosed {
+				err := send(msg)
+
+				queued := !msg.try && msg.eventType != ping && msg.eventType != pong
+				if queued && !errors.Is(err, participant.ErrDataChannelMessageQueued) {
+					t.Fatalf("expected %s to be queued, got %v", msg.json, err)
+				} else if !queued && !errors.Is(err, errClosed) {
+					t.Fatalf("expected %s not to be queued, got %v", msg.json, err)
+				}
+			}
+
+			if len(channel.sent) != 0 {
+				t.Fatalf("sent %v while the data channel was closed", channel.sent)
+			}
+
+			channel.open = true
+			if err := queue.Flush(channel.send); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, msg := range testCase.whileOpen {
+				if err := send(msg); err != nil {
+					t.Fatalf("failed to send %s: %v", msg.json, err)
+				}
+			}
+
+			if !slices.Equal(channel.sent, testCase.expected) {
+				t.Errorf("expected %v, got %v", testCase.expected, channel.sent)
+			}
+
+			if queue.Len() != 0 {
+				t.Errorf("expected an empty queue, got %d messages", queue.Len())
+			}
+		})
+	}
+}
+
+func jsonOf(messages []message) []string {
+	json := []string{}
+	for _, msg := range messages {
+		json = append(json, msg.json)
+	}
+
+	return json
+}
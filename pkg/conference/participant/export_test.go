@@ -0,0 +1,29 @@
+package participant
+
+import "github.com/sirupsen/logrus"
+
+// Exposes the queue of the data channel messages to the tests.
+type DataChannelQueue struct {
+	queue *dataChannelQueue
+}
+
+func NewDataChannelQueue() DataChannelQueue {
+	return DataChannelQueue{queue: &dataChannelQueue{}}
+}
+
+func (q DataChannelQueue) Send(eventType, json string, send func(string) error) error {
+	message := queuedDataChannelMessage{eventType: eventType, json: json}
+	return q.queue.send(message, send, logrus.NewEntry(logrus.StandardLogger()))
+}
+
+func (q DataChannelQueue) TrySend(eventType, json string, send func(string) error) error {
+	return q.queue.trySend(queuedDataChannelMessage{eventType: eventType, json: json}, send)
+}
+
+func (q DataChannelQueue) Flush(send func(string) error) error {
+	return q.queue.flush(send)
+}
+
+func (q DataChannelQueue) Len() int {
+	return len(q.queue.messages)
+}
@@ -2,6 +2,7 @@ package participant
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
@@ -25,6 +26,20 @@ type Participant struct {
 	Peer            *peer.Peer[ID]
 	RemoteSessionID id.SessionID
 	Pong            chan<- Pong
+	// Set if the participant is not an end-user, but another focus (SFU) that the conference is cascaded with.
+	FocusLink bool
+	// Set if the link to the other focus has been initiated by us (only relevant for focus links).
+	Outgoing bool
+	// The capabilities that the participant has advertised (nil for the older clients that don't do it).
+	Capabilities *Capabilities
+	// The requirements that the participant has asked for when subscribing to the tracks
+	// (to pass them on if the participant is handed over to another focus).
+	SubscriptionRequirements map[TrackID]TrackMetadata
+
+	// Messages that could not be sent since the data channel was not available (yet).
+	// Guarded by the mutex since the pings are sent from another goroutine.
+	dataChannelMutex sync.Mutex
+	dataChannelQueue dataChannelQueue
 }
 
 func (p *Participant) AsMatrixRecipient() signaling.MatrixRecipient {
@@ -36,16 +51,44 @@ func (p *Participant) AsMatrixRecipient() signaling.MatrixRecipient {
 	}
 }
 
+// Sends a message over the data channel. If the data channel is not available, the message is queued
+// (and `ErrDataChannelMessageQueued` is returned) until the data channel becomes available again.
+// Pings and pongs are never queued, they're only sent if the data channel is available right now.
 func (p *Participant) SendDataChannelMessage(toSend event.Event) error {
 	jsonToSend, err := toSend.MarshalJSON()
 	if err != nil {
 		return fmt.Errorf("Failed to marshal data channel message: %w", err)
 	}
 
-	if err := p.Peer.SendOverDataChannel(string(jsonToSend)); err != nil {
-		// TODO: We must buffer the message in this case and re-send it once the data channel is recovered!
-		return fmt.Errorf("Failed to send data channel message: %w", err)
+	p.dataChannelMutex.Lock()
+	defer p.dataChannelMutex.Unlock()
+
+	message := queuedDataChannelMessage{eventType: toSend.Type.Type, json: string(jsonToSend)}
+	return p.dataChannelQueue.send(message, p.Peer.SendOverDataChannel, p.Logger)
+}
+
+// Sends a message over the data channel if it's available right now. Unlike `SendDataChannelMessage()`,
+// the message is not queued otherwise, the caller is expected to deliver it in another way. The queued
+// messages of the same type are dropped then, so that they don't arrive after the one that replaces them.
+func (p *Participant) TrySendDataChannelMessage(toSend event.Event) error {
+	jsonToSend, err := toSend.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("Failed to marshal data channel message: %w", err)
 	}
 
-	return nil
+	p.dataChannelMutex.Lock()
+	defer p.dataChannelMutex.Unlock()
+
+	message := queuedDataChannelMessage{eventType: toSend.Type.Type, json: string(jsonToSend)}
+	return p.dataChannelQueue.trySend(message, p.Peer.SendOverDataChannel)
+}
+
+// Sends the messages that were queued while the data channel was unavailable.
+func (p *Participant) FlushDataChannelMessages() {
+	p.dataChannelMutex.Lock()
+	defer p.dataChannelMutex.Unlock()
+
+	if err := p.dataChannelQueue.flush(p.Peer.SendOverDataChannel); err != nil {
+		p.Logger.WithError(err).Warnf("Failed to send %d queued data channel messages", len(p.dataChannelQueue.messages))
+	}
 }
@@ -1,22 +1,35 @@
 package participant
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"golang.org/x/exp/slices"
 )
 
 type TrackID = string
 
+// The subscriber can't decode any of the codecs that the track is published with.
+var ErrIncompatibleCodec = errors.New("no compatible codec")
+
 // Represents a track that a peer has published (has already started sending to the SFU).
 type PublishedTrack struct {
 	// Owner of a published track.
 	Owner ID
 	// Info about the track.
 	Info webrtc_ext.TrackInfo
-	// Available simulcast Layers.
+	// Available simulcast Layers. For an SVC track these are the simulcast layers that its spatial layers map onto.
 	Layers []webrtc_ext.SimulcastLayer
+	// Number of the spatial layers if the track is sent as a single SVC stream (0 if not known or not SVC).
+	SpatialLayers int
+	// Codecs of the simulcast layers (the publisher may send different layers with different codecs).
+	LayerCodecs map[webrtc_ext.SimulcastLayer]webrtc.RTPCodecCapability
 	// Track metadata.
 	Metadata TrackMetadata
 	// Output track (if any). I.e. a track that would contain all RTP packets
@@ -24,13 +37,108 @@ type PublishedTrack struct {
 	OutputTrack *webrtc.TrackLocalStaticRTP
 	// All available subscriptions for this particular track.
 	Subscriptions map[ID]subscription.Subscription
+	// Parser of the layers of a video track without simulcast, used to detect the SVC streams.
+	layerParser *rewriter.LayerParser
+}
+
+// Learns the spatial layers of an SVC stream from its packets. Returns true if they have changed.
+func (p *PublishedTrack) processSVCPacket(packet *rtp.Packet) bool {
+	if p.layerParser == nil {
+		return false
+	}
+
+	p.layerParser.Parse(packet)
+
+	spatialLayers := p.layerParser.SpatialLayers()
+	if spatialLayers == 0 || spatialLayers == p.SpatialLayers {
+		return false
+	}
+
+	p.SpatialLayers = spatialLayers
+	p.Layers = []webrtc_ext.SimulcastLayer{}
+
+	// A single spatial layer is just a regular track without any layers to select.
+	if spatialLayers > 1 {
+		for spatialLayer := 0; spatialLayer < spatialLayers; spatialLayer++ {
+			layer := webrtc_ext.SpatialLayerToSimulcastLayer(spatialLayer, spatialLayers)
+			if !slices.Contains(p.Layers, layer) {
+				p.Layers = append(p.Layers, layer)
+			}
+		}
+	}
+
+	return true
 }
 
 // Calculate the layer that we can use based on the requirements passed as parameters and available layers.
 func (p *PublishedTrack) GetOptimalLayer(requestedWidth, requestedHeight int) webrtc_ext.SimulcastLayer {
+	return p.getOptimalLayer(p.Layers, requestedWidth, requestedHeight)
+}
+
+// Returns the codec of a given simulcast layer.
+func (p *PublishedTrack) Codec(layer webrtc_ext.SimulcastLayer) webrtc.RTPCodecCapability {
+	if codec, found := p.LayerCodecs[layer]; found {
+		return codec
+	}
+
+	return p.Info.Codec
+}
+
+// Returns the simulcast layers that are sent with a given codec.
+func (p *PublishedTrack) LayersWithCodec(mimeType string) []webrtc_ext.SimulcastLayer {
+	layers := []webrtc_ext.SimulcastLayer{}
+	for _, layer := range p.Layers {
+		if strings.EqualFold(p.Codec(layer).MimeType, mimeType) {
+			layers = append(layers, layer)
+		}
+	}
+
+	return layers
+}
+
+// Returns the simulcast layers that a subscriber that supports given codecs can decode (all layers if the codecs
+// are not known). Returns `ErrIncompatibleCodec` if the subscriber can't decode the track at all.
+func (p *PublishedTrack) DecodableLayers(codecs []string) ([]webrtc_ext.SimulcastLayer, error) {
+	if codecs == nil {
+		return p.Layers, nil
+	}
+
+	supported := func(layer webrtc_ext.SimulcastLayer) bool {
+		return slices.IndexFunc(codecs, func(codec string) bool {
+			return strings.EqualFold(codec, p.Codec(layer).MimeType)
+		}) != -1
+	}
+
+	if len(p.Layers) == 0 {
+		if !supported(webrtc_ext.SimulcastLayerNone) {
+			return nil, fmt.Errorf("%w: %s", ErrIncompatibleCodec, p.Info.Codec.MimeType)
+		}
+
+		return p.Layers, nil
+	}
+
+	layers := []webrtc_ext.SimulcastLayer{}
+	for _, layer := range p.Layers {
+		if supported(layer) {
+			layers = append(layers, layer)
+		}
+	}
+
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("%w: none of the layers is sent with %s", ErrIncompatibleCodec, strings.Join(codecs, ", "))
+	}
+
+	return layers, nil
+}
+
+// Calculate the optimal layer out of the given ones.
+func (p *PublishedTrack) getOptimalLayer(
+	layers []webrtc_ext.SimulcastLayer,
+	requestedWidth, requestedHeight int,
+) webrtc_ext.SimulcastLayer {
 	// Audio track. For them we don't have any simulcast. We also don't have any simulcast for video
-	// if there was no simulcast enabled at all.
-	if p.Info.Kind == webrtc.RTPCodecTypeAudio || len(p.Layers) == 0 {
+	// if there was no simulcast enabled at all (unless the video is an SVC stream with multiple spatial layers).
+	if p.Info.Kind == webrtc.RTPCodecTypeAudio || len(layers) == 0 {
 		return webrtc_ext.SimulcastLayerNone
 	}
 
@@ -48,12 +156,12 @@ func (p *PublishedTrack) GetOptimalLayer(requestedWidth, requestedHeight int) we
 
 	// More Go boilerplate.
 	for _, desiredLayer := range priority {
-		layerIndex := slices.IndexFunc(p.Layers, func(simulcast webrtc_ext.SimulcastLayer) bool {
+		layerIndex := slices.IndexFunc(layers, func(simulcast webrtc_ext.SimulcastLayer) bool {
 			return simulcast == desiredLayer
 		})
 
 		if layerIndex != -1 {
-			return p.Layers[layerIndex]
+			return layers[layerIndex]
 		}
 	}
 
@@ -66,6 +174,8 @@ func (p *PublishedTrack) GetOptimalLayer(requestedWidth, requestedHeight int) we
 // This metadata is only set for video tracks at the moment.
 type TrackMetadata struct {
 	MaxWidth, MaxHeight int
+	// Maximum frame rate (0 if not limited).
+	MaxFrameRate int
 }
 
 // Calculates the optimal layer closest to the requested resolution. We assume that the full resolution is the
@@ -1,11 +1,13 @@
 package participant_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/webrtc/v3"
+	"golang.org/x/exp/slices"
 )
 
 func TestGetOptimalLayer(t *testing.T) {
@@ -73,3 +75,113 @@ func TestGetOptimalLayerAudio(t *testing.T) {
 		t.Fatal("Expected no simulcast layer for audio")
 	}
 }
+
+func TestGetOptimalLayerSVC(t *testing.T) {
+	cases := []struct {
+		spatialLayers               int
+		desiredWidth, desiredHeight int
+		expectedSpatialLayer        int
+	}{
+		{3, 1280, 720, 2},
+		{3, 640, 480, 1},
+		{3, 320, 240, 0},
+		{2, 1280, 720, 1},
+		{2, 640, 480, 0},
+		{2, 320, 240, 0},
+		{1, 1280, 720, 0},
+	}
+
+	for _, c := range cases {
+		// The spatial layers of an SVC track are offered as the simulcast layers that they map onto.
+		mock := participant.PublishedTrack{
+			Info:     webrtc_ext.TrackInfo{Kind: webrtc.RTPCodecTypeVideo},
+			Metadata: participant.TrackMetadata{MaxWidth: 1280, MaxHeight: 720},
+		}
+
+		for spatialLayer := 0; spatialLayer < c.spatialLayers; spatialLayer++ {
+			mock.Layers = append(mock.Layers, webrtc_ext.SpatialLayerToSimulcastLayer(spatialLayer, c.spatialLayers))
+		}
+
+		optimalLayer := mock.GetOptimalLayer(c.desiredWidth, c.desiredHeight)
+		spatialLayer := webrtc_ext.SimulcastLayerToSpatialLayer(optimalLayer, c.spatialLayers)
+		if spatialLayer != c.expectedSpatialLayer {
+			t.Errorf("%d spatial layers, %dx%d: expected spatial layer %d, got %d (%s)",
+				c.spatialLayers, c.desiredWidth, c.desiredHeight, c.expectedSpatialLayer, spatialLayer, optimalLayer)
+		}
+	}
+}
+
+func TestDecodableLayers(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+	vp8 := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}
+	av1 := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1}
+
+	// The low layer is sent with VP8, the others with AV1.
+	mixed := participant.PublishedTrack{
+		Info:        webrtc_ext.TrackInfo{Kind: webrtc.RTPCodecTypeVideo, Codec: av1},
+		Layers:      []webrtc_ext.SimulcastLayer{low, mid, high},
+		LayerCodecs: map[webrtc_ext.SimulcastLayer]webrtc.RTPCodecCapability{low: vp8, mid: av1, high: av1},
+	}
+
+	// No simulcast, the codec of the track is the only one.
+	single := participant.PublishedTrack{
+		Info: webrtc_ext.TrackInfo{Kind: webrtc.RTPCodecTypeVideo, Codec: vp8},
+	}
+
+	cases := []struct {
+		name     string
+		track    participant.PublishedTrack
+		codecs   []string
+		expected []webrtc_ext.SimulcastLayer
+		err      error
+	}{
+		{"unknown codecs", mixed, nil, []webrtc_ext.SimulcastLayer{low, mid, high}, nil},
+		{"all codecs", mixed, []string{"video/vp8", "video/AV1"}, []webrtc_ext.SimulcastLayer{low, mid, high}, nil},
+		{"some layers", mixed, []string{"video/VP8"}, []webrtc_ext.SimulcastLayer{low}, nil},
+		{"no layers", mixed, []string{"video/H264"}, nil, participant.ErrIncompatibleCodec},
+		{"no codecs", mixed, []string{}, nil, participant.ErrIncompatibleCodec},
+		{"no simulcast", single, []string{"video/VP8"}, nil, nil},
+		{"no simulcast, incompatible", single, []string{"video/AV1"}, nil, participant.ErrIncompatibleCodec},
+		{"no simulcast, unknown codecs", single, nil, nil, nil},
+	}
+
+	for _, c := range cases {
+		layers, err := c.track.DecodableLayers(c.codecs)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected error %v, got %v", c.name, c.err, err)
+			continue
+		}
+
+		if !slices.Equal(layers, c.expected) {
+			t.Errorf("%s: expected layers %v, got %v", c.name, c.expected, layers)
+		}
+	}
+}
+
+func TestLayersWithCodec(t *testing.T) {
+	low, mid, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerMedium, webrtc_ext.SimulcastLayerHigh
+
+	track := participant.PublishedTrack{
+		Info:   webrtc_ext.TrackInfo{Kind: webrtc.RTPCodecTypeVideo, Codec: webrtc.RTPCodecCapability{MimeType: "video/VP8"}},
+		Layers: []webrtc_ext.SimulcastLayer{low, mid, high},
+		// The layers without their own codec are sent with the codec of the track.
+		LayerCodecs: map[webrtc_ext.SimulcastLayer]webrtc.RTPCodecCapability{
+			high: {MimeType: webrtc.MimeTypeAV1},
+		},
+	}
+
+	cases := []struct {
+		mimeType string
+		expected []webrtc_ext.SimulcastLayer
+	}{
+		{"video/VP8", []webrtc_ext.SimulcastLayer{low, mid}},
+		{"video/av1", []webrtc_ext.SimulcastLayer{high}},
+		{"video/H264", []webrtc_ext.SimulcastLayer{}},
+	}
+
+	for _, c := range cases {
+		if layers := track.LayersWithCodec(c.mimeType); !slices.Equal(layers, c.expected) {
+			t.Errorf("%s: expected layers %v, got %v", c.mimeType, c.expected, layers)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/matrix-org/waterfall/pkg/conference/subscription"
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
@@ -39,6 +40,17 @@ func (t *Tracker) HasParticipants() bool {
 	return len(t.participants) != 0
 }
 
+// Checks if there are any end-users in the conference, i.e. participants that are not links to other foci.
+func (t *Tracker) HasUserParticipants() bool {
+	for _, participant := range t.participants {
+		if !participant.FocusLink {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Iterates over participants and calls a closure on each of the participants.
 func (t *Tracker) ForEachParticipant(fn func(ID, *Participant)) {
 	for id, participant := range t.participants {
@@ -98,15 +110,23 @@ func (t *Tracker) AddPublishedTrack(
 			layers = append(layers, simulcast)
 		}
 
-		t.publishedTracks[info.TrackID] = &PublishedTrack{
+		track := &PublishedTrack{
 			Owner:         participantID,
 			Info:          info,
 			Layers:        layers,
+			LayerCodecs:   map[webrtc_ext.SimulcastLayer]webrtc.RTPCodecCapability{simulcast: info.Codec},
 			Metadata:      metadata,
 			OutputTrack:   outputTrack,
 			Subscriptions: make(map[ID]subscription.Subscription),
 		}
 
+		// A video track without simulcast may be an SVC stream, we'll know once it announces its structure.
+		if info.Kind == webrtc.RTPCodecTypeVideo && simulcast == webrtc_ext.SimulcastLayerNone {
+			track.layerParser = rewriter.NewLayerParser(info.Codec.MimeType, info.DependencyDescriptorID)
+		}
+
+		t.publishedTracks[info.TrackID] = track
+
 		return
 	}
 
@@ -114,6 +134,7 @@ func (t *Tracker) AddPublishedTrack(
 	fn := func(layer webrtc_ext.SimulcastLayer) bool { return layer == simulcast }
 	if simulcast != webrtc_ext.SimulcastLayerNone && slices.IndexFunc(track.Layers, fn) == -1 {
 		track.Layers = append(track.Layers, simulcast)
+		track.LayerCodecs[simulcast] = info.Codec
 		t.publishedTracks[info.TrackID] = track
 	}
 }
@@ -125,6 +146,15 @@ func (t *Tracker) ForEachPublishedTrackInfo(fn func(ID, webrtc_ext.TrackInfo)) {
 	}
 }
 
+// Iterates over the published tracks that a given participant is subscribed to and calls a closure upon each track info.
+func (t *Tracker) ForEachSubscribedTrackInfo(participantID ID, fn func(webrtc_ext.TrackInfo)) {
+	for _, track := range t.publishedTracks {
+		if _, found := track.Subscriptions[participantID]; found {
+			fn(track.Info)
+		}
+	}
+}
+
 // Updates metadata associated with a given track.
 func (t *Tracker) UpdatePublishedTrackMetadata(id TrackID, metadata TrackMetadata) {
 	if track, found := t.publishedTracks[id]; found {
@@ -160,35 +190,46 @@ func (t *Tracker) Subscribe(participantID ID, trackID TrackID, requirements Trac
 		return fmt.Errorf("track %s does not exist", trackID)
 	}
 
-	// Calculate the desired simulcast layer.
-	desiredLayer := published.GetOptimalLayer(requirements.MaxWidth, requirements.MaxHeight)
-
-	// If the subscription exists, let's see if we need to update it.
+	// If the subscription exists, let's see if we need to update it. The codec of the subscribed
+	// track can't change, so only the layers that are sent with the same codec are considered.
 	if sub := published.Subscriptions[participantID]; sub != nil {
+		layers := published.LayersWithCodec(published.Codec(sub.Simulcast()).MimeType)
+		desiredLayer := published.getOptimalLayer(layers, requirements.MaxWidth, requirements.MaxHeight)
+		sub.SetMaxFrameRate(requirements.MaxFrameRate)
 		if sub.Simulcast() != desiredLayer {
 			sub.SwitchLayer(desiredLayer)
 			return nil
 		}
 
-		return fmt.Errorf("subscription already exists and up-to-date")
+		// The subscription already exists and is up-to-date.
+		return nil
+	}
+
+	// Only the layers that the subscriber can decode are considered.
+	layers, err := published.DecodableLayers(participant.Peer.RemoteCodecs(published.Info.Kind))
+	if err != nil {
+		return err
 	}
 
+	// Calculate the desired simulcast layer.
+	desiredLayer := published.getOptimalLayer(layers, requirements.MaxWidth, requirements.MaxHeight)
+
 	// Find the owner of the track that we're trying to subscribe to.
 	owner := t.participants[published.Owner]
 	if owner == nil {
 		return fmt.Errorf("owner of the track %s does not exist", published.Info.TrackID)
 	}
 
-	var (
-		sub subscription.Subscription
-		err error
-	)
+	var sub subscription.Subscription
 
 	// Subscription does not exist, so let's create it.
 	switch published.Info.Kind {
 	case webrtc.RTPCodecTypeVideo:
+		info := published.Info
+		info.Codec = published.Codec(desiredLayer)
+
 		sub, err = subscription.NewVideoSubscription(
-			published.Info,
+			info,
 			desiredLayer,
 			participant.Peer,
 			func(track webrtc_ext.TrackInfo, simulcast webrtc_ext.SimulcastLayer) error {
@@ -205,6 +246,8 @@ func (t *Tracker) Subscribe(participantID ID, trackID TrackID, requirements Trac
 		return err
 	}
 
+	sub.SetMaxFrameRate(requirements.MaxFrameRate)
+
 	// Add the subscription to the list of subscriptions.
 	published.Subscriptions[participantID] = sub
 
@@ -224,11 +267,14 @@ func (t *Tracker) Unsubscribe(participantID ID, trackID TrackID) {
 // Processes an RTP packet received on a given track.
 func (t *Tracker) ProcessRTP(info webrtc_ext.TrackInfo, simulcast webrtc_ext.SimulcastLayer, packet *rtp.Packet) {
 	if published := t.publishedTracks[info.TrackID]; published != nil {
+		if published.processSVCPacket(packet) {
+			logrus.Infof("Track %s is sent with %d spatial layers", info.TrackID, published.SpatialLayers)
+		}
+
+		// The subscriptions pick the layers that they need (e.g. two layers while switching between them).
 		for _, sub := range published.Subscriptions {
-			if sub.Simulcast() == simulcast {
-				if err := sub.WriteRTP(*packet); err != nil {
-					logrus.Errorf("Dropping an RTP packet on %s (%s): %s", info.TrackID, simulcast, err)
-				}
+			if err := sub.WriteRTP(*packet, simulcast); err != nil {
+				logrus.Errorf("Dropping an RTP packet on %s (%s): %s", info.TrackID, simulcast, err)
 			}
 		}
 	}
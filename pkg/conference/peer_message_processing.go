@@ -1,6 +1,8 @@
 package conference
 
 import (
+	"encoding/json"
+
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/peer"
 	"github.com/matrix-org/waterfall/pkg/signaling"
@@ -31,6 +33,14 @@ func (c *Conference) processNewTrackPublishedMessage(sender participant.ID, msg
 	// If a new track has been published, we inform everyone about new track available.
 	c.tracker.AddPublishedTrack(sender, msg.TrackInfo, msg.SimulcastLayer, trackMetadata, msg.OutputTrack)
 	c.resendMetadataToAllExcept(sender)
+
+	// Tracks of our end-users are forwarded to the foci that we're linked with.
+	if !c.isFocusLink(sender) {
+		c.subscribeLinksToTrack(msg.TrackID)
+	}
+
+	// The participants that have been handed over to us may be waiting for the track.
+	c.restorePendingSubscriptions(msg.TrackID)
 }
 
 func (c *Conference) processRTPPacketReceivedMessage(msg peer.RTPPacketReceived) {
@@ -43,21 +53,30 @@ func (c *Conference) processPublishedTrackFailedMessage(sender participant.ID, m
 	c.resendMetadataToAllExcept(sender)
 }
 
-func (c *Conference) processNewICECandidateMessage(sender participant.ID, msg peer.NewICECandidate) {
+func (c *Conference) processNewICECandidatesMessage(sender participant.ID, msg peer.NewICECandidates) {
 	p := c.getParticipant(sender)
 	if p == nil {
 		return
 	}
 
-	p.Logger.Debug("Received a new local ICE candidate")
+	p.Logger.Debugf("Received %d new local ICE candidates", len(msg.Candidates))
 
-	// Convert WebRTC ICE candidate to Matrix ICE candidate.
-	jsonCandidate := msg.Candidate.ToJSON()
-	candidates := []event.CallCandidate{{
-		Candidate:     jsonCandidate.Candidate,
-		SDPMLineIndex: int(*jsonCandidate.SDPMLineIndex),
-		SDPMID:        *jsonCandidate.SDPMid,
-	}}
+	// Convert WebRTC ICE candidates to Matrix ICE candidates.
+	candidates := make([]event.CallCandidate, 0, len(msg.Candidates)+1)
+	for _, candidate := range msg.Candidates {
+		jsonCandidate := candidate.ToJSON()
+		candidates = append(candidates, event.CallCandidate{
+			Candidate:     jsonCandidate.Candidate,
+			SDPMLineIndex: int(*jsonCandidate.SDPMLineIndex),
+			SDPMID:        *jsonCandidate.SDPMid,
+		})
+	}
+
+	// An empty candidate indicates that ICE gathering is complete, no need to send it separately.
+	if msg.GatheringComplete {
+		p.Logger.Debug("Local ICE gathering completed")
+		candidates = append(candidates, event.CallCandidate{Candidate: ""})
+	}
 
 	c.matrixWorker.sendSignalingMessage(p.AsMatrixRecipient(), signaling.IceCandidates{Candidates: candidates})
 }
@@ -80,19 +99,37 @@ func (c *Conference) processRenegotiationRequiredMessage(sender participant.ID,
 		return
 	}
 
+	description := event.CallData{
+		Type: event.CallDataType(msg.Offer.Type.String()),
+		SDP:  msg.Offer.SDP,
+	}
+
+	// The data channel may still look open while ICE is down, so the ICE restart offer is always sent over Matrix.
+	if msg.ICERestart {
+		p.Logger.Info("Restarting ICE, sending SDP offer over Matrix")
+		c.matrixWorker.sendSignalingMessage(p.AsMatrixRecipient(), signaling.Negotiate{
+			Description:    description,
+			StreamMetadata: c.getAvailableStreamsFor(p.ID),
+		})
+		return
+	}
+
 	p.Logger.Info("Renegotiation started, sending SDP offer")
-	p.SendDataChannelMessage(event.Event{
+	c.sendNegotiate(p, description)
+}
+
+// Sends an offer or an answer of the renegotiation to a participant.
+func (c *Conference) sendNegotiate(p *participant.Participant, description event.CallData) {
+	metadata := c.getAvailableStreamsFor(p.ID)
+	c.sendOverDataChannelOrMatrix(p, event.Event{
 		Type: event.FocusCallNegotiate,
 		Content: event.Content{
 			Parsed: event.FocusCallNegotiateEventContent{
-				Description: event.CallData{
-					Type: event.CallDataType(msg.Offer.Type.String()),
-					SDP:  msg.Offer.SDP,
-				},
-				SDPStreamMetadata: c.getAvailableStreamsFor(p.ID),
+				Description:       description,
+				SDPStreamMetadata: metadata,
 			},
 		},
-	})
+	}, signaling.Negotiate{Description: description, StreamMetadata: metadata})
 }
 
 func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.DataChannelMessage) {
@@ -114,16 +151,24 @@ func (c *Conference) processDataChannelMessage(sender participant.ID, msg peer.D
 	switch focusEvent.Type.Type {
 	case event.FocusCallTrackSubscription.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallTrackSubscription)
-		c.processTrackSubscriptionMessage(p, *focusEvent.Content.AsFocusCallTrackSubscription())
+		c.processTrackSubscriptionMessage(
+			p,
+			*focusEvent.Content.AsFocusCallTrackSubscription(),
+			subscriptionFrameRates(focusEvent.Content),
+		)
 	case event.FocusCallNegotiate.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallNegotiate)
 		c.processNegotiateMessage(p, *focusEvent.Content.AsFocusCallNegotiate())
+	case event.FocusCallPing.Type:
+		c.processPingMessage(p)
 	case event.FocusCallPong.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallPong)
 		c.processPongMessage(p)
 	case event.FocusCallSDPStreamMetadataChanged.Type:
 		focusEvent.Content.ParseRaw(event.FocusCallSDPStreamMetadataChanged)
 		c.processMetadataMessage(p.ID, *focusEvent.Content.AsFocusCallSDPStreamMetadataChanged())
+	case focusCallCapabilities.Type:
+		c.processCapabilitiesMessage(p, focusEvent.Content)
 	default:
 		p.Logger.WithField("type", focusEvent.Type.Type).Warn("Received data channel message of unknown type")
 	}
@@ -136,6 +181,8 @@ func (c *Conference) processDataChannelAvailableMessage(sender participant.ID, m
 	}
 
 	p.Logger.Info("Connected data channel")
+	c.sendCapabilities(p)
+	p.FlushDataChannelMessages()
 	p.SendDataChannelMessage(event.Event{
 		Type: event.FocusCallSDPStreamMetadataChanged,
 		Content: event.Content{
@@ -144,12 +191,19 @@ func (c *Conference) processDataChannelAvailableMessage(sender participant.ID, m
 			},
 		},
 	})
+
+	// Once the link to another focus is established, we can start forwarding our tracks to it.
+	if p.FocusLink {
+		c.subscribeLinkToUserTracks(p)
+	}
+
 }
 
 // Handle the `FocusEvent` from the DataChannel message.
 func (c *Conference) processTrackSubscriptionMessage(
 	p *participant.Participant,
 	msg event.FocusCallTrackSubscriptionEventContent,
+	frameRates map[string]int,
 ) {
 	p.Logger.Debug("Received track subscription request over DC")
 
@@ -157,20 +211,48 @@ func (c *Conference) processTrackSubscriptionMessage(
 	for _, track := range msg.Unsubscribe {
 		p.Logger.Debugf("Unsubscribing from track %s", track.TrackID)
 		c.tracker.Unsubscribe(p.ID, track.TrackID)
+		delete(p.SubscriptionRequirements, track.TrackID)
 	}
 
 	// Now let's handle the subscribe commands.
 	for _, track := range msg.Subscribe {
 		p.Logger.Debugf("Subscribing to track %s", track.TrackID)
 
-		requirements := participant.TrackMetadata{track.Width, track.Height}
+		requirements := participant.TrackMetadata{
+			MaxWidth:     track.Width,
+			MaxHeight:    track.Height,
+			MaxFrameRate: frameRates[track.TrackID],
+		}
 		if err := c.tracker.Subscribe(p.ID, track.TrackID, requirements); err != nil {
 			p.Logger.Errorf("Failed to subscribe to track %s: %v", track.TrackID, err)
+			c.sendSubscriptionError(p, track, err)
 			continue
 		}
 
 		p.Logger.Debugf("Subscribed to track %s", track.TrackID)
+		p.SubscriptionRequirements[track.TrackID] = requirements
+	}
+}
+
+// The subscribe commands may have an optional `max_frame_rate` that mautrix does not know about.
+func subscriptionFrameRates(content event.Content) map[string]int {
+	var subscription struct {
+		Subscribe []struct {
+			TrackID      string `json:"track_id"`
+			MaxFrameRate int    `json:"max_frame_rate,omitempty"`
+		} `json:"subscribe"`
+	}
+
+	frameRates := make(map[string]int)
+	if err := json.Unmarshal(content.VeryRaw, &subscription); err != nil {
+		return frameRates
+	}
+
+	for _, track := range subscription.Subscribe {
+		frameRates[track.TrackID] = track.MaxFrameRate
 	}
+
+	return frameRates
 }
 
 func (c *Conference) processNegotiateMessage(p *participant.Participant, msg event.FocusCallNegotiateEventContent) {
@@ -187,17 +269,9 @@ func (c *Conference) processNegotiateMessage(p *participant.Participant, msg eve
 			return
 		}
 
-		p.SendDataChannelMessage(event.Event{
-			Type: event.FocusCallNegotiate,
-			Content: event.Content{
-				Parsed: event.FocusCallNegotiateEventContent{
-					Description: event.CallData{
-						Type: event.CallDataType(answer.Type.String()),
-						SDP:  answer.SDP,
-					},
-					SDPStreamMetadata: c.getAvailableStreamsFor(p.ID),
-				},
-			},
+		c.sendNegotiate(p, event.CallData{
+			Type: event.CallDataType(answer.Type.String()),
+			SDP:  answer.SDP,
 		})
 	case event.CallDataTypeAnswer:
 		p.Logger.Info("Renegotiation answer received")
@@ -212,6 +286,14 @@ func (c *Conference) processNegotiateMessage(p *participant.Participant, msg eve
 	}
 }
 
+// Other foci ping us the same way as we ping our participants, so we must respond to them.
+func (c *Conference) processPingMessage(p *participant.Participant) {
+	p.SendDataChannelMessage(event.Event{
+		Type:    event.FocusCallPong,
+		Content: event.Content{},
+	})
+}
+
 func (c *Conference) processPongMessage(p *participant.Participant) {
 	select {
 	case p.Pong <- participant.Pong{}:
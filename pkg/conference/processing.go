@@ -4,6 +4,7 @@ import (
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
 	"maunium.net/go/mautrix/event"
 )
 
@@ -21,11 +22,21 @@ func (c *Conference) processMessages(signalDone chan struct{}) {
 			c.processPeerMessage(msg)
 		case msg := <-c.matrixEvents:
 			c.processMatrixMessage(msg)
+		case msg := <-c.controlMessages:
+			c.processControlMessage(msg)
+		case failed := <-c.deliveryFailures:
+			c.processDeliveryFailure(failed)
+		case delivered := <-c.handoffResults:
+			c.onHandoffSent(delivered)
+		case <-c.migrationDeadline:
+			c.onMigrationDeadline()
 		}
 
-		// If there are no more participants, stop the conference.
-		if !c.tracker.HasParticipants() {
+		// If there are no more participants, stop the conference. The links to other foci
+		// are not interesting on their own, so they're hung up once the last end-user leaves.
+		if !c.tracker.HasUserParticipants() {
 			c.logger.Info("No more participants, stopping the conference")
+			c.unlinkFoci()
 			return
 		}
 	}
@@ -46,8 +57,8 @@ func (c *Conference) processPeerMessage(message channel.Message[participant.ID,
 		c.processRTPPacketReceivedMessage(msg)
 	case peer.PublishedTrackFailed:
 		c.processPublishedTrackFailedMessage(message.Sender, msg)
-	case peer.NewICECandidate:
-		c.processNewICECandidateMessage(message.Sender, msg)
+	case peer.NewICECandidates:
+		c.processNewICECandidatesMessage(message.Sender, msg)
 	case peer.ICEGatheringComplete:
 		c.processICEGatheringCompleteMessage(message.Sender, msg)
 	case peer.RenegotiationRequired:
@@ -67,11 +78,30 @@ func (c *Conference) processMatrixMessage(msg MatrixMessage) {
 		c.onNewParticipant(msg.Sender, ev)
 	case *event.CallCandidatesEventContent:
 		c.onCandidates(msg.Sender, ev)
+	case *event.CallAnswerEventContent:
+		c.onAnswer(msg.Sender, ev)
 	case *event.CallSelectAnswerEventContent:
 		c.onSelectAnswer(msg.Sender, ev)
 	case *event.CallHangupEventContent:
 		c.onHangup(msg.Sender, ev)
+	case *event.CallNegotiateEventContent:
+		c.onNegotiate(msg.Sender, ev)
+	case *signaling.CallSDPStreamMetadataChangedEventContent:
+		c.onMetadataChanged(msg.Sender, ev)
 	default:
 		c.logger.Errorf("Unexpected event type: %T", ev)
 	}
 }
+
+func (c *Conference) processControlMessage(msg ControlMessage) {
+	switch msg := msg.(type) {
+	case MigrateTo:
+		c.migrate(msg.Focus)
+	case RestoreSubscriptions:
+		c.restoreSubscriptions(msg.Participant, msg.Subscriptions)
+	case Shutdown:
+		c.shutdown(msg.Flushed)
+	default:
+		c.logger.Errorf("Unexpected control message: %T", msg)
+	}
+}
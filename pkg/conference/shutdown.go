@@ -0,0 +1,31 @@
+package conference
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+)
+
+// Hangs up on all participants (and links to other foci) since the SFU is shutting down. The conference
+// ends right after that; the flushed channel is closed once all queued messages (hangups) are sent.
+func (c *Conference) shutdown(flushed chan<- struct{}) {
+	c.logger.Info("Shutting down, hanging up on all participants")
+
+	participants := []*participant.Participant{}
+	c.tracker.ForEachParticipant(func(_ participant.ID, p *participant.Participant) {
+		participants = append(participants, p)
+	})
+
+	c.hangUpOn(participants, func(p *participant.Participant) signaling.Hangup {
+		if p.FocusLink {
+			return signaling.Hangup{Reason: event.CallHangupUserHangup}
+		}
+
+		return signaling.Hangup{Reason: signaling.CallHangupFocusShutdown}
+	})
+
+	go func() {
+		<-c.matrixWorker.done
+		close(flushed)
+	}()
+}
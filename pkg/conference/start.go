@@ -33,8 +33,9 @@ func StartConference(
 	confID string,
 	config Config,
 	peerConnectionFactory *webrtc_ext.PeerConnectionFactory,
-	signaling signaling.MatrixSignaler,
+	signaler signaling.MatrixSignaler,
 	matrixEvents <-chan MatrixMessage,
+	controlMessages <-chan ControlMessage,
 	userID id.UserID,
 	inviteEvent *event.CallInviteEventContent,
 ) (<-chan struct{}, error) {
@@ -43,18 +44,27 @@ func StartConference(
 		config:            config,
 		connectionFactory: peerConnectionFactory,
 		logger:            logrus.WithFields(logrus.Fields{"conf_id": confID}),
-		matrixWorker:      newMatrixWorker(signaling),
 		tracker:           *participant.NewParticipantTracker(),
 		streamsMetadata:   make(event.CallSDPStreamMetadata),
 		peerMessages:      make(chan channel.Message[participant.ID, peer.MessageContent], 100),
 		matrixEvents:      matrixEvents,
+		controlMessages:   controlMessages,
+		deliveryFailures:  make(chan []signaling.MatrixMessage, 16),
+		handoffResults:    make(chan bool, 1),
+
+		pendingSubscriptions: make(map[participant.ID][]signaling.HandoffSubscription),
 	}
+	conference.matrixWorker = newMatrixWorker(signaler, conference.reportDeliveryFailure)
 
 	participantID := participant.ID{UserID: userID, DeviceID: inviteEvent.DeviceID, CallID: inviteEvent.CallID}
 	if err := conference.onNewParticipant(participantID, inviteEvent); err != nil {
-		return nil, nil
+		conference.matrixWorker.stop()
+		return nil, err
 	}
 
+	// Link the conference to the other foci, so that their participants can see ours.
+	conference.linkFoci()
+
 	// Start conference "main loop".
 	signalDone := make(chan struct{})
 	go conference.processMessages(signalDone)
@@ -1,9 +1,12 @@
 package conference
 
 import (
+	"time"
+
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/peer"
+	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
@@ -22,8 +25,19 @@ type Conference struct {
 	tracker         participant.Tracker
 	streamsMetadata event.CallSDPStreamMetadata
 
-	peerMessages chan channel.Message[participant.ID, peer.MessageContent]
-	matrixEvents <-chan MatrixMessage
+	peerMessages    chan channel.Message[participant.ID, peer.MessageContent]
+	matrixEvents    <-chan MatrixMessage
+	controlMessages <-chan ControlMessage
+	// Signaling messages that could not be delivered (reported by the matrix worker).
+	deliveryFailures chan []signaling.MatrixMessage
+
+	// The focus that the conference is being moved to (if any) and the deadline for the participants to move.
+	migratingTo       *signaling.Focus
+	migrationDeadline <-chan time.Time
+	// Reports whether the focus that the conference is being moved to has got the handoff.
+	handoffResults chan bool
+	// Subscriptions of the participants that have been handed over to us whose tracks are not published yet.
+	pendingSubscriptions map[participant.ID][]signaling.HandoffSubscription
 }
 
 func (c *Conference) getParticipant(id participant.ID) *participant.Participant {
@@ -37,10 +51,7 @@ func (c *Conference) getParticipant(id participant.ID) *participant.Participant
 
 // Helper to terminate and remove a participant from the conference.
 func (c *Conference) removeParticipant(id participant.ID) {
-	// Remove the participant and then remove its streams from the map.
-	for streamID := range c.tracker.RemoveParticipant(id) {
-		delete(c.streamsMetadata, streamID)
-	}
+	c.forgetParticipant(id)
 
 	// Inform the other participants about updated metadata (since the participant left
 	// the corresponding streams of the participant are no longer available, so we're informing
@@ -48,14 +59,43 @@ func (c *Conference) removeParticipant(id participant.ID) {
 	c.resendMetadataToAllExcept(id)
 }
 
+// Hangs up on many participants at once (e.g. when shutting down). Unlike removing them one by one,
+// the remaining participants are informed about the updated metadata only once (if there are any left).
+func (c *Conference) hangUpOn(
+	participants []*participant.Participant,
+	hangupFor func(*participant.Participant) signaling.Hangup,
+) {
+	if len(participants) == 0 {
+		return
+	}
+
+	for _, p := range participants {
+		c.matrixWorker.sendSignalingMessage(p.AsMatrixRecipient(), hangupFor(p))
+		c.forgetParticipant(p.ID)
+	}
+
+	c.resendMetadataToAllExcept(participant.ID{})
+}
+
+// Removes the participant and its streams without informing the others.
+func (c *Conference) forgetParticipant(id participant.ID) {
+	for streamID := range c.tracker.RemoveParticipant(id) {
+		delete(c.streamsMetadata, streamID)
+	}
+	delete(c.pendingSubscriptions, id)
+}
+
 // Helper to get the list of available streams for a given participant, i.e. the list of streams
 // that a given participant **can subscribe to**. Each stream may have multiple tracks.
 func (c *Conference) getAvailableStreamsFor(forParticipant participant.ID) event.CallSDPStreamMetadata {
 	streamsMetadata := make(event.CallSDPStreamMetadata)
 
+	// Links to other foci only get the tracks of our end-users (not the ones from other links).
+	forLink := c.isFocusLink(forParticipant)
+
 	c.tracker.ForEachPublishedTrackInfo(func(owner participant.ID, info webrtc_ext.TrackInfo) {
 		// Skip us. As we know about our own tracks.
-		if owner != forParticipant {
+		if owner != forParticipant && !(forLink && c.isFocusLink(owner)) {
 			streamID := info.StreamID
 			kind := info.Kind.String()
 
@@ -84,18 +124,32 @@ func (c *Conference) getAvailableStreamsFor(forParticipant participant.ID) event
 func (c *Conference) resendMetadataToAllExcept(exceptMe participant.ID) {
 	c.tracker.ForEachParticipant(func(id participant.ID, participant *participant.Participant) {
 		if id != exceptMe {
-			participant.SendDataChannelMessage(event.Event{
+			metadata := c.getAvailableStreamsFor(id)
+			c.sendOverDataChannelOrMatrix(participant, event.Event{
 				Type: event.FocusCallSDPStreamMetadataChanged,
 				Content: event.Content{
 					Parsed: event.FocusCallSDPStreamMetadataChangedEventContent{
-						SDPStreamMetadata: c.getAvailableStreamsFor(id),
+						SDPStreamMetadata: metadata,
 					},
 				},
-			})
+			}, signaling.StreamMetadataChanged{StreamMetadata: metadata})
 		}
 	})
 }
 
+// Sends a message over the data channel or, if the data channel is not available (e.g. it has failed),
+// sends the equivalent to-device message, so that the participant is not stuck with an outdated state.
+func (c *Conference) sendOverDataChannelOrMatrix(
+	p *participant.Participant,
+	dataChannelMessage event.Event,
+	matrixMessage interface{},
+) {
+	if err := p.TrySendDataChannelMessage(dataChannelMessage); err != nil {
+		p.Logger.WithError(err).Debugf("Sending %s as a to-device message", dataChannelMessage.Type.Type)
+		c.matrixWorker.sendSignalingMessage(p.AsMatrixRecipient(), matrixMessage)
+	}
+}
+
 // Helper that updates the metadata each time the metadata is received.
 func (c *Conference) updateMetadata(metadata event.CallSDPStreamMetadata) {
 	// Note that this assumes that the stream IDs are unique, which is not always so!
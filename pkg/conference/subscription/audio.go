@@ -38,13 +38,16 @@ func (s *AudioSubscription) Unsubscribe() error {
 	return s.controller.RemoveTrack(s.sender)
 }
 
-func (s *AudioSubscription) WriteRTP(packet rtp.Packet) error {
+func (s *AudioSubscription) WriteRTP(packet rtp.Packet, simulcast webrtc_ext.SimulcastLayer) error {
 	return fmt.Errorf("Bug: no write RTP logic for an audio subscription!")
 }
 
 func (s *AudioSubscription) SwitchLayer(simulcast webrtc_ext.SimulcastLayer) {
 }
 
+func (s *AudioSubscription) SetMaxFrameRate(frameRate int) {
+}
+
 func (s *AudioSubscription) Simulcast() webrtc_ext.SimulcastLayer {
 	return webrtc_ext.SimulcastLayerNone
 }
@@ -0,0 +1,118 @@
+package rewriter
+
+import (
+	"golang.org/x/exp/slices"
+)
+
+// Positions of the counters of a payload descriptor that must stay continuous when switching layers.
+type descriptorCounters struct {
+	// Offset of the PictureID (-1 if not present).
+	pictureIDOffset int
+	// Width of the PictureID in bits (7 or 15).
+	pictureIDWidth uint64
+	// Offset of the TL0PICIDX (-1 if not present).
+	tl0PicIdxOffset int
+	// Offset of the byte that ends with the 5-bit KEYIDX (-1 if not present, VP8 only).
+	keyIdxOffset int
+}
+
+func noDescriptorCounters() descriptorCounters {
+	return descriptorCounters{pictureIDOffset: -1, tl0PicIdxOffset: -1, keyIdxOffset: -1}
+}
+
+// Keeps the counters of the VP8 and VP9 payload descriptors (PictureID, TL0PICIDX and KEYIDX) continuous
+// when switching layers, the same way as `forwardingState` does with the sequence numbers and timestamps.
+type descriptorRewriter struct {
+	// Finds the counters in the payload, returns false if the descriptor is malformed.
+	parse func(payload []byte) (descriptorCounters, bool)
+	// The SSRC of the previously rewritten packet.
+	ssrc      uint32
+	pictureID counterState
+	tl0PicIdx counterState
+	keyIdx    counterState
+}
+
+// Returns the payload with the rewritten counters. The payload is copied
+// before the modification, since it's shared with other subscribers.
+func (r *descriptorRewriter) process(ssrc uint32, payload []byte) []byte {
+	counters, found := r.parse(payload)
+	if !found || counters == noDescriptorCounters() {
+		return payload
+	}
+
+	// If the SSRCs don't match, then we've switched layers.
+	switched := r.ssrc != ssrc
+	r.ssrc = ssrc
+
+	rewritten := slices.Clone(payload)
+
+	if offset := counters.pictureIDOffset; offset != -1 {
+		if counters.pictureIDWidth == 15 {
+			truncated := uint64(payload[offset]&0x7F)<<8 | uint64(payload[offset+1])
+			pictureID := r.pictureID.process(truncated, 15, switched)
+			rewritten[offset] = 0x80 | byte(pictureID>>8)&0x7F
+			rewritten[offset+1] = byte(pictureID)
+		} else {
+			truncated := uint64(payload[offset] & 0x7F)
+			pictureID := r.pictureID.process(truncated, 7, switched)
+			rewritten[offset] = byte(pictureID) & 0x7F
+		}
+	}
+
+	if offset := counters.tl0PicIdxOffset; offset != -1 {
+		rewritten[offset] = byte(r.tl0PicIdx.process(uint64(payload[offset]), 8, switched))
+	}
+
+	// The KEYIDX shares the byte with the TID and the layer sync bit, which are kept as they are.
+	if offset := counters.keyIdxOffset; offset != -1 {
+		keyIdx := r.keyIdx.process(uint64(payload[offset]&0x1F), 5, switched)
+		rewritten[offset] = payload[offset]&0xE0 | byte(keyIdx)&0x1F
+	}
+
+	return rewritten
+}
+
+// State of a single counter that is rewritten. The counter continues from the latest outgoing value
+// after switching layers (the values of the very first layer are kept as they are).
+type counterState struct {
+	// The value of the first incoming counter after switching layers.
+	firstIncoming uint64
+	// The highest incoming value since switching layers.
+	latestIncoming uint64
+	// The value of the first outgoing counter after switching layers.
+	firstOutgoing uint64
+	// The highest outgoing value.
+	latestOutgoing uint64
+	// Set once the first value has been processed.
+	started bool
+}
+
+// Processes the incoming (truncated) counter and returns the expanded outgoing one.
+func (c *counterState) process(truncated, width uint64, switched bool) uint64 {
+	if switched || !c.started {
+		// As in `forwardingState`, the ROC is tracked since the switching point, so it's 0.
+		c.firstIncoming = truncated
+		c.latestIncoming = truncated
+
+		c.firstOutgoing = truncated
+		if c.started {
+			c.firstOutgoing = c.latestOutgoing + 1
+		}
+
+		c.started = true
+		c.latestOutgoing = max(c.latestOutgoing, c.firstOutgoing)
+
+		return c.firstOutgoing
+	}
+
+	expanded := ExpandCounter(truncated, width, &c.latestIncoming)
+	outgoing := c.firstOutgoing + expanded - c.firstIncoming
+
+	// Late packets from before the switching point don't move the latest value
+	// (the outgoing value is still correct once truncated).
+	if expanded >= c.firstIncoming {
+		c.latestOutgoing = max(c.latestOutgoing, outgoing)
+	}
+
+	return outgoing
+}
@@ -0,0 +1,175 @@
+package rewriter
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Checks if the RTP payload of a given codec (MIME type) starts a keyframe, i.e. if it's a point
+// where a decoder can start decoding the stream. Any packet is such a point for the codecs that
+// don't have keyframes (e.g. audio) or that we don't know.
+func IsKeyframe(mimeType string, payload []byte) bool {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return isVP8Keyframe(payload)
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return isVP9Keyframe(payload)
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return isH264Keyframe(payload)
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		return isAV1Keyframe(payload)
+	default:
+		return true
+	}
+}
+
+// See RFC 7741, section 4.2 (payload descriptor) and RFC 6386, section 9.1 (frame header).
+func isVP8Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	// Only the first packet of the first partition carries the frame header.
+	start, partitionID := payload[0]&0x10 != 0, payload[0]&0x07
+	if !start || partitionID != 0 {
+		return false
+	}
+
+	offset := 1
+	if payload[0]&0x80 != 0 {
+		if len(payload) < offset+1 {
+			return false
+		}
+
+		extension := payload[offset]
+		offset++
+
+		// Picture ID (7 or 15 bits).
+		if extension&0x80 != 0 {
+			if len(payload) < offset+1 {
+				return false
+			}
+
+			if payload[offset]&0x80 != 0 {
+				offset += 2
+			} else {
+				offset++
+			}
+		}
+
+		// TL0PICIDX.
+		if extension&0x40 != 0 {
+			offset++
+		}
+
+		// TID/Y/KEYIDX.
+		if extension&0x20 != 0 || extension&0x10 != 0 {
+			offset++
+		}
+	}
+
+	if len(payload) < offset+1 {
+		return false
+	}
+
+	// The (inverse) key frame flag of the frame header.
+	return payload[offset]&0x01 == 0
+}
+
+// See the VP9 RTP payload format (RFC 9628), section 4.2.
+func isVP9Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	descriptor := payload[0]
+	interPicturePredicted := descriptor&0x40 != 0
+	startOfFrame := descriptor&0x08 != 0
+	if interPicturePredicted || !startOfFrame {
+		return false
+	}
+
+	// Without the layer indices there is a single spatial layer.
+	if descriptor&0x20 == 0 {
+		return true
+	}
+
+	offset := 1
+	if descriptor&0x80 != 0 {
+		if len(payload) < offset+1 {
+			return false
+		}
+
+		if payload[offset]&0x80 != 0 {
+			offset += 2
+		} else {
+			offset++
+		}
+	}
+
+	if len(payload) < offset+1 {
+		return false
+	}
+
+	// Only the base spatial layer of a keyframe is decodable on its own.
+	spatialID := (payload[offset] >> 1) & 0x07
+	return spatialID == 0
+}
+
+// NAL unit types of H.264 (see RFC 6184, section 5.2).
+const (
+	h264NALUnitIDR   = 5
+	h264NALUnitSPS   = 7
+	h264NALUnitSTAPA = 24
+	h264NALUnitFUA   = 28
+)
+
+// See RFC 6184, section 5.
+func isH264Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	isKeyframeNALUnit := func(nalUnitType byte) bool {
+		return nalUnitType == h264NALUnitIDR || nalUnitType == h264NALUnitSPS
+	}
+
+	switch nalUnitType := payload[0] & 0x1F; nalUnitType {
+	case h264NALUnitSTAPA:
+		for offset := 1; offset+2 < len(payload); {
+			size := int(binary.BigEndian.Uint16(payload[offset:]))
+			if isKeyframeNALUnit(payload[offset+2] & 0x1F) {
+				return true
+			}
+
+			offset += 2 + size
+		}
+
+		return false
+	case h264NALUnitFUA:
+		if len(payload) < 2 {
+			return false
+		}
+
+		// Only the first fragment starts the NAL unit.
+		start := payload[1]&0x80 != 0
+		return start && isKeyframeNALUnit(payload[1]&0x1F)
+	default:
+		return isKeyframeNALUnit(nalUnitType)
+	}
+}
+
+// See the AV1 RTP payload format, section 4.4 (aggregation header).
+func isAV1Keyframe(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	// The first packet of a new coded video sequence (that starts with a keyframe),
+	// unless it continues an OBU from the previous packet.
+	continuation := payload[0]&0x80 != 0
+	newSequence := payload[0]&0x08 != 0
+	return newSequence && !continuation
+}
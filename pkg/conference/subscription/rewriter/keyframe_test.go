@@ -0,0 +1,49 @@
+package rewriter_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestIsKeyframe(t *testing.T) {
+	cases := []struct {
+		name     string
+		mimeType string
+		payload  []byte
+		expected bool
+	}{
+		{"VP8 keyframe", webrtc.MimeTypeVP8, []byte{0x10, 0x00}, true},
+		{"VP8 interframe", webrtc.MimeTypeVP8, []byte{0x10, 0x01}, false},
+		{"VP8 keyframe continuation", webrtc.MimeTypeVP8, []byte{0x00, 0x00}, false},
+		{"VP8 keyframe with picture ID", webrtc.MimeTypeVP8, []byte{0x90, 0x80, 0x81, 0x23, 0x00}, true},
+		{"VP8 interframe with picture ID and TL0PICIDX", webrtc.MimeTypeVP8, []byte{0x90, 0xC0, 0x12, 0x05, 0x01}, false},
+		{"VP8 truncated", webrtc.MimeTypeVP8, []byte{0x90}, false},
+
+		{"VP9 keyframe", webrtc.MimeTypeVP9, []byte{0x08}, true},
+		{"VP9 interframe", webrtc.MimeTypeVP9, []byte{0x48}, false},
+		{"VP9 keyframe continuation", webrtc.MimeTypeVP9, []byte{0x00}, false},
+		{"VP9 keyframe base spatial layer", webrtc.MimeTypeVP9, []byte{0xA8, 0x12, 0x00}, true},
+		{"VP9 keyframe upper spatial layer", webrtc.MimeTypeVP9, []byte{0xA8, 0x12, 0x02}, false},
+
+		{"H.264 IDR", webrtc.MimeTypeH264, []byte{0x65}, true},
+		{"H.264 non-IDR", webrtc.MimeTypeH264, []byte{0x41}, false},
+		{"H.264 STAP-A with SPS", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x01, 0x68}, true},
+		{"H.264 STAP-A without SPS", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x01, 0x06, 0x00, 0x01, 0x68}, false},
+		{"H.264 FU-A start of IDR", webrtc.MimeTypeH264, []byte{0x7C, 0x85}, true},
+		{"H.264 FU-A middle of IDR", webrtc.MimeTypeH264, []byte{0x7C, 0x05}, false},
+
+		{"AV1 new coded video sequence", webrtc.MimeTypeAV1, []byte{0x18}, true},
+		{"AV1 continuation", webrtc.MimeTypeAV1, []byte{0x98}, false},
+		{"AV1 interframe", webrtc.MimeTypeAV1, []byte{0x10}, false},
+
+		{"Opus", webrtc.MimeTypeOpus, []byte{0x00}, true},
+	}
+
+	for _, c := range cases {
+		if keyframe := rewriter.IsKeyframe(c.mimeType, c.payload); keyframe != c.expected {
+			t.Errorf("%s: expected %t, got %t", c.name, c.expected, keyframe)
+		}
+	}
+}
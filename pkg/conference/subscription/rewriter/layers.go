@@ -0,0 +1,307 @@
+package rewriter
+
+import (
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// The highest temporal layer ID that VP9 can signal (3 bits).
+const vp9MaxTemporalLayer = 7
+
+// The decode target indication of the AV1 dependency descriptor that marks a switching point.
+const decodeTargetSwitch = 2
+
+// The spatial and temporal layer that a packet belongs to.
+type PacketLayers struct {
+	Spatial  int
+	Temporal int
+	// Set on the last packet of the frame of the given spatial layer.
+	EndOfFrame bool
+	// The highest temporal layer that the decoder can switch up to at this frame (0 if it's not
+	// a switching point), since the following frames up to this layer don't depend on the previous ones.
+	SwitchUpTo int
+}
+
+// Extracts the layer IDs from the packets of a single stream (encoding) of a track.
+type LayerParser struct {
+	mimeType string
+	// ID of the AV1 dependency descriptor header extension (0 if not negotiated).
+	dependencyDescriptorID uint8
+	// The frame dependency templates of the AV1 dependency descriptor. They are only sent
+	// along with the keyframes, so we must remember them for the following frames.
+	templateIDOffset int
+	templateLayers   []PacketLayers
+	// The decode target indications of each template and the highest temporal layer of each decode target.
+	templateDTIs       [][]uint32
+	decodeTargetLayers []int
+	// The number of spatial layers of the stream as announced by the stream itself (0 if not known yet).
+	spatialLayers int
+}
+
+func NewLayerParser(mimeType string, dependencyDescriptorID uint8) *LayerParser {
+	return &LayerParser{mimeType: strings.ToLower(mimeType), dependencyDescriptorID: dependencyDescriptorID}
+}
+
+// Returns the layers that the packet belongs to. Returns false if they are not known
+// (e.g. the codec or the stream does not have layers), such packets are never dropped.
+func (l *LayerParser) Parse(packet *rtp.Packet) (PacketLayers, bool) {
+	switch l.mimeType {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		descriptor, found := parseVP8Descriptor(packet.Payload)
+		if !found || descriptor.temporalIDOffset == -1 {
+			return PacketLayers{}, false
+		}
+
+		// VP8 has no spatial layers and the end of the frame is only known from the marker.
+		layers := PacketLayers{Temporal: int(packet.Payload[descriptor.temporalIDOffset] >> 6), EndOfFrame: packet.Marker}
+
+		// The layer sync bit is set if the frame only depends on the base layer.
+		if packet.Payload[descriptor.temporalIDOffset]&0x20 != 0 {
+			layers.SwitchUpTo = layers.Temporal
+		}
+
+		return layers, true
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		return l.parseVP9Descriptor(packet.Payload)
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		if l.dependencyDescriptorID == 0 {
+			return PacketLayers{}, false
+		}
+
+		return l.parseDependencyDescriptor(packet.GetExtension(l.dependencyDescriptorID))
+	default:
+		return PacketLayers{}, false
+	}
+}
+
+// Returns the number of spatial layers of the stream (0 if not known yet). The streams announce
+// their structure along with the keyframes (VP9 scalability structure, AV1 dependency descriptor).
+func (l *LayerParser) SpatialLayers() int {
+	return l.spatialLayers
+}
+
+// See the VP9 RTP payload format (RFC 9628), section 4.2.
+func (l *LayerParser) parseVP9Descriptor(payload []byte) (PacketLayers, bool) {
+	if len(payload) < 1 {
+		return PacketLayers{}, false
+	}
+
+	descriptor := payload[0]
+	pictureIDPresent := descriptor&0x80 != 0
+	interPicturePredicted := descriptor&0x40 != 0
+	layerIndicesPresent := descriptor&0x20 != 0
+	flexibleMode := descriptor&0x10 != 0
+	endOfFrame := descriptor&0x04 != 0
+	scalabilityStructurePresent := descriptor&0x02 != 0
+
+	offset := 1
+	if pictureIDPresent {
+		if len(payload) < offset+1 {
+			return PacketLayers{}, false
+		}
+
+		if payload[offset]&0x80 != 0 {
+			offset += 2
+		} else {
+			offset++
+		}
+	}
+
+	layers, found := PacketLayers{EndOfFrame: endOfFrame}, false
+	if layerIndicesPresent {
+		if len(payload) < offset+1 {
+			return PacketLayers{}, false
+		}
+
+		layers.Temporal = int(payload[offset] >> 5)
+		layers.Spatial = int(payload[offset]>>1) & 0x07
+		found = true
+
+		// The switching up point bit is set if the following frames of the higher temporal layers
+		// don't depend on the frames before this one.
+		if payload[offset]&0x10 != 0 {
+			layers.SwitchUpTo = vp9MaxTemporalLayer
+		}
+		offset++
+
+		// TL0PICIDX (only in the non-flexible mode).
+		if !flexibleMode {
+			offset++
+		}
+	}
+
+	// Reference indices (P_DIFF), each of them tells if another one follows.
+	if flexibleMode && interPicturePredicted {
+		for i := 0; i < 3; i++ {
+			if len(payload) < offset+1 {
+				return PacketLayers{}, false
+			}
+
+			offset++
+			if payload[offset-1]&0x01 == 0 {
+				break
+			}
+		}
+	}
+
+	// The scalability structure starts with the number of the spatial layers (minus one).
+	if scalabilityStructurePresent {
+		if len(payload) < offset+1 {
+			return PacketLayers{}, false
+		}
+
+		l.spatialLayers = int(payload[offset]>>5) + 1
+	}
+
+	return layers, found
+}
+
+// See the AV1 RTP payload format, appendix A (dependency descriptor).
+func (l *LayerParser) parseDependencyDescriptor(descriptor []byte) (PacketLayers, bool) {
+	if len(descriptor) < 3 {
+		return PacketLayers{}, false
+	}
+
+	reader := bitReader{data: descriptor}
+
+	// Mandatory fields: start_of_frame, end_of_frame, frame_dependency_template_id and frame_number.
+	reader.read(1)
+	endOfFrame := reader.read(1) == 1
+	templateID := int(reader.read(6))
+	reader.read(16)
+
+	// The extended fields follow if the descriptor is longer than the mandatory fields.
+	var customDTIs []uint32
+	if len(descriptor) > 3 {
+		structurePresent := reader.read(1) == 1
+		activeDecodeTargetsPresent := reader.read(1) == 1
+		customDTIsPresent := reader.read(1) == 1
+		// custom_fdiffs_flag and custom_chains_flag.
+		reader.read(2)
+
+		if structurePresent {
+			l.parseTemplateDependencyStructure(&reader)
+		}
+
+		// active_decode_targets_bitmask.
+		if activeDecodeTargetsPresent {
+			reader.read(len(l.decodeTargetLayers))
+		}
+
+		if customDTIsPresent {
+			customDTIs = l.readDTIs(&reader)
+		}
+	}
+
+	if reader.overflow || l.templateLayers == nil {
+		return PacketLayers{}, false
+	}
+
+	index := (templateID + 64 - l.templateIDOffset) % 64
+	if index >= len(l.templateLayers) {
+		return PacketLayers{}, false
+	}
+
+	dtis := l.templateDTIs[index]
+	if customDTIs != nil {
+		dtis = customDTIs
+	}
+
+	layers := l.templateLayers[index]
+	layers.EndOfFrame = endOfFrame
+
+	// The frame is a switching point for the decode targets that it indicates so.
+	for decodeTarget, dti := range dtis {
+		if dti == decodeTargetSwitch {
+			layers.SwitchUpTo = max(layers.SwitchUpTo, l.decodeTargetLayers[decodeTarget])
+		}
+	}
+
+	return layers, true
+}
+
+// Reads the beginning of the `template_dependency_structure()` up to the decode target indications
+// of the templates (that's all we need).
+func (l *LayerParser) parseTemplateDependencyStructure(reader *bitReader) {
+	templateIDOffset := int(reader.read(6))
+	decodeTargets := int(reader.read(5)) + 1
+
+	// template_layers(): each template is followed by `next_layer_idc` that tells if the
+	// next template has the same layers (0), the next temporal layer (1), the next spatial
+	// layer (2) or if there are no more templates (3).
+	templateLayers := []PacketLayers{}
+	layers := PacketLayers{}
+	for len(templateLayers) < 64 {
+		templateLayers = append(templateLayers, layers)
+
+		nextLayer := reader.read(2)
+		if reader.overflow || nextLayer == 3 {
+			break
+		}
+
+		switch nextLayer {
+		case 1:
+			layers.Temporal++
+		case 2:
+			layers.Spatial++
+			layers.Temporal = 0
+		}
+	}
+
+	// template_dtis(): a decode target includes the layers of the templates that it's present in.
+	templateDTIs := make([][]uint32, len(templateLayers))
+	decodeTargetLayers := make([]int, decodeTargets)
+	for i, layers := range templateLayers {
+		templateDTIs[i] = make([]uint32, decodeTargets)
+		for decodeTarget := range templateDTIs[i] {
+			templateDTIs[i][decodeTarget] = reader.read(2)
+			if templateDTIs[i][decodeTarget] != 0 {
+				decodeTargetLayers[decodeTarget] = max(decodeTargetLayers[decodeTarget], layers.Temporal)
+			}
+		}
+	}
+
+	if !reader.overflow {
+		l.templateIDOffset = templateIDOffset
+		l.templateLayers = templateLayers
+		l.templateDTIs = templateDTIs
+		l.decodeTargetLayers = decodeTargetLayers
+		l.spatialLayers = layers.Spatial + 1
+	}
+}
+
+// Reads the decode target indications of a frame (`frame_dtis()`).
+func (l *LayerParser) readDTIs(reader *bitReader) []uint32 {
+	dtis := make([]uint32, len(l.decodeTargetLayers))
+	for decodeTarget := range dtis {
+		dtis[decodeTarget] = reader.read(2)
+	}
+
+	return dtis
+}
+
+// Reads the bits (most significant first) from a byte slice.
+type bitReader struct {
+	data     []byte
+	position int
+	// Set if there was an attempt to read past the end of the data.
+	overflow bool
+}
+
+func (r *bitReader) read(bits int) uint32 {
+	var value uint32
+	for i := 0; i < bits; i++ {
+		if r.position/8 >= len(r.data) {
+			r.overflow = true
+			return 0
+		}
+
+		bit := (r.data[r.position/8] >> (7 - r.position%8)) & 1
+		value = value<<1 | uint32(bit)
+		r.position++
+	}
+
+	return value
+}
@@ -0,0 +1,226 @@
+package rewriter_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestLayerParser(t *testing.T) {
+	cases := []struct {
+		name           string
+		mimeType       string
+		payload        []byte
+		expectedLayers rewriter.PacketLayers
+		expectedFound  bool
+	}{
+		{"VP8 without extension", webrtc.MimeTypeVP8, []byte{0x10, 0x00}, rewriter.PacketLayers{}, false},
+		{"VP8 without TID", webrtc.MimeTypeVP8, []byte{0x90, 0x80, 0x12, 0x00}, rewriter.PacketLayers{}, false},
+		{"VP8 base layer", webrtc.MimeTypeVP8, []byte{0x90, 0xE0, 0x12, 0x05, 0x20, 0x00}, rewriter.PacketLayers{}, true},
+		{
+			"VP8 second layer", webrtc.MimeTypeVP8, []byte{0x90, 0xE0, 0x12, 0x05, 0x40, 0x00},
+			rewriter.PacketLayers{Temporal: 1}, true,
+		},
+		{
+			"VP8 layer sync", webrtc.MimeTypeVP8, []byte{0x90, 0xE0, 0x12, 0x05, 0x60, 0x00},
+			rewriter.PacketLayers{Temporal: 1, SwitchUpTo: 1}, true,
+		},
+		{"VP8 truncated", webrtc.MimeTypeVP8, []byte{0x90, 0xE0, 0x12}, rewriter.PacketLayers{}, false},
+
+		{"VP9 without layer indices", webrtc.MimeTypeVP9, []byte{0x08}, rewriter.PacketLayers{}, false},
+		{"VP9 base layer", webrtc.MimeTypeVP9, []byte{0xA8, 0x12, 0x00}, rewriter.PacketLayers{}, true},
+		{"VP9 third temporal layer", webrtc.MimeTypeVP9, []byte{0xA8, 0x80, 0x12, 0x40}, rewriter.PacketLayers{Temporal: 2}, true},
+		{
+			"VP9 second spatial layer", webrtc.MimeTypeVP9, []byte{0xA8, 0x12, 0x22},
+			rewriter.PacketLayers{Spatial: 1, Temporal: 1}, true,
+		},
+		{
+			"VP9 end of the second spatial layer", webrtc.MimeTypeVP9, []byte{0xAC, 0x12, 0x22},
+			rewriter.PacketLayers{Spatial: 1, Temporal: 1, EndOfFrame: true}, true,
+		},
+		{
+			"VP9 flexible mode", webrtc.MimeTypeVP9, []byte{0xF0, 0x12, 0x02, 0x03, 0x02},
+			rewriter.PacketLayers{Spatial: 1}, true,
+		},
+		{
+			"VP9 switching up point", webrtc.MimeTypeVP9, []byte{0xA8, 0x12, 0x10},
+			rewriter.PacketLayers{SwitchUpTo: 7}, true,
+		},
+		{"VP9 truncated", webrtc.MimeTypeVP9, []byte{0xA8, 0x80}, rewriter.PacketLayers{}, false},
+
+		{"H.264", webrtc.MimeTypeH264, []byte{0x65}, rewriter.PacketLayers{}, false},
+	}
+
+	for _, c := range cases {
+		parser := rewriter.NewLayerParser(c.mimeType, 0)
+		layers, found := parser.Parse(&rtp.Packet{Payload: c.payload})
+		if layers != c.expectedLayers || found != c.expectedFound {
+			t.Errorf("%s: expected (%+v, %t), got (%+v, %t)", c.name, c.expectedLayers, c.expectedFound, layers, found)
+		}
+	}
+}
+
+func TestVP9ScalabilityStructure(t *testing.T) {
+	cases := []struct {
+		name                  string
+		payload               []byte
+		expectedSpatialLayers int
+	}{
+		{"Without scalability structure", []byte{0xA8, 0x12, 0x00, 0x05}, 0},
+		{"Non-flexible mode", []byte{0xAA, 0x12, 0x00, 0x05, 0x40}, 3},
+		{"Flexible mode", []byte{0xFA, 0x12, 0x02, 0x03, 0x02, 0x20}, 2},
+	}
+
+	for _, c := range cases {
+		parser := rewriter.NewLayerParser(webrtc.MimeTypeVP9, 0)
+		parser.Parse(&rtp.Packet{Payload: c.payload})
+		if spatialLayers := parser.SpatialLayers(); spatialLayers != c.expectedSpatialLayers {
+			t.Errorf("%s: expected %d spatial layers, got %d", c.name, c.expectedSpatialLayers, spatialLayers)
+		}
+	}
+}
+
+func TestAV1DependencyDescriptor(t *testing.T) {
+	const extensionID = 3
+
+	packet := func(descriptor []byte) *rtp.Packet {
+		packet := &rtp.Packet{Header: rtp.Header{Extension: true, ExtensionProfile: 0xBEDE}}
+		if err := packet.SetExtension(extensionID, descriptor); err != nil {
+			t.Fatal(err)
+		}
+
+		return packet
+	}
+
+	cases := []struct {
+		name string
+		// The keyframe (template 1 or 0) with the template dependency structure.
+		// The decode target indications are: 0 (not present), 1 (discardable), 2 (switch), 3 (required).
+		keyframe              []byte
+		expectedSpatialLayers int
+		// The layers of the following frames that only have the mandatory fields.
+		frames map[byte]rewriter.PacketLayers
+	}{
+		{
+			// template_id_offset = 1, dt_cnt_minus_one = 2, next_layer_idc = 0, 1, 1, 3,
+			// template_dtis = 2 2 2, 2 3 3, 0 2 2, 0 0 1.
+			"Temporal layers",
+			[]byte{0xC1, 0x00, 0x02, 0x80, 0x22, 0x17, 0xAA, 0xF2, 0x81},
+			1,
+			map[byte]rewriter.PacketLayers{
+				0x01: {SwitchUpTo: 2},
+				0x02: {},
+				0x03: {Temporal: 1, SwitchUpTo: 2},
+				0x04: {Temporal: 2},
+				0x44: {Temporal: 2, EndOfFrame: true},
+			},
+		},
+		{
+			// template_id_offset = 0, dt_cnt_minus_one = 3, next_layer_idc = 1, 2, 1, 3,
+			// template_dtis = 2 2 2 2, 0 1 0 1, 0 0 2 2, 0 0 0 1.
+			"Spatial layers",
+			[]byte{0x80, 0x00, 0x01, 0x80, 0x03, 0x67, 0xAA, 0x11, 0x0A, 0x01},
+			2,
+			map[byte]rewriter.PacketLayers{
+				0x00: {SwitchUpTo: 1},
+				0x01: {Temporal: 1},
+				0x02: {Spatial: 1, SwitchUpTo: 1},
+				0x42: {Spatial: 1, EndOfFrame: true, SwitchUpTo: 1},
+				0x03: {Spatial: 1, Temporal: 1},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		parser := rewriter.NewLayerParser(webrtc.MimeTypeAV1, extensionID)
+
+		// Without the template structure (that comes with the keyframe) the layers are not known.
+		if _, found := parser.Parse(packet([]byte{0x01, 0x00, 0x00})); found {
+			t.Errorf("%s: expected unknown layers before the template structure", c.name)
+		}
+
+		if _, found := parser.Parse(packet(c.keyframe)); !found {
+			t.Errorf("%s: expected known layers of the keyframe", c.name)
+		}
+
+		if spatialLayers := parser.SpatialLayers(); spatialLayers != c.expectedSpatialLayers {
+			t.Errorf("%s: expected %d spatial layers, got %d", c.name, c.expectedSpatialLayers, spatialLayers)
+		}
+
+		for firstByte, expected := range c.frames {
+			layers, found := parser.Parse(packet([]byte{firstByte, 0x00, 0x03}))
+			if !found || layers != expected {
+				t.Errorf("%s: frame %#x: expected (%+v, true), got (%+v, %t)", c.name, firstByte, expected, layers, found)
+			}
+		}
+	}
+}
+
+func TestSkippedPackets(t *testing.T) {
+	packetRewriter := rewriter.NewPacketRewriter()
+
+	// The rewriter starts with 0.
+	expectedSequenceNumber := uint16(0)
+	for i := uint16(0); i < 10; i++ {
+		packet := rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 100 + i, Timestamp: 1000 + uint32(i)}}
+
+		// Every other packet is dropped, yet the forwarded ones must be contiguous.
+		if i%2 == 1 {
+			packetRewriter.Skip(packet)
+			continue
+		}
+
+		rewritten := packetRewriter.ProcessIncoming(packet)
+		if rewritten.SequenceNumber != expectedSequenceNumber {
+			t.Errorf("packet %d: expected sequence number %d, got %d", i, expectedSequenceNumber, rewritten.SequenceNumber)
+		}
+
+		expectedSequenceNumber++
+	}
+}
+
+func TestSkippedPacketsOutOfOrder(t *testing.T) {
+	packetRewriter := rewriter.NewPacketRewriter()
+
+	steps := []struct {
+		sequenceNumber uint16
+		skip           bool
+		// The expected outgoing sequence number (-1 if the packet must not be forwarded).
+		expected int
+	}{
+		{sequenceNumber: 100, expected: 0},
+		{sequenceNumber: 102, expected: 2},
+		// The packet after it has been forwarded already, so the gap stays.
+		{sequenceNumber: 101, skip: true},
+		{sequenceNumber: 103, skip: true},
+		{sequenceNumber: 104, expected: 3},
+		// The retransmissions get the same sequence numbers as before.
+		{sequenceNumber: 102, expected: 2},
+		// The gap of the skipped packet is closed already.
+		{sequenceNumber: 103, expected: -1},
+		{sequenceNumber: 106, skip: true},
+		{sequenceNumber: 107, expected: 5},
+		// A late packet only accounts for the packets skipped before it.
+		{sequenceNumber: 105, expected: 4},
+	}
+
+	for i, step := range steps {
+		packet := rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: step.sequenceNumber}}
+		if step.skip {
+			packetRewriter.Skip(packet)
+			continue
+		}
+
+		rewritten := packetRewriter.ProcessIncoming(packet)
+		switch {
+		case step.expected == -1 && rewritten != nil:
+			t.Errorf("step %d: expected the packet to be dropped, got %d", i, rewritten.SequenceNumber)
+		case step.expected != -1 && rewritten == nil:
+			t.Errorf("step %d: expected sequence number %d, got a dropped packet", i, step.expected)
+		case step.expected != -1 && int(rewritten.SequenceNumber) != step.expected:
+			t.Errorf("step %d: expected sequence number %d, got %d", i, step.expected, rewritten.SequenceNumber)
+		}
+	}
+}
@@ -1,32 +1,122 @@
 package rewriter
 
 import (
+	"sort"
+	"strings"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
 )
 
 type RewrittenRTPPacket *rtp.Packet
 
+// The number of the latest skipped packets whose sequence numbers are remembered. The packets that arrive
+// later than any of the forgotten skipped ones are dropped, since we can't tell where they belong anymore.
+const maxTrackedSkips = 512
+
 // A structure that is used to rewrite the RTP packets that are being forwarded.
 type PacketRewriter struct {
 	// The highest identifiers of the outgoing packet returned by the processing
 	// function. This is the **latest** identifier in terms of seqNum and ts, not
 	// the identifier of the **last** forwarded packet.
 	latestOutgoing ExpandedPacketIdentifiers
-	// State of the rewriter. Currently we only have a forwarding state.
-	// We'll also have "switching" state in the future to handle smooth layer switching.
+	// State of the forwarding of the current layer.
 	state forwardingState
+	// The layer that is currently forwarded (only valid if `forwarding` is set).
+	layer      webrtc_ext.SimulcastLayer
+	forwarding bool
+	// Set while switching layers. The current layer is still forwarded until a keyframe
+	// arrives on the target layer, then the rewriter cuts over to the target layer.
+	switching *switchingState
+	// MIME type of the codec, used to detect the keyframes.
+	mimeType string
+	// Rewriter of the payload descriptor (only set for VP8 and VP9).
+	descriptor *descriptorRewriter
+}
+
+// The state of the switch to another layer.
+type switchingState struct {
+	// The layer that we're switching to.
+	target webrtc_ext.SimulcastLayer
 }
 
-// Creates a new instance of the `PacketRewriter`.
+// Creates a new instance of the `PacketRewriter` that forwards the packets as they come, i.e.
+// the layers are switched as soon as the SSRC changes, see `ProcessIncoming()`.
 func NewPacketRewriter() *PacketRewriter {
 	rewriter := new(PacketRewriter)
 	return rewriter
 }
 
-// Process new incoming packet.
+// Creates a new instance of the `PacketRewriter` that forwards a single layer of a track at a time and
+// only switches layers on a keyframe, see `ProcessLayer()`. Nothing is forwarded until the initial layer
+// delivers a keyframe.
+func NewLayerPacketRewriter(mimeType string, layer webrtc_ext.SimulcastLayer) *PacketRewriter {
+	rewriter := &PacketRewriter{
+		switching: &switchingState{target: layer},
+		mimeType:  mimeType,
+	}
+
+	switch {
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP8):
+		rewriter.descriptor = &descriptorRewriter{parse: parseVP8Counters}
+	case strings.EqualFold(mimeType, webrtc.MimeTypeVP9):
+		rewriter.descriptor = &descriptorRewriter{parse: parseVP9Counters}
+	}
+
+	return rewriter
+}
+
+// Starts switching to another layer. The switch completes once the target layer delivers a keyframe.
+func (p *PacketRewriter) SwitchLayer(target webrtc_ext.SimulcastLayer) {
+	if p.switching != nil && p.switching.target == target {
+		return
+	}
+
+	// Switching back to the layer that is still forwarded cancels the switch.
+	if p.forwarding && p.layer == target {
+		p.switching = nil
+		return
+	}
+
+	p.switching = &switchingState{target: target}
+}
+
+// Process new incoming packet of a given layer. Returns nil if the packet must not be forwarded,
+// i.e. if it does not belong to the forwarded layer.
+func (p *PacketRewriter) ProcessLayer(packet rtp.Packet, layer webrtc_ext.SimulcastLayer) RewrittenRTPPacket {
+	if !p.Accepts(&packet, layer) {
+		return nil
+	}
+
+	return p.ProcessIncoming(packet)
+}
+
+// Checks if a packet of a given layer belongs to the forwarded layer, i.e. if it may be forwarded.
+func (p *PacketRewriter) Accepts(packet *rtp.Packet, layer webrtc_ext.SimulcastLayer) bool {
+	// Cut over to the target layer on its first keyframe. The packets that belong to the old layer
+	// are dropped from now on (even if they arrive late), so that the layers never interleave.
+	if p.switching != nil && p.switching.target == layer && IsKeyframe(p.mimeType, packet.Payload) {
+		p.layer, p.forwarding, p.switching = layer, true, nil
+	}
+
+	return p.forwarding && layer == p.layer
+}
+
+// Informs the rewriter that a packet of the forwarded layer is deliberately not forwarded (e.g. since it
+// belongs to a temporal layer that the subscriber does not get), so that the sequence numbers of the
+// forwarded packets stay contiguous.
+func (p *PacketRewriter) Skip(packet rtp.Packet) {
+	p.state.skip(packet.SSRC, packet.SequenceNumber)
+}
+
+// Process new incoming packet. Returns nil if the packet arrived too late to be forwarded.
 func (p *PacketRewriter) ProcessIncoming(packet rtp.Packet) RewrittenRTPPacket {
 	incomingIDs := TruncatedPacketIdentifiers{packet.Timestamp, packet.SequenceNumber}
-	outgoingIDs := p.state.process(packet.SSRC, incomingIDs, p.latestOutgoing)
+	outgoingIDs, ok := p.state.process(packet.SSRC, incomingIDs, p.latestOutgoing)
+	if !ok {
+		return nil
+	}
 
 	// Store the highest outgoing IDs.
 	p.latestOutgoing = p.latestOutgoing.Max(outgoingIDs)
@@ -35,6 +125,11 @@ func (p *PacketRewriter) ProcessIncoming(packet rtp.Packet) RewrittenRTPPacket {
 	packet.Timestamp = uint32(outgoingIDs.timestamp)
 	packet.SequenceNumber = uint16(outgoingIDs.sequenceNumber)
 
+	// The codec-specific counters must stay continuous as well.
+	if p.descriptor != nil {
+		packet.Payload = p.descriptor.process(packet.SSRC, packet.Payload)
+	}
+
 	return &packet
 }
 
@@ -56,17 +151,26 @@ type forwardingState struct {
 	// layers. This is our "base" to calculate the proper timestamp when
 	// forwarding/rewriting the packet.
 	firstOutgoing ExpandedPacketIdentifiers
+	// The (expanded) sequence numbers of the latest packets that have been skipped since switching
+	// layers in ascending order. The outgoing sequence number of a packet is shifted by the number
+	// of the packets that have been skipped before it to close the gaps.
+	skipped []uint32
+	// The number of the skipped packets that are not in the list anymore
+	// and the sequence number of the latest of them.
+	forgottenSkips      uint32
+	latestForgottenSkip uint32
 }
 
 // Processes the incoming IDs and returns the rewritten IDs.
+// Returns false if the packet arrived too late to be forwarded.
 func (s *forwardingState) process(
 	ssrc uint32,
 	incomingIDs TruncatedPacketIdentifiers,
 	latestOutgoing ExpandedPacketIdentifiers,
-) ExpandedPacketIdentifiers {
+) (ExpandedPacketIdentifiers, bool) {
 	// If the SSRCs don't match, then we've switched layers.
 	if s.ssrc != ssrc {
-		return s.reset(ssrc, incomingIDs, latestOutgoing)
+		return s.reset(ssrc, incomingIDs, latestOutgoing), true
 	}
 
 	// Expand the sequence number.
@@ -80,11 +184,23 @@ func (s *forwardingState) process(
 	// Expanded identifiers.
 	expandedIncomingIDs := ExpandedPacketIdentifiers{expandedTimestamp, expandedSequenceNumber}
 
-	// Now we can safely calculate the delta.
+	// We don't know how many of the forgotten skipped packets were before this one.
+	if s.forgottenSkips > 0 && expandedSequenceNumber <= s.latestForgottenSkip {
+		return ExpandedPacketIdentifiers{}, false
+	}
+
+	// The gap of a skipped packet is closed already, so it can't be forwarded later (e.g. when retransmitted).
+	skippedBefore := sort.Search(len(s.skipped), func(i int) bool { return s.skipped[i] >= expandedSequenceNumber })
+	if skippedBefore < len(s.skipped) && s.skipped[skippedBefore] == expandedSequenceNumber {
+		return ExpandedPacketIdentifiers{}, false
+	}
+
+	// Now we can safely calculate the delta (without the packets that were skipped before this one).
 	delta := expandedIncomingIDs.Sub(s.firstIncoming)
+	delta.sequenceNumber -= s.forgottenSkips + uint32(skippedBefore)
 
 	// The outgoing IDs are the delta added to the first outgoing IDs.
-	return s.firstOutgoing.Add(delta)
+	return s.firstOutgoing.Add(delta), true
 }
 
 // Resets the state of the rewriter for a new SSRC (switching layers).
@@ -100,6 +216,7 @@ func (s *forwardingState) reset(
 	// the switching point, and that is now, so the ROC is 0.
 	s.firstIncoming = ExpandedPacketIdentifiers{uint64(incoming.timestamp), uint32(incoming.sequenceNumber)}
 	s.latestIncoming = s.firstIncoming
+	s.skipped, s.forgottenSkips, s.latestForgottenSkip = nil, 0, 0
 
 	// Calculate the delta between the current packet and the previous one.
 	var delta ExpandedPacketIdentifiers
@@ -125,3 +242,29 @@ func (s *forwardingState) reset(
 
 	return outgoingIDs
 }
+
+// Accounts for a packet that is not forwarded. Only the gaps of the packets that arrive in order are
+// closed. The packets after a late one may have been forwarded already, so its gap stays (as if it was lost).
+func (s *forwardingState) skip(ssrc uint32, sequenceNumber uint16) {
+	// Nothing has been forwarded from this SSRC yet, so there are no gaps to close.
+	if s.ssrc != ssrc {
+		return
+	}
+
+	previousSequenceNumber := s.latestIncoming.sequenceNumber
+	latestSequenceNumber := uint64(previousSequenceNumber)
+	expandedSequenceNumber := uint32(ExpandCounter(uint64(sequenceNumber), 16, &latestSequenceNumber))
+	s.latestIncoming.sequenceNumber = uint32(latestSequenceNumber)
+
+	if expandedSequenceNumber <= previousSequenceNumber {
+		return
+	}
+
+	if len(s.skipped) == maxTrackedSkips {
+		s.forgottenSkips++
+		s.latestForgottenSkip = s.skipped[0]
+		s.skipped = s.skipped[1:]
+	}
+
+	s.skipped = append(s.skipped, expandedSequenceNumber)
+}
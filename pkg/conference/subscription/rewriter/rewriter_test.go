@@ -4,7 +4,9 @@ import (
 	"testing"
 
 	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
 )
 
 func TestRewriter(t *testing.T) {
@@ -57,3 +59,50 @@ func TestRewriter(t *testing.T) {
 		}
 	}
 }
+
+func TestLayerSwitching(t *testing.T) {
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+	keyframe, interframe := []byte{0x10, 0x00}, []byte{0x10, 0x01}
+
+	cases := []struct {
+		layer          webrtc_ext.SimulcastLayer
+		switchTo       webrtc_ext.SimulcastLayer
+		seqNum         uint16
+		payload        []byte
+		expectedSeqNum int // -1 if the packet must be dropped
+	}{
+		{low, low, 100, interframe, -1},   // nothing is forwarded before the first keyframe
+		{low, low, 101, keyframe, 0},      // first keyframe
+		{low, low, 102, interframe, 1},    // normal packet
+		{high, low, 500, keyframe, -1},    // the other layer is not forwarded
+		{low, high, 103, interframe, 2},   // switching, the old layer is still forwarded
+		{high, high, 501, interframe, -1}, // no keyframe on the target layer yet
+		{low, high, 104, interframe, 3},   // still switching
+		{high, high, 502, keyframe, 5},    // keyframe on the target layer, cut over
+		{low, high, 105, interframe, -1},  // late packet of the old layer
+		{high, high, 503, interframe, 6},  // normal packet
+		{low, low, 106, interframe, -1},   // switching back
+		{high, high, 504, interframe, 7},  // switch cancelled before the keyframe
+	}
+
+	packetRewriter := rewriter.NewLayerPacketRewriter(webrtc.MimeTypeVP8, low)
+
+	for i, c := range cases {
+		packetRewriter.SwitchLayer(c.switchTo)
+
+		packet := rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: c.seqNum, SSRC: uint32(c.layer)},
+			Payload: c.payload,
+		}
+
+		rewritten := packetRewriter.ProcessLayer(packet, c.layer)
+		switch {
+		case c.expectedSeqNum == -1 && rewritten != nil:
+			t.Fatalf("case %d: expected packet to be dropped", i)
+		case c.expectedSeqNum != -1 && rewritten == nil:
+			t.Fatalf("case %d: expected packet to be forwarded", i)
+		case rewritten != nil && int(rewritten.SequenceNumber) != c.expectedSeqNum:
+			t.Fatalf("case %d: expected seqNum %d, got %d", i, c.expectedSeqNum, rewritten.SequenceNumber)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package rewriter
+
+// Positions of the fields within the VP8 payload descriptor (see RFC 7741, section 4.2).
+type vp8Descriptor struct {
+	descriptorCounters
+	// Offset of the TID/Y/KEYIDX byte if the TID is present (-1 otherwise).
+	temporalIDOffset int
+}
+
+// Parses the extension of the VP8 payload descriptor. Returns false if the
+// descriptor has no extension (i.e. none of the fields) or is malformed.
+func parseVP8Descriptor(payload []byte) (vp8Descriptor, bool) {
+	descriptor := vp8Descriptor{descriptorCounters: noDescriptorCounters(), temporalIDOffset: -1}
+
+	if len(payload) < 2 || payload[0]&0x80 == 0 {
+		return descriptor, false
+	}
+
+	extension := payload[1]
+	offset := 2
+
+	if extension&0x80 != 0 {
+		if len(payload) < offset+1 {
+			return descriptor, false
+		}
+
+		descriptor.pictureIDOffset = offset
+		descriptor.pictureIDWidth = 7
+		if payload[offset]&0x80 != 0 {
+			descriptor.pictureIDWidth = 15
+			offset++
+		}
+
+		offset++
+	}
+
+	if extension&0x40 != 0 {
+		descriptor.tl0PicIdxOffset = offset
+		offset++
+	}
+
+	// The TID and the KEYIDX share a single byte.
+	if extension&0x20 != 0 || extension&0x10 != 0 {
+		if extension&0x20 != 0 {
+			descriptor.temporalIDOffset = offset
+		}
+
+		if extension&0x10 != 0 {
+			descriptor.keyIdxOffset = offset
+		}
+
+		offset++
+	}
+
+	if len(payload) < offset {
+		return descriptor, false
+	}
+
+	return descriptor, true
+}
+
+// Returns the counters of the VP8 payload descriptor.
+func parseVP8Counters(payload []byte) (descriptorCounters, bool) {
+	descriptor, found := parseVP8Descriptor(payload)
+	return descriptor.descriptorCounters, found
+}
@@ -0,0 +1,133 @@
+package rewriter_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestVP8DescriptorRewriting(t *testing.T) {
+	// Builds a VP8 payload with a 15-bit PictureID and TL0PICIDX.
+	vp8Payload := func(pictureID uint16, tl0PicIdx uint8, keyframe bool) []byte {
+		header := byte(0x01)
+		if keyframe {
+			header = 0x00
+		}
+
+		return []byte{0x90, 0xC0, 0x80 | byte(pictureID>>8), byte(pictureID), tl0PicIdx, header}
+	}
+
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+
+	cases := []struct {
+		layer             webrtc_ext.SimulcastLayer
+		pictureID         uint16
+		tl0PicIdx         uint8
+		keyframe          bool
+		expectedPictureID uint16
+		expectedTL0PicIdx uint8
+	}{
+		{low, 1000, 10, true, 1000, 10},      // first packet, the values are kept
+		{low, 1000, 10, false, 1000, 10},     // same frame
+		{low, 1001, 11, false, 1001, 11},     // next frame
+		{high, 20000, 200, true, 1002, 12},   // layer switch, the counters continue
+		{high, 20001, 200, false, 1003, 12},  // next frame (not a TL0 frame)
+		{high, 32767, 255, false, 13769, 67}, // gap in the incoming counters is kept
+		{high, 0, 0, false, 13770, 68},       // incoming counters wrap around
+		{low, 1500, 50, true, 13771, 69},     // layer switch again
+		{low, 1499, 49, false, 13770, 68},    // packet that arrives out-of-order
+		{low, 1501, 51, false, 13772, 70},    // normal packet
+	}
+
+	packetRewriter := rewriter.NewLayerPacketRewriter(webrtc.MimeTypeVP8, low)
+
+	for i, c := range cases {
+		packetRewriter.SwitchLayer(c.layer)
+
+		payload := vp8Payload(c.pictureID, c.tl0PicIdx, c.keyframe)
+		original := append([]byte{}, payload...)
+
+		packet := rtp.Packet{
+			Header:  rtp.Header{SSRC: uint32(c.layer)},
+			Payload: payload,
+		}
+
+		rewritten := packetRewriter.ProcessLayer(packet, c.layer)
+		if rewritten == nil {
+			t.Fatalf("case %d: expected packet to be forwarded", i)
+		}
+
+		pictureID := uint16(rewritten.Payload[2]&0x7F)<<8 | uint16(rewritten.Payload[3])
+		if pictureID != c.expectedPictureID {
+			t.Fatalf("case %d: expected PictureID %d, got %d", i, c.expectedPictureID, pictureID)
+		}
+
+		if tl0PicIdx := rewritten.Payload[4]; tl0PicIdx != c.expectedTL0PicIdx {
+			t.Fatalf("case %d: expected TL0PICIDX %d, got %d", i, c.expectedTL0PicIdx, tl0PicIdx)
+		}
+
+		// The original payload is shared with other subscribers, so it must not change.
+		if string(payload) != string(original) {
+			t.Fatalf("case %d: original payload has been modified", i)
+		}
+	}
+}
+
+func TestVP8KeyIdxRewriting(t *testing.T) {
+	// Builds a VP8 payload with the TID (and the layer sync bit) and the KEYIDX.
+	vp8Payload := func(temporalID, keyIdx uint8, keyframe bool) []byte {
+		header := byte(0x01)
+		if keyframe {
+			header = 0x00
+		}
+
+		return []byte{0x90, 0x30, temporalID<<6 | 0x20 | keyIdx, header}
+	}
+
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+
+	cases := []struct {
+		layer          webrtc_ext.SimulcastLayer
+		temporalID     uint8
+		keyIdx         uint8
+		keyframe       bool
+		expectedKeyIdx uint8
+	}{
+		{low, 0, 3, true, 3},    // first packet, the value is kept
+		{low, 1, 3, false, 3},   // same key frame
+		{low, 0, 4, true, 4},    // next key frame
+		{high, 0, 30, true, 5},  // layer switch, the index continues
+		{high, 2, 30, false, 5}, // same key frame
+		{high, 0, 31, true, 6},  // next key frame
+		{high, 0, 0, true, 7},   // incoming index wraps around
+		{low, 0, 9, true, 8},    // layer switch again
+	}
+
+	packetRewriter := rewriter.NewLayerPacketRewriter(webrtc.MimeTypeVP8, low)
+
+	for i, c := range cases {
+		packetRewriter.SwitchLayer(c.layer)
+
+		packet := rtp.Packet{
+			Header:  rtp.Header{SSRC: uint32(c.layer)},
+			Payload: vp8Payload(c.temporalID, c.keyIdx, c.keyframe),
+		}
+
+		rewritten := packetRewriter.ProcessLayer(packet, c.layer)
+		if rewritten == nil {
+			t.Fatalf("case %d: expected packet to be forwarded", i)
+		}
+
+		if keyIdx := rewritten.Payload[2] & 0x1F; keyIdx != c.expectedKeyIdx {
+			t.Fatalf("case %d: expected KEYIDX %d, got %d", i, c.expectedKeyIdx, keyIdx)
+		}
+
+		// The TID and the layer sync bit are kept as they are.
+		if rewritten.Payload[2]&0xE0 != packet.Payload[2]&0xE0 {
+			t.Fatalf("case %d: the TID has been modified", i)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package rewriter
+
+// Returns the counters of the VP9 payload descriptor (see RFC 9628, section 4.2). The TL0PICIDX is only
+// present in the non-flexible mode, in the flexible mode the references are relative to the PictureID.
+func parseVP9Counters(payload []byte) (descriptorCounters, bool) {
+	counters := noDescriptorCounters()
+
+	if len(payload) < 1 {
+		return counters, false
+	}
+
+	descriptor := payload[0]
+	pictureIDPresent := descriptor&0x80 != 0
+	layerIndicesPresent := descriptor&0x20 != 0
+	flexibleMode := descriptor&0x10 != 0
+
+	offset := 1
+	if pictureIDPresent {
+		if len(payload) < offset+1 {
+			return counters, false
+		}
+
+		counters.pictureIDOffset = offset
+		counters.pictureIDWidth = 7
+		if payload[offset]&0x80 != 0 {
+			counters.pictureIDWidth = 15
+			offset++
+		}
+
+		offset++
+	}
+
+	if layerIndicesPresent && !flexibleMode {
+		// The TL0PICIDX follows the byte with the layer indices.
+		counters.tl0PicIdxOffset = offset + 1
+		offset += 2
+	}
+
+	if len(payload) < offset {
+		return counters, false
+	}
+
+	return counters, true
+}
@@ -0,0 +1,109 @@
+package rewriter_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestVP9DescriptorRewriting(t *testing.T) {
+	// Builds a VP9 payload (non-flexible mode) with a 15-bit PictureID, the layer indices and TL0PICIDX.
+	vp9Payload := func(pictureID uint16, tl0PicIdx uint8, keyframe bool) []byte {
+		// I, L and B bits, the P bit is set on the inter-predicted pictures.
+		descriptor := byte(0xA8)
+		if !keyframe {
+			descriptor |= 0x40
+		}
+
+		return []byte{descriptor, 0x80 | byte(pictureID>>8), byte(pictureID), 0x00, tl0PicIdx}
+	}
+
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+
+	cases := []struct {
+		layer             webrtc_ext.SimulcastLayer
+		pictureID         uint16
+		tl0PicIdx         uint8
+		keyframe          bool
+		expectedPictureID uint16
+		expectedTL0PicIdx uint8
+	}{
+		{low, 500, 100, true, 500, 100},     // first packet, the values are kept
+		{low, 501, 101, false, 501, 101},    // next picture
+		{high, 30000, 7, true, 502, 102},    // layer switch, the counters continue
+		{high, 30001, 8, false, 503, 103},   // next picture
+		{high, 32767, 255, false, 3269, 94}, // gap in the incoming counters is kept
+		{high, 0, 0, false, 3270, 95},       // incoming counters wrap around
+		{low, 600, 120, true, 3271, 96},     // layer switch again
+		{low, 599, 119, false, 3270, 95},    // packet that arrives out-of-order
+	}
+
+	packetRewriter := rewriter.NewLayerPacketRewriter(webrtc.MimeTypeVP9, low)
+
+	for i, c := range cases {
+		packetRewriter.SwitchLayer(c.layer)
+
+		payload := vp9Payload(c.pictureID, c.tl0PicIdx, c.keyframe)
+		original := append([]byte{}, payload...)
+
+		packet := rtp.Packet{Header: rtp.Header{SSRC: uint32(c.layer)}, Payload: payload}
+
+		rewritten := packetRewriter.ProcessLayer(packet, c.layer)
+		if rewritten == nil {
+			t.Fatalf("case %d: expected packet to be forwarded", i)
+		}
+
+		pictureID := uint16(rewritten.Payload[1]&0x7F)<<8 | uint16(rewritten.Payload[2])
+		if pictureID != c.expectedPictureID {
+			t.Fatalf("case %d: expected PictureID %d, got %d", i, c.expectedPictureID, pictureID)
+		}
+
+		if tl0PicIdx := rewritten.Payload[4]; tl0PicIdx != c.expectedTL0PicIdx {
+			t.Fatalf("case %d: expected TL0PICIDX %d, got %d", i, c.expectedTL0PicIdx, tl0PicIdx)
+		}
+
+		// The layer indices are kept as they are.
+		if rewritten.Payload[0] != payload[0] || rewritten.Payload[3] != payload[3] {
+			t.Fatalf("case %d: unexpected descriptor %v", i, rewritten.Payload)
+		}
+
+		// The original payload is shared with other subscribers, so it must not change.
+		if string(payload) != string(original) {
+			t.Fatalf("case %d: original payload has been modified", i)
+		}
+	}
+}
+
+func TestVP9FlexibleModeRewriting(t *testing.T) {
+	// I, L, F and B bits, 7-bit PictureID, the layer indices and no TL0PICIDX in the flexible mode.
+	keyframe := []byte{0xB8, 10, 0x00, 0xFF}
+	// P bit as well and a reference index (P_DIFF) instead of the TL0PICIDX.
+	interframe := []byte{0xF8, 11, 0x00, 0x02}
+
+	low, high := webrtc_ext.SimulcastLayerLow, webrtc_ext.SimulcastLayerHigh
+	packetRewriter := rewriter.NewLayerPacketRewriter(webrtc.MimeTypeVP9, low)
+
+	packetRewriter.ProcessLayer(rtp.Packet{Header: rtp.Header{SSRC: 1}, Payload: keyframe}, low)
+	packetRewriter.ProcessLayer(rtp.Packet{Header: rtp.Header{SSRC: 1}, Payload: interframe}, low)
+
+	packetRewriter.SwitchLayer(high)
+	rewritten := packetRewriter.ProcessLayer(
+		rtp.Packet{Header: rtp.Header{SSRC: 2}, Payload: []byte{0xB8, 90, 0x00, 0xFF}},
+		high,
+	)
+	if rewritten == nil {
+		t.Fatal("expected the keyframe of the new layer to be forwarded")
+	}
+
+	if pictureID := rewritten.Payload[1]; pictureID != 12 {
+		t.Errorf("expected PictureID 12, got %d", pictureID)
+	}
+
+	// The byte after the layer indices is not a TL0PICIDX in the flexible mode.
+	if rewritten.Payload[3] != 0xFF {
+		t.Errorf("expected the byte after the layer indices to be kept, got %d", rewritten.Payload[3])
+	}
+}
@@ -0,0 +1,40 @@
+package subscription
+
+import (
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/pion/rtp"
+)
+
+// Decides which spatial layers of an SVC stream the subscriber gets. Only used by the worker.
+type spatialFilter struct {
+	// The highest spatial layer that is forwarded, only changes at the picture boundaries.
+	limit int
+	// RTP timestamp of the latest picture.
+	timestamp uint32
+	started   bool
+}
+
+// Decides if a packet of given layers is forwarded to the subscriber that wants to get the spatial layers up to
+// `target`. The upper spatial layers depend on the previous frames of the same layer, so we can only switch up
+// on a keyframe, whereas the lower layers never depend on the upper ones, so we can switch down on any picture.
+func (f *spatialFilter) forward(packet *rtp.Packet, layers rewriter.PacketLayers, target int, keyframe bool) bool {
+	if !f.started || packet.Timestamp != f.timestamp {
+		if !f.started || target < f.limit || keyframe {
+			f.limit = target
+		}
+
+		f.started, f.timestamp = true, packet.Timestamp
+	}
+
+	if layers.Spatial > f.limit {
+		return false
+	}
+
+	// The marker is set on the last packet of the highest spatial layer, which we may have dropped,
+	// so we set it on the last packet of the highest forwarded layer instead.
+	if layers.Spatial == f.limit && layers.EndOfFrame {
+		packet.Marker = true
+	}
+
+	return true
+}
@@ -8,8 +8,9 @@ import (
 
 type Subscription interface {
 	Unsubscribe() error
-	WriteRTP(packet rtp.Packet) error
+	WriteRTP(packet rtp.Packet, simulcast webrtc_ext.SimulcastLayer) error
 	SwitchLayer(simulcast webrtc_ext.SimulcastLayer)
+	SetMaxFrameRate(frameRate int)
 	Simulcast() webrtc_ext.SimulcastLayer
 }
 
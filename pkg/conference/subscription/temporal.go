@@ -0,0 +1,112 @@
+package subscription
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/subscription/rewriter"
+	"github.com/pion/rtp"
+)
+
+// Fraction of the lost packets (in 1/256 units, as in the RTCP receiver reports) above which the subscriber is
+// considered congested and gets one temporal layer less, and below which it gets one temporal layer more.
+const (
+	congestedFractionLost   = 25 // ~10%
+	uncongestedFractionLost = 5  // ~2%
+)
+
+// Decides which temporal layers of the forwarded layer the subscriber gets. The higher temporal layers
+// are dropped if the subscriber asked for a lower frame rate than the one of the stream or if it's congested.
+type temporalFilter struct {
+	// Frame rate that the subscriber asked for (0 if not limited).
+	maxFrameRate atomic.Int32
+	// How many of the highest temporal layers are dropped due to the congestion.
+	congestionLevel atomic.Int32
+	// The highest temporal layer that we've seen in the stream.
+	highestLayer atomic.Int32
+
+	// The highest temporal layer that is forwarded, only changes at the frame boundaries.
+	limit int
+	// RTP timestamp of the latest frame.
+	timestamp uint32
+	started   bool
+
+	// Measurement of the frame rate of the stream (before dropping anything).
+	frameRate   float64
+	frames      int
+	windowStart time.Time
+}
+
+func newTemporalFilter() *temporalFilter {
+	return &temporalFilter{}
+}
+
+// Decides if a packet of given layers is forwarded to the subscriber. The higher temporal layers depend on
+// the previous frames of their layer, so we can only switch up on a keyframe or at a switching point that the
+// stream marks, whereas the lower layers never depend on the higher ones, so we can switch down on any frame.
+func (f *temporalFilter) forward(packet *rtp.Packet, layers rewriter.PacketLayers, keyframe bool, now time.Time) bool {
+	if int32(layers.Temporal) > f.highestLayer.Load() {
+		f.highestLayer.Store(int32(layers.Temporal))
+	}
+
+	// The limit changes only when a new frame starts, so that the frames are never forwarded partially.
+	if !f.started || packet.Timestamp != f.timestamp {
+		f.started, f.timestamp = true, packet.Timestamp
+		f.measureFrameRate(now)
+
+		switch target := f.currentLimit(); {
+		case target <= f.limit || keyframe:
+			f.limit = target
+		case layers.SwitchUpTo >= target:
+			f.limit = target
+		case layers.SwitchUpTo > f.limit:
+			f.limit = layers.SwitchUpTo
+		}
+	}
+
+	return layers.Temporal <= f.limit
+}
+
+func (f *temporalFilter) measureFrameRate(now time.Time) {
+	if f.windowStart.IsZero() {
+		f.windowStart = now
+	}
+
+	f.frames++
+
+	if elapsed := now.Sub(f.windowStart); elapsed >= time.Second {
+		f.frameRate = float64(f.frames) / elapsed.Seconds()
+		f.frames = 0
+		f.windowStart = now
+	}
+}
+
+func (f *temporalFilter) currentLimit() int {
+	limit := int(f.highestLayer.Load())
+
+	// Assuming the usual (dyadic) structure, each temporal layer that we drop halves the frame rate.
+	if maxFrameRate := float64(f.maxFrameRate.Load()); maxFrameRate > 0 && f.frameRate > 0 {
+		// Allow a small tolerance, so that the jitter of the measurement does not make us drop a layer.
+		for frameRate := f.frameRate; limit > 0 && frameRate > maxFrameRate*1.1; frameRate /= 2 {
+			limit--
+		}
+	}
+
+	limit -= int(f.congestionLevel.Load())
+	if limit < 0 {
+		limit = 0
+	}
+
+	return limit
+}
+
+// Adapts the temporal layers to the losses that the subscriber reports. The base layer is never dropped.
+func (f *temporalFilter) processLossReport(fractionLost uint8) {
+	level := f.congestionLevel.Load()
+	switch {
+	case fractionLost > congestedFractionLost && level < f.highestLayer.Load():
+		f.congestionLevel.Store(level + 1)
+	case fractionLost < uncongestedFractionLost && level > 0:
+		f.congestionLevel.Store(level - 1)
+	}
+}
@@ -21,12 +21,17 @@ type RequestKeyFrameFn = func(track webrtc_ext.TrackInfo, simulcast webrtc_ext.S
 type VideoSubscription struct {
 	rtpSender *webrtc.RTPSender
 
-	info         webrtc_ext.TrackInfo
+	info webrtc_ext.TrackInfo
+	// The layer that we're subscribed to (that we're switching to if it's not forwarded yet).
 	currentLayer atomic.Int32 // atomic webrtc_ext.SimulcastLayer
+	// The layer that is actually forwarded (differs from `currentLayer` while switching layers).
+	forwardedLayer atomic.Int32 // atomic webrtc_ext.SimulcastLayer
+	// Decides which temporal layers of the forwarded layer are forwarded.
+	temporalFilter *temporalFilter
 
 	controller        SubscriptionController
 	requestKeyFrameFn RequestKeyFrameFn
-	worker            *worker.Worker[rtp.Packet]
+	worker            *worker.Worker[layerPacket]
 	logger            *logrus.Entry
 }
 
@@ -48,29 +53,33 @@ func NewVideoSubscription(
 		return nil, fmt.Errorf("Failed to add track: %s", err)
 	}
 
-	// Atomic version of the webrtc_ext.SimulcastLayer.
-	var currentLayer atomic.Int32
-	currentLayer.Store(int32(simulcast))
-
 	// Create a subscription.
 	subscription := &VideoSubscription{
 		rtpSender,
 		info,
-		currentLayer,
+		atomic.Int32{},
+		atomic.Int32{},
+		newTemporalFilter(),
 		controller,
 		requestKeyFrameFn,
 		nil,
 		logger,
 	}
 
+	// Atomic version of the webrtc_ext.SimulcastLayer.
+	subscription.currentLayer.Store(int32(simulcast))
+	subscription.forwardedLayer.Store(int32(simulcast))
+
 	// Create a worker state.
 	workerState := workerState{
-		packetRewriter: rewriter.NewPacketRewriter(),
+		packetRewriter: rewriter.NewLayerPacketRewriter(info.Codec.MimeType, simulcast),
+		layerParser:    rewriter.NewLayerParser(info.Codec.MimeType, info.DependencyDescriptorID),
 		rtpTrack:       rtpTrack,
+		subscription:   subscription,
 	}
 
 	// Configure the worker for the subscription.
-	workerConfig := worker.Config[rtp.Packet]{
+	workerConfig := worker.Config[layerPacket]{
 		ChannelSize: 32,
 		Timeout:     3 * time.Second,
 		OnTimeout: func() {
@@ -99,17 +108,31 @@ func (s *VideoSubscription) Unsubscribe() error {
 	return s.controller.RemoveTrack(s.rtpSender)
 }
 
-func (s *VideoSubscription) WriteRTP(packet rtp.Packet) error {
+func (s *VideoSubscription) WriteRTP(packet rtp.Packet, simulcast webrtc_ext.SimulcastLayer) error {
+	// Only the forwarded layer and the one that we're switching to are of interest. A track without simulcast
+	// has a single layer (that may carry the SVC layers), so all of its packets are of interest.
+	current, forwarded := s.currentLayer.Load(), s.forwardedLayer.Load()
+	if simulcast != webrtc_ext.SimulcastLayerNone && int32(simulcast) != current && int32(simulcast) != forwarded {
+		return nil
+	}
+
 	// Send the packet to the worker.
-	return s.worker.Send(packet)
+	return s.worker.Send(layerPacket{packet, simulcast})
 }
 
+// Switches to another layer. The current layer is forwarded until the new one delivers a keyframe,
+// so that the subscriber does not get the frames that it can't decode.
 func (s *VideoSubscription) SwitchLayer(simulcast webrtc_ext.SimulcastLayer) {
 	s.logger.Infof("Switching layer on %s to %s", s.info.TrackID, simulcast)
 	s.currentLayer.Store(int32(simulcast))
 	s.requestKeyFrame()
 }
 
+// Limits the frame rate that the subscriber gets (0 for no limit) by dropping the higher temporal layers.
+func (s *VideoSubscription) SetMaxFrameRate(frameRate int) {
+	s.temporalFilter.maxFrameRate.Store(int32(frameRate))
+}
+
 func (s *VideoSubscription) TrackInfo() webrtc_ext.TrackInfo {
 	return s.info
 }
@@ -120,6 +143,11 @@ func (s *VideoSubscription) Simulcast() webrtc_ext.SimulcastLayer {
 
 // Read incoming RTCP packets. Before these packets are returned they are processed by interceptors.
 func (s *VideoSubscription) readRTCP() {
+	var ssrc webrtc.SSRC
+	if encodings := s.rtpSender.GetParameters().Encodings; len(encodings) > 0 {
+		ssrc = encodings[0].SSRC
+	}
+
 	for {
 		packets, _, err := s.rtpSender.ReadRTCP()
 		if err != nil {
@@ -133,10 +161,17 @@ func (s *VideoSubscription) readRTCP() {
 
 		// We only want to inform others about PLIs and FIRs. We skip the rest of the packets for now.
 		for _, packet := range packets {
-			switch packet.(type) {
+			switch packet := packet.(type) {
 			// For simplicity we assume that any of the key frame requests is just a key frame request.
 			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
 				s.requestKeyFrame()
+			// The losses that the subscriber reports tell us if it's congested.
+			case *rtcp.ReceiverReport:
+				for _, report := range packet.Reports {
+					if report.SSRC == uint32(ssrc) {
+						s.temporalFilter.processLossReport(report.FractionLost)
+					}
+				}
 			}
 		}
 	}
@@ -149,14 +184,83 @@ func (s *VideoSubscription) requestKeyFrame() {
 	}
 }
 
+// An RTP packet along with the simulcast layer that it belongs to.
+type layerPacket struct {
+	packet rtp.Packet
+	layer  webrtc_ext.SimulcastLayer
+}
+
 // Internal state of a worker that runs in its own goroutine.
 type workerState struct {
 	// Rewriter of the packet IDs.
 	packetRewriter *rewriter.PacketRewriter
+	// Extracts the spatial and temporal layers of the forwarded packets.
+	layerParser *rewriter.LayerParser
+	// Decides which spatial layers of an SVC stream are forwarded.
+	spatialFilter spatialFilter
 	// Undelying output track.
 	rtpTrack *webrtc.TrackLocalStaticRTP
+	// The subscription that the worker belongs to.
+	subscription *VideoSubscription
 }
 
-func (w *workerState) handlePacket(packet rtp.Packet) {
-	w.rtpTrack.WriteRTP(w.packetRewriter.ProcessIncoming(packet))
+func (w *workerState) handlePacket(packet layerPacket) {
+	requestedLayer := webrtc_ext.SimulcastLayer(w.subscription.currentLayer.Load())
+
+	// An SVC stream is a single layer (no simulcast), the requested layer selects its spatial layers instead.
+	svc := packet.layer == webrtc_ext.SimulcastLayerNone
+	if svc {
+		w.packetRewriter.SwitchLayer(webrtc_ext.SimulcastLayerNone)
+	} else {
+		w.packetRewriter.SwitchLayer(requestedLayer)
+	}
+
+	if !w.packetRewriter.Accepts(&packet.packet, packet.layer) {
+		return
+	}
+
+	if !svc && int32(packet.layer) != w.subscription.forwardedLayer.Load() {
+		w.subscription.logger.Infof("Switched layer on %s to %s", w.subscription.info.TrackID, packet.layer)
+		w.subscription.forwardedLayer.Store(int32(packet.layer))
+	}
+
+	// Drop the spatial and temporal layers that the subscriber does not get (if the stream has any).
+	layers, found := w.layerParser.Parse(&packet.packet)
+	keyframe := found && rewriter.IsKeyframe(w.subscription.info.Codec.MimeType, packet.packet.Payload)
+	if found && svc && !w.forwardSpatialLayer(&packet.packet, layers, requestedLayer, keyframe) {
+		w.packetRewriter.Skip(packet.packet)
+		return
+	}
+
+	if found && !w.subscription.temporalFilter.forward(&packet.packet, layers, keyframe, time.Now()) {
+		w.packetRewriter.Skip(packet.packet)
+		return
+	}
+
+	if rewritten := w.packetRewriter.ProcessIncoming(packet.packet); rewritten != nil {
+		w.rtpTrack.WriteRTP(rewritten)
+	}
+}
+
+func (w *workerState) forwardSpatialLayer(
+	packet *rtp.Packet,
+	layers rewriter.PacketLayers,
+	requestedLayer webrtc_ext.SimulcastLayer,
+	keyframe bool,
+) bool {
+	// Until the stream announces its structure, we don't know which spatial layers correspond to the requested one.
+	spatialLayers := w.layerParser.SpatialLayers()
+	if spatialLayers == 0 {
+		return true
+	}
+
+	target := webrtc_ext.SimulcastLayerToSpatialLayer(requestedLayer, spatialLayers)
+
+	previousLimit := w.spatialFilter.limit
+	forward := w.spatialFilter.forward(packet, layers, target, keyframe)
+	if w.spatialFilter.limit != previousLimit {
+		w.subscription.logger.Infof("Switched spatial layer on %s to %d", w.subscription.info.TrackID, w.spatialFilter.limit)
+	}
+
+	return forward
 }
@@ -0,0 +1,48 @@
+package conference
+
+import (
+	"errors"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"maunium.net/go/mautrix/event"
+)
+
+// Data channel message that informs the participant that it could not be subscribed to a track.
+var focusCallTrackSubscriptionError = event.Type{Type: "m.call.track_subscription_error", Class: event.FocusEventType}
+
+type focusCallTrackSubscriptionErrorEventContent struct {
+	StreamID string `json:"stream_id"`
+	TrackID  string `json:"track_id"`
+	// Machine-readable reason of the failure, see `subscriptionErrorReason()`.
+	Reason string `json:"reason"`
+	// Human-readable description of the failure.
+	Error string `json:"error"`
+}
+
+const (
+	// The participant did not negotiate any of the codecs that the track is published with.
+	subscriptionErrorIncompatibleCodec = "incompatible_codec"
+	subscriptionErrorFailed            = "failed"
+)
+
+func subscriptionErrorReason(err error) string {
+	if errors.Is(err, participant.ErrIncompatibleCodec) {
+		return subscriptionErrorIncompatibleCodec
+	}
+
+	return subscriptionErrorFailed
+}
+
+func (c *Conference) sendSubscriptionError(p *participant.Participant, track event.FocusTrackDescription, err error) {
+	p.SendDataChannelMessage(event.Event{
+		Type: focusCallTrackSubscriptionError,
+		Content: event.Content{
+			Parsed: focusCallTrackSubscriptionErrorEventContent{
+				StreamID: track.StreamID,
+				TrackID:  track.TrackID,
+				Reason:   subscriptionErrorReason(err),
+				Error:    err.Error(),
+			},
+		},
+	})
+}
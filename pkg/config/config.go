@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/matrix-org/waterfall/pkg/admin"
+	"github.com/matrix-org/waterfall/pkg/auth"
 	"github.com/matrix-org/waterfall/pkg/conference"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
@@ -14,14 +16,25 @@ import (
 
 // SFU configuration.
 type Config struct {
+	// Signaling transport: "matrix" (default) or "websocket".
+	Transport string `yaml:"transport"`
 	// Matrix configuration.
 	Matrix signaling.Config `yaml:"matrix"`
+	// WebSocket signaling configuration (if the WebSocket transport is used).
+	WebSocket signaling.WebSocketConfig `yaml:"websocket"`
 	// Conference (call) configuration.
 	Conference conference.Config `yaml:"conference"`
+	// Directory where the SFU keeps its state across the restarts (e.g. the sync token).
+	// Nothing is persisted if empty.
+	DataDirectory string `yaml:"dataDirectory"`
 	// Starting from which level to log stuff.
 	LogLevel string `yaml:"log"`
 	// WebRTC configuration.
 	WebRTC webrtc_ext.Config `yaml:"webrtc"`
+	// Admin API configuration.
+	Admin admin.Config `yaml:"admin"`
+	// Who may start and join the conferences (everyone by default).
+	Authorization auth.Config `yaml:"authorization"`
 }
 
 // Tries to load a config from the `CONFIG` environment variable.
@@ -85,14 +98,32 @@ func LoadConfigFromString(configString string) (*Config, error) {
 }
 
 func validateConfig(config Config) error {
-	if config.Matrix.UserID == "" {
-		return fmt.Errorf("you must set matrix.userId")
-	}
-	if config.Matrix.HomeserverURL == "" {
-		return fmt.Errorf("you must set matrix.homeserverUrl")
-	}
-	if config.Matrix.AccessToken == "" {
-		return fmt.Errorf("you must set matrix.accessToken")
+	switch config.Transport {
+	case signaling.TransportMatrix, "":
+		if config.Matrix.UserID == "" {
+			return fmt.Errorf("you must set matrix.userId")
+		}
+		if config.Matrix.HomeserverURL == "" {
+			return fmt.Errorf("you must set matrix.homeserverUrl")
+		}
+		if config.Matrix.AccessToken == "" {
+			return fmt.Errorf("you must set matrix.accessToken")
+		}
+		if config.Matrix.Encryption && config.DataDirectory == "" {
+			return fmt.Errorf("you must set dataDirectory if matrix.encryption is enabled")
+		}
+	case signaling.TransportWebSocket:
+		if config.WebSocket.ListenAddress == "" {
+			return fmt.Errorf("you must set websocket.listen")
+		}
+		if config.WebSocket.UserID == "" || config.WebSocket.DeviceID == "" {
+			return fmt.Errorf("you must set websocket.userId and websocket.deviceId")
+		}
+		if config.WebSocket.Secret == "" {
+			return fmt.Errorf("you must set websocket.secret")
+		}
+	default:
+		return fmt.Errorf("unknown transport: %s", config.Transport)
 	}
 	if config.Conference.HeartbeatConfig.Timeout == 0 {
 		return fmt.Errorf("you must set heartbeat.timeout")
@@ -100,6 +131,23 @@ func validateConfig(config Config) error {
 	if config.Conference.HeartbeatConfig.Interval == 0 {
 		return fmt.Errorf("you must set heartbeat.interval")
 	}
+	switch config.Conference.FocusSelection.Fallback {
+	case conference.FocusFallbackAccept, conference.FocusFallbackReject, "":
+	default:
+		return fmt.Errorf("unknown conference.focusSelection.fallback: %s", config.Conference.FocusSelection.Fallback)
+	}
+	if config.Conference.FocusSelection.RejectsUnknown() && config.Transport == signaling.TransportWebSocket {
+		return fmt.Errorf("conference.focusSelection.fallback: reject is only supported with the matrix transport")
+	}
+	if window := config.Conference.CandidateBatchWindow; window != nil && *window < 0 {
+		return fmt.Errorf("conference.candidateBatchWindow must not be negative")
+	}
+	if config.Authorization.RequireRoomMembership && config.Transport == signaling.TransportWebSocket {
+		return fmt.Errorf("authorization.requireRoomMembership is only supported with the matrix transport")
+	}
+	if config.Admin.ListenAddress != "" && config.Admin.Token == "" {
+		return fmt.Errorf("you must set admin.token if the admin API is enabled")
+	}
 
 	// Make sure the heartbeat values are within sane bounds
 	if config.Conference.HeartbeatConfig.Timeout < 30 && config.Conference.HeartbeatConfig.Timeout > 60*2 {
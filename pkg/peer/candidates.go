@@ -0,0 +1,108 @@
+package peer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Local ICE candidates that have been gathered, but not sent to the remote peer yet.
+type candidateBatch struct {
+	mutex      sync.Mutex
+	candidates []*webrtc.ICECandidate
+	timer      *time.Timer
+	// Held while a batch is being sent, so that the batches are sent in order
+	// without blocking the gathering of the new candidates.
+	sending sync.Mutex
+}
+
+// Buffers a gathered local candidate, the batch is sent once the batching window elapses.
+func (p *Peer[ID]) addLocalCandidate(candidate *webrtc.ICECandidate) {
+	p.pendingCandidates.mutex.Lock()
+
+	p.pendingCandidates.candidates = append(p.pendingCandidates.candidates, candidate)
+
+	if p.config.CandidateBatchWindow == 0 {
+		p.flushLocalCandidates(false)
+		return
+	}
+
+	if p.pendingCandidates.timer == nil {
+		p.pendingCandidates.timer = time.AfterFunc(p.config.CandidateBatchWindow, func() {
+			p.pendingCandidates.mutex.Lock()
+			p.flushLocalCandidates(false)
+		})
+	}
+
+	p.pendingCandidates.mutex.Unlock()
+}
+
+// Sends the buffered candidates (along with the end-of-candidates marker if the gathering is complete).
+func (p *Peer[ID]) completeLocalCandidates() {
+	p.pendingCandidates.mutex.Lock()
+	p.flushLocalCandidates(true)
+}
+
+// Must be called with the mutex of the pending candidates held, releases it before sending the candidates.
+func (p *Peer[ID]) flushLocalCandidates(gatheringComplete bool) {
+	batch := &p.pendingCandidates
+
+	if batch.timer != nil {
+		batch.timer.Stop()
+		batch.timer = nil
+	}
+
+	candidates := batch.candidates
+	batch.candidates = nil
+
+	batch.sending.Lock()
+	defer batch.sending.Unlock()
+	batch.mutex.Unlock()
+
+	switch {
+	case len(candidates) > 0:
+		p.sink.Send(NewICECandidates{Candidates: candidates, GatheringComplete: gatheringComplete})
+	case gatheringComplete:
+		p.sink.Send(ICEGatheringComplete{})
+	}
+}
+
+// Removes the local candidates that may not be sent to the peers from the SDP. With ICE-lite, the candidates
+// are sent within the SDP rather than trickled, so they must be filtered there. The candidates that can't be
+// parsed are removed as well.
+func filterSDPCandidates(sdp string, isAllowed func(*webrtc.ICECandidate) bool) string {
+	lines := strings.SplitAfter(sdp, "\n")
+
+	filtered := make([]string, 0, len(lines))
+	for _, line := range lines {
+		value := strings.TrimSpace(line)
+		if strings.HasPrefix(value, "a=candidate:") {
+			candidate, ok := parseSDPCandidate(strings.TrimPrefix(value, "a=candidate:"))
+			if !ok || !isAllowed(candidate) {
+				continue
+			}
+		}
+
+		filtered = append(filtered, line)
+	}
+
+	return strings.Join(filtered, "")
+}
+
+// Parses the fields of a candidate attribute that the candidate filters need (see RFC 8839, section 5.1):
+// `<foundation> <component> <transport> <priority> <address> <port> typ <type> ...`.
+func parseSDPCandidate(value string) (*webrtc.ICECandidate, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return nil, false
+	}
+
+	candidateType, err := webrtc.NewICECandidateType(fields[7])
+	if err != nil {
+		return nil, false
+	}
+
+	return &webrtc.ICECandidate{Address: fields[4], Typ: candidateType}, true
+}
@@ -0,0 +1,15 @@
+package peer
+
+import "time"
+
+// Configuration of the peer.
+type Config struct {
+	// How long the gathered local ICE candidates are buffered before they are sent to the remote
+	// peer in a single message. The buffered candidates are sent immediately once the gathering
+	// completes. The candidates are sent one by one if zero.
+	CandidateBatchWindow time.Duration
+	// How long to wait for a lost ICE connection to recover before restarting ICE.
+	ICERestartDelay time.Duration
+	// How long to wait for the connection to recover after the ICE restart before giving up on the peer.
+	ICERestartTimeout time.Duration
+}
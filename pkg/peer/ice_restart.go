@@ -0,0 +1,74 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"maunium.net/go/mautrix/event"
+)
+
+// State of the ICE restart (if the ICE connection got lost).
+type iceRestart struct {
+	mutex sync.Mutex
+	// Fires once the grace period elapses, i.e. when the ICE restart is due.
+	restart *time.Timer
+	// Fires if the connection does not recover in time after the ICE restart.
+	deadline *time.Timer
+}
+
+// Schedules an ICE restart unless the connection recovers within the grace period (e.g. a short network glitch).
+func (p *Peer[ID]) scheduleICERestart() {
+	p.iceRestart.mutex.Lock()
+	defer p.iceRestart.mutex.Unlock()
+
+	// The restart is either scheduled or in progress already.
+	if p.iceRestart.restart != nil || p.iceRestart.deadline != nil {
+		return
+	}
+
+	p.iceRestart.restart = time.AfterFunc(p.config.ICERestartDelay, p.restartICE)
+}
+
+// Sends an offer with new ICE credentials (via the usual renegotiation) and
+// gives up on the peer if it does not reconnect before the deadline.
+func (p *Peer[ID]) restartICE() {
+	p.iceRestart.mutex.Lock()
+	p.iceRestart.restart = nil
+	p.iceRestart.deadline = time.AfterFunc(p.config.ICERestartTimeout, func() {
+		p.logger.Warn("ICE restart timed out")
+		p.sink.Send(LeftTheCall{Reason: event.CallHangupICEFailed})
+	})
+	p.iceRestart.mutex.Unlock()
+
+	p.logger.Info("Restarting ICE")
+	offer, err := p.peerConnection.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		p.logger.WithError(err).Error("failed to create ICE restart offer")
+		return
+	}
+
+	localOffer, err := p.setLocalDescription(offer)
+	if err != nil {
+		return
+	}
+
+	p.sink.Send(RenegotiationRequired{Offer: localOffer, ICERestart: true})
+}
+
+// Cancels the ICE restart since the connection has recovered (or the peer is gone).
+func (p *Peer[ID]) cancelICERestart() {
+	p.iceRestart.mutex.Lock()
+	defer p.iceRestart.mutex.Unlock()
+
+	if p.iceRestart.restart != nil {
+		p.iceRestart.restart.Stop()
+		p.iceRestart.restart = nil
+	}
+
+	if p.iceRestart.deadline != nil {
+		p.logger.Info("ICE connection recovered")
+		p.iceRestart.deadline.Stop()
+		p.iceRestart.deadline = nil
+	}
+}
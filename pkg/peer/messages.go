@@ -39,14 +39,18 @@ type RTPPacketReceived struct {
 	Packet         *rtp.Packet
 }
 
-type NewICECandidate struct {
-	Candidate *webrtc.ICECandidate
+// Local ICE candidates that have been gathered. If the gathering is complete, no more candidates follow.
+type NewICECandidates struct {
+	Candidates        []*webrtc.ICECandidate
+	GatheringComplete bool
 }
 
 type ICEGatheringComplete struct{}
 
 type RenegotiationRequired struct {
 	Offer *webrtc.SessionDescription
+	// Set if the offer restarts ICE, i.e. the connection (and thus the data channel) may be gone already.
+	ICERestart bool
 }
 
 type DataChannelMessage struct {
@@ -3,6 +3,8 @@ package peer
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/matrix-org/waterfall/pkg/channel"
 	"github.com/matrix-org/waterfall/pkg/peer/state"
@@ -10,6 +12,7 @@ import (
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 )
 
 var (
@@ -18,6 +21,8 @@ var (
 	ErrCantCreateAnswer           = errors.New("can't create answer")
 	ErrCantSetLocalDescription    = errors.New("can't set local description")
 	ErrCantCreateLocalDescription = errors.New("can't create local description")
+	ErrCantCreateDataChannel      = errors.New("can't create data channel")
+	ErrGlare                      = errors.New("both sides sent an offer at the same time")
 	ErrDataChannelNotAvailable    = errors.New("data channel is not available")
 	ErrDataChannelNotReady        = errors.New("data channel is not ready")
 	ErrCantSubscribeToTrack       = errors.New("can't subscribe to track")
@@ -29,50 +34,130 @@ var (
 // and informs the outside world about the things happening inside the peer by posting
 // the messages to the channel.
 type Peer[ID comparable] struct {
+	config         Config
 	logger         *logrus.Entry
 	peerConnection *webrtc.PeerConnection
 	sink           *channel.SinkWithSender[ID, MessageContent]
 	state          *state.PeerState
+	// A polite peer gives up its own offer if both sides send an offer at the same time.
+	// We're polite to the peers that have called us and impolite to the peers that we've called.
+	polite bool
+	// Local ICE candidates that are waiting to be sent.
+	pendingCandidates candidateBatch
+	// ICE restart that is scheduled or in progress (if any).
+	iceRestart iceRestart
+	// Decides which local candidates are sent to the remote peer.
+	isCandidateAllowed func(*webrtc.ICECandidate) bool
+	// With ICE-lite the local candidates are sent within the SDP instead of being trickled.
+	iceLite bool
 }
 
+// How long to wait for the host candidates to be gathered with ICE-lite.
+const iceLiteGatheringTimeout = time.Second
+
 // Instantiates a new peer with a given SDP offer and returns a peer and the SDP answer if everything is ok.
 func NewPeer[ID comparable](
 	connectionFactory *webrtc_ext.PeerConnectionFactory,
+	config Config,
 	sdpOffer string,
 	sink *channel.SinkWithSender[ID, MessageContent],
 	logger *logrus.Entry,
 ) (*Peer[ID], *webrtc.SessionDescription, error) {
+	peer, err := newPeer(connectionFactory, config, sink, logger, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	peer.peerConnection.OnNegotiationNeeded(peer.onNegotiationNeeded)
+
+	if sdpAnswer, err := peer.ProcessSDPOffer(sdpOffer); err != nil {
+		return nil, nil, err
+	} else {
+		return peer, sdpAnswer, nil
+	}
+}
+
+// Instantiates a new peer that calls the remote side (i.e. we're the ones who send an initial offer).
+// Returns a peer and the SDP offer that must be sent to the remote side if everything is ok.
+func NewOutgoingPeer[ID comparable](
+	connectionFactory *webrtc_ext.PeerConnectionFactory,
+	config Config,
+	sink *channel.SinkWithSender[ID, MessageContent],
+	logger *logrus.Entry,
+) (*Peer[ID], *webrtc.SessionDescription, error) {
+	peer, err := newPeer(connectionFactory, config, sink, logger, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataChannel, err := peer.peerConnection.CreateDataChannel("focus", nil)
+	if err != nil {
+		logger.WithError(err).Error("failed to create data channel")
+		peer.Terminate()
+		return nil, nil, ErrCantCreateDataChannel
+	}
+
+	peer.onDataChannelReady(dataChannel)
+
+	offer, err := peer.peerConnection.CreateOffer(nil)
+	if err != nil {
+		logger.WithError(err).Error("failed to create offer")
+		peer.Terminate()
+		return nil, nil, ErrCantCreateLocalDescription
+	}
+
+	localOffer, err := peer.setLocalDescription(offer)
+	if err != nil {
+		peer.Terminate()
+		return nil, nil, err
+	}
+
+	// The initial offer is sent along with the invite, so the renegotiations are only handled from now on.
+	peer.peerConnection.OnNegotiationNeeded(peer.onNegotiationNeeded)
+
+	return peer, localOffer, nil
+}
+
+func newPeer[ID comparable](
+	connectionFactory *webrtc_ext.PeerConnectionFactory,
+	config Config,
+	sink *channel.SinkWithSender[ID, MessageContent],
+	logger *logrus.Entry,
+	polite bool,
+) (*Peer[ID], error) {
 	peerConnection, err := connectionFactory.CreatePeerConnection()
 	if err != nil {
 		logger.WithError(err).Error("failed to create peer connection")
-		return nil, nil, ErrCantCreatePeerConnection
+		return nil, ErrCantCreatePeerConnection
 	}
 
 	peer := &Peer[ID]{
+		config:         config,
 		logger:         logger,
 		peerConnection: peerConnection,
 		sink:           sink,
 		state:          state.NewPeerState(),
+		polite:         polite,
+
+		isCandidateAllowed: connectionFactory.IsCandidateAllowed,
+		iceLite:            connectionFactory.ICELite(),
 	}
 
 	peerConnection.OnTrack(peer.onRtpTrackReceived)
 	peerConnection.OnDataChannel(peer.onDataChannelReady)
 	peerConnection.OnICECandidate(peer.onICECandidateGathered)
-	peerConnection.OnNegotiationNeeded(peer.onNegotiationNeeded)
 	peerConnection.OnICEConnectionStateChange(peer.onICEConnectionStateChanged)
 	peerConnection.OnICEGatheringStateChange(peer.onICEGatheringStateChanged)
 	peerConnection.OnConnectionStateChange(peer.onConnectionStateChanged)
 	peerConnection.OnSignalingStateChange(peer.onSignalingStateChanged)
 
-	if sdpAnswer, err := peer.ProcessSDPOffer(sdpOffer); err != nil {
-		return nil, nil, err
-	} else {
-		return peer, sdpAnswer, nil
-	}
+	return peer, nil
 }
 
 // Closes peer connection. From this moment on, no new messages will be sent from the peer.
 func (p *Peer[ID]) Terminate() {
+	p.cancelICERestart()
+
 	if err := p.peerConnection.Close(); err != nil {
 		p.logger.WithError(err).Error("failed to close peer connection")
 	}
@@ -87,6 +172,11 @@ func (p *Peer[ID]) Terminate() {
 func (p *Peer[ID]) RequestKeyFrame(info webrtc_ext.TrackInfo, simulcast webrtc_ext.SimulcastLayer) error {
 	// Find the right track.
 	track := p.state.GetRemoteTrack(info.TrackID, simulcast)
+	// An SVC track has a single encoding that carries all of its (spatial) layers.
+	if track == nil {
+		track = p.state.GetRemoteTrack(info.TrackID, webrtc_ext.SimulcastLayerNone)
+	}
+
 	if track == nil {
 		return ErrTrackNotFound
 	}
@@ -106,6 +196,56 @@ func (p *Peer[ID]) RemoveTrack(sender *webrtc.RTPSender) error {
 	return p.peerConnection.RemoveTrack(sender)
 }
 
+// Returns the MIME types (e.g. "video/VP8") of the codecs of a given kind that the remote peer has listed in
+// its session description, i.e. the ones that it can decode. Returns nil if they are not known, i.e. if there is
+// no remote description yet or if it has no media section of that kind (e.g. a subscriber without a camera that has
+// not negotiated any video yet), so that the subscriber is not mistaken for one that can't decode anything.
+func (p *Peer[ID]) RemoteCodecs(kind webrtc.RTPCodecType) []string {
+	description := p.peerConnection.RemoteDescription()
+	if description == nil {
+		return nil
+	}
+
+	parsed, err := description.Unmarshal()
+	if err != nil {
+		p.logger.WithError(err).Warn("failed to parse remote description")
+		return nil
+	}
+
+	var codecs []string
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != kind.String() {
+			continue
+		}
+
+		for _, attribute := range media.Attributes {
+			if attribute.Key != "rtpmap" {
+				continue
+			}
+
+			// The format is `<payload type> <encoding name>/<clock rate>[/<channels>]`.
+			_, encoding, found := strings.Cut(attribute.Value, " ")
+			if !found {
+				continue
+			}
+
+			name, _, _ := strings.Cut(encoding, "/")
+			mimeType := kind.String() + "/" + name
+			if !slices.ContainsFunc(codecs, func(codec string) bool { return strings.EqualFold(codec, mimeType) }) {
+				codecs = append(codecs, mimeType)
+			}
+		}
+	}
+
+	return codecs
+}
+
+// Checks if the data channel is open, i.e. if the messages could be sent over it.
+func (p *Peer[ID]) DataChannelReady() bool {
+	dataChannel := p.state.GetDataChannel()
+	return dataChannel != nil && dataChannel.ReadyState() == webrtc.DataChannelStateOpen
+}
+
 // Tries to send the given message to the remote counterpart of our peer.
 func (p *Peer[ID]) SendOverDataChannel(json string) error {
 	dataChannel := p.state.GetDataChannel()
@@ -149,6 +289,21 @@ func (p *Peer[ID]) ProcessSDPAnswer(sdpAnswer string) error {
 
 // Applies the sdp offer received from the remote peer and generates an SDP answer.
 func (p *Peer[ID]) ProcessSDPOffer(sdpOffer string) (*webrtc.SessionDescription, error) {
+	// If both sides sent an offer at the same time, the polite side gives up its own offer.
+	// The impolite side ignores the remote offer and waits for the answer to its own offer.
+	if p.peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if !p.polite {
+			p.logger.Warn("ignoring remote offer since we have sent our own offer")
+			return nil, ErrGlare
+		}
+
+		p.logger.Info("rolling back local offer in favor of the remote offer")
+		if err := p.peerConnection.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			p.logger.WithError(err).Error("failed to rollback local description")
+			return nil, ErrCantSetLocalDescription
+		}
+	}
+
 	err := p.peerConnection.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  sdpOffer,
@@ -164,10 +319,41 @@ func (p *Peer[ID]) ProcessSDPOffer(sdpOffer string) (*webrtc.SessionDescription,
 		return nil, ErrCantCreateAnswer
 	}
 
-	if err := p.peerConnection.SetLocalDescription(answer); err != nil {
+	return p.setLocalDescription(answer)
+}
+
+// Applies the local description and returns the one that must be sent to the remote peer.
+// With ICE-lite, it waits for the (host-only, hence quick) gathering to complete, so that
+// the returned description contains all local candidates (that may be sent to the peers).
+// Note that it blocks the caller (i.e. the conference loop) for up to `iceLiteGatheringTimeout` then.
+func (p *Peer[ID]) setLocalDescription(description webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if !p.iceLite {
+		if err := p.peerConnection.SetLocalDescription(description); err != nil {
+			p.logger.WithError(err).Error("failed to set local description")
+			return nil, ErrCantSetLocalDescription
+		}
+
+		return &description, nil
+	}
+
+	gatheringComplete := webrtc.GatheringCompletePromise(p.peerConnection)
+	if err := p.peerConnection.SetLocalDescription(description); err != nil {
 		p.logger.WithError(err).Error("failed to set local description")
 		return nil, ErrCantSetLocalDescription
 	}
 
-	return &answer, nil
+	select {
+	case <-gatheringComplete:
+	case <-time.After(iceLiteGatheringTimeout):
+		p.logger.Warn("timed out waiting for ICE gathering, sending the candidates gathered so far")
+	}
+
+	local := p.peerConnection.LocalDescription()
+	if local == nil {
+		return nil, ErrCantSetLocalDescription
+	}
+
+	filtered := *local
+	filtered.SDP = filterSDPCandidates(local.SDP, p.isCandidateAllowed)
+	return &filtered, nil
 }
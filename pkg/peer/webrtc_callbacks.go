@@ -11,6 +11,7 @@ import (
 func (p *Peer[ID]) onRtpTrackReceived(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 	// Construct a new track info assuming that there is no simulcast.
 	trackInfo := webrtc_ext.TrackInfoFromTrack(remoteTrack)
+	trackInfo.DependencyDescriptorID = webrtc_ext.DependencyDescriptorID(receiver)
 
 	switch trackInfo.Kind {
 	case webrtc.RTPCodecTypeVideo:
@@ -24,12 +25,25 @@ func (p *Peer[ID]) onRtpTrackReceived(remoteTrack *webrtc.TrackRemote, receiver
 func (p *Peer[ID]) onICECandidateGathered(candidate *webrtc.ICECandidate) {
 	if candidate == nil {
 		p.logger.Info("ICE candidate gathering finished")
-		p.sink.Send(ICEGatheringComplete{})
+		if !p.iceLite {
+			p.completeLocalCandidates()
+		}
+		return
+	}
+
+	// With ICE-lite the candidates are sent within the SDP, there is no need to trickle them.
+	if p.iceLite {
+		p.logger.WithField("candidate", candidate).Debug("ICE candidate gathered (ICE-lite)")
+		return
+	}
+
+	if !p.isCandidateAllowed(candidate) {
+		p.logger.WithField("candidate", candidate).Debug("ICE candidate gathered, but not sent (type not allowed)")
 		return
 	}
 
 	p.logger.WithField("candidate", candidate).Debug("ICE candidate gathered")
-	p.sink.Send(NewICECandidate{Candidate: candidate})
+	p.addLocalCandidate(candidate)
 }
 
 // A callback that is called when a change has been made that requires renegotiation.
@@ -41,12 +55,12 @@ func (p *Peer[ID]) onNegotiationNeeded() {
 		return
 	}
 
-	if err := p.peerConnection.SetLocalDescription(offer); err != nil {
-		p.logger.WithError(err).Error("failed to set local description")
+	localOffer, err := p.setLocalDescription(offer)
+	if err != nil {
 		return
 	}
 
-	p.sink.Send(RenegotiationRequired{Offer: &offer})
+	p.sink.Send(RenegotiationRequired{Offer: localOffer})
 }
 
 // A callback that is called once we receive an ICE connection state change for this peer connection.
@@ -55,12 +69,10 @@ func (p *Peer[ID]) onICEConnectionStateChanged(state webrtc.ICEConnectionState)
 
 	switch state {
 	case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateDisconnected:
-		// TODO: Ask Simon if we should do it here as in the previous implementation.
-		//       Ideally we want to perform an ICE restart here.
-		// p.notify <- PeerLeftTheCall{sender: p.data}
+		// The network of the peer may have changed (e.g. switching from Wi-Fi to LTE).
+		p.scheduleICERestart()
 	case webrtc.ICEConnectionStateCompleted, webrtc.ICEConnectionStateConnected:
-		// FIXME: Start keep-alive timer over the data channel to check the connecitons that hanged.
-		// p.notify <- PeerJoinedTheCall{sender: p.data}
+		p.cancelICERestart()
 	}
 }
 
@@ -76,7 +88,10 @@ func (p *Peer[ID]) onConnectionStateChanged(state webrtc.PeerConnectionState) {
 	p.logger.Infof("Connection state changed: %v", state)
 
 	switch state {
-	case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+	case webrtc.PeerConnectionStateFailed:
+		// A failed connection may recover after the ICE restart, we only give up once the restart times out.
+		p.scheduleICERestart()
+	case webrtc.PeerConnectionStateClosed:
 		p.sink.Send(LeftTheCall{event.CallHangupUserHangup})
 	case webrtc.PeerConnectionStateConnected:
 		p.sink.Send(JoinedTheCall{})
@@ -0,0 +1,173 @@
+package routing
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
+)
+
+// How many of the recently seen messages we remember.
+const dedupCapacity = 4096
+
+// Remembers the hashes of the recently seen messages, so that the repeated ones (e.g. the ones that the
+// homeserver delivers again after a restart) can be dropped. If the path is set, the hashes are appended
+// to a file, so that they survive the restarts (the file is compacted once it grows too big).
+type deduplicator struct {
+	path    string
+	file    *os.File
+	written int
+	seen    map[string]struct{}
+	order   []string
+}
+
+func newDeduplicator(path string) (*deduplicator, error) {
+	d := &deduplicator{path: path, seen: make(map[string]struct{})}
+	if path == "" {
+		return d, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	if file != nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			d.remember(scanner.Text())
+		}
+		file.Close()
+
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Returns `true` if the message has been seen before, otherwise remembers it.
+func (d *deduplicator) isDuplicate(msg signaling.IncomingMessage) bool {
+	hash, err := hashMessage(msg)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to hash message, can't check for duplicates")
+		return false
+	}
+
+	if _, found := d.seen[hash]; found {
+		return true
+	}
+
+	d.remember(hash)
+	d.persist(hash)
+
+	return false
+}
+
+func (d *deduplicator) remember(hash string) {
+	if _, found := d.seen[hash]; found {
+		return
+	}
+
+	if len(d.order) == dedupCapacity {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+
+	d.seen[hash] = struct{}{}
+	d.order = append(d.order, hash)
+}
+
+func (d *deduplicator) persist(hash string) {
+	if d.file == nil {
+		return
+	}
+
+	if _, err := fmt.Fprintln(d.file, hash); err != nil {
+		logrus.WithError(err).Error("Failed to persist the hash of the message")
+		return
+	}
+
+	d.written++
+	if d.written >= 2*dedupCapacity {
+		if err := d.compact(); err != nil {
+			logrus.WithError(err).Error("Failed to compact the seen messages")
+		}
+	}
+}
+
+// Rewrites the file with the hashes that we still remember and reopens it for appending.
+func (d *deduplicator) compact() error {
+	if d.file != nil {
+		d.file.Close()
+		d.file = nil
+	}
+
+	temporary := d.path + ".tmp"
+	file, err := os.Create(temporary)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", temporary, err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, hash := range d.order {
+		fmt.Fprintln(writer, hash)
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", temporary, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", temporary, err)
+	}
+
+	if err := os.Rename(temporary, d.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", d.path, err)
+	}
+
+	d.file, err = os.OpenFile(d.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", d.path, err)
+	}
+
+	d.written = len(d.order)
+
+	return nil
+}
+
+// Messages are identified by their transaction IDs if the transport tells them. Otherwise (e.g. to-device
+// messages carry no IDs), they are identified by their contents as they were sent, since the parsed contents
+// do not necessarily serialize back to the same JSON (or at all).
+func hashMessage(msg signaling.IncomingMessage) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00", msg.Type.Type, msg.UserID, msg.DeviceID)
+
+	switch {
+	case msg.TransactionID != "":
+		fmt.Fprintf(hash, "txn\x00%s", msg.TransactionID)
+	case len(msg.RawContent) > 0:
+		hash.Write(msg.RawContent)
+	default:
+		content, err := json.Marshal(msg.Content)
+		if err != nil {
+			return "", err
+		}
+
+		hash.Write(content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,31 @@
+package routing
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+)
+
+const (
+	PendingTimeout    = pendingTimeout
+	MaxPendingPerCall = maxPendingPerCall
+)
+
+// Exposes the queue of the messages that arrived before their invites to the tests.
+type PendingQueue struct {
+	queue *pendingQueue
+}
+
+func NewPendingQueue(now func() time.Time) PendingQueue {
+	queue := newPendingQueue()
+	queue.now = now
+	return PendingQueue{queue: queue}
+}
+
+func (q PendingQueue) Add(msg signaling.IncomingMessage) bool {
+	return q.queue.add(msg)
+}
+
+func (q PendingQueue) Take(msg signaling.IncomingMessage) []signaling.IncomingMessage {
+	return q.queue.take(msg)
+}
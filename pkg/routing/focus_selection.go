@@ -0,0 +1,58 @@
+package routing
+
+import (
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// Checks if we're the focus that the sender of an invite must use for the call (MSC3898). The decision is based
+// on the `m.call.member` state events of the room that the call belongs to. If we're not the right focus, returns
+// false and the focus that the device must use instead (nil if not known). If the room state does not tell anything
+// about the foci (or we can't get it), the configured fallback decides. It fetches the room state, so it must not
+// be called from the main loop of the router.
+func (r *Router) checkFocus(msg signaling.IncomingMessage) (*signaling.Focus, bool) {
+	logger := logrus.WithFields(logrus.Fields{
+		"room_id":   msg.RoomID,
+		"conf_id":   msg.ConferenceID,
+		"user_id":   msg.UserID,
+		"device_id": msg.DeviceID,
+	})
+
+	// The transports without rooms (e.g. WebSocket) have no focus selection.
+	selector, ok := r.transport.(signaling.FocusSelector)
+	if !ok {
+		return nil, true
+	}
+
+	if msg.RoomID == "" {
+		return r.focusFallback(logger, "the invite has no room ID")
+	}
+
+	members, err := selector.GetCallMembers(msg.RoomID)
+	if err != nil {
+		return r.focusFallback(logger.WithError(err), "the call members are not available")
+	}
+
+	foci := members.ChosenFoci(msg.ConferenceID, msg.UserID, msg.DeviceID)
+	if len(foci) == 0 {
+		return r.focusFallback(logger, "the caller has not chosen any focus")
+	}
+
+	if slices.Contains(foci, r.transport.Focus()) {
+		return nil, true
+	}
+
+	return &foci[0], false
+}
+
+// Decides on an invite if we don't know which focus the caller must use.
+func (r *Router) focusFallback(logger *logrus.Entry, reason string) (*signaling.Focus, bool) {
+	logger.Warnf("can't tell which focus the caller must use: %s", reason)
+	if r.config.FocusSelection.RejectsUnknown() {
+		return nil, false
+	}
+
+	logger.Info("accepting invite without the focus selection")
+	return nil, true
+}
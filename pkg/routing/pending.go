@@ -0,0 +1,88 @@
+package routing
+
+import (
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/conference/participant"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+)
+
+// How long the messages that arrived before the invite are kept.
+const pendingTimeout = 10 * time.Second
+
+// The maximal number of the messages kept for a single call (e.g. a burst of candidates).
+const maxPendingPerCall = 64
+
+// Identifies a call within a conference.
+type pendingKey struct {
+	conferenceID string
+	participant  participant.ID
+}
+
+// Messages received for a call that we have not gotten an invite for (yet).
+type pendingCall struct {
+	messages []signaling.IncomingMessage
+	expires  time.Time
+}
+
+// To-device messages are not guaranteed to be delivered in order, so the candidates (or a hangup) may
+// arrive before the invite that starts the call. Such messages are kept here until the invite comes.
+type pendingQueue struct {
+	calls map[pendingKey]*pendingCall
+	// Returns the current time (replaced in the tests).
+	now func() time.Time
+}
+
+func newPendingQueue() *pendingQueue {
+	return &pendingQueue{calls: make(map[pendingKey]*pendingCall), now: time.Now}
+}
+
+func pendingKeyOf(msg signaling.IncomingMessage) pendingKey {
+	return pendingKey{
+		conferenceID: msg.ConferenceID,
+		participant:  participant.ID{UserID: msg.UserID, DeviceID: msg.DeviceID, CallID: msg.CallID},
+	}
+}
+
+// Keeps a message until the invite of its call arrives. Returns false if there are too many messages for the call.
+func (q *pendingQueue) add(msg signaling.IncomingMessage) bool {
+	now := q.now()
+	q.expire(now)
+
+	key := pendingKeyOf(msg)
+	call := q.calls[key]
+	if call == nil {
+		call = &pendingCall{expires: now.Add(pendingTimeout)}
+		q.calls[key] = call
+	}
+
+	if len(call.messages) == maxPendingPerCall {
+		return false
+	}
+
+	call.messages = append(call.messages, msg)
+	return true
+}
+
+// Removes and returns the messages of the call that a given message (e.g. an invite) belongs to.
+func (q *pendingQueue) take(msg signaling.IncomingMessage) []signaling.IncomingMessage {
+	q.expire(q.now())
+
+	key := pendingKeyOf(msg)
+	call := q.calls[key]
+	if call == nil {
+		return nil
+	}
+
+	delete(q.calls, key)
+	return call.messages
+}
+
+// Drops the messages that have been waiting for too long.
+func (q *pendingQueue) expire(now time.Time) {
+	for key, call := range q.calls {
+		if now.After(call.expires) {
+			delete(q.calls, key)
+		}
+	}
+}
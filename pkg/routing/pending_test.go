@@ -0,0 +1,128 @@
+package routing_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/routing"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestPendingQueue(t *testing.T) {
+	message := func(callID string, sequence int) signaling.IncomingMessage {
+		return signaling.IncomingMessage{
+			Type:          event.CallCandidates,
+			ConferenceID:  "conference",
+			CallID:        callID,
+			UserID:        "@alice:example.org",
+			DeviceID:      "ALICE",
+			TransactionID: strconv.Itoa(sequence),
+			Content:       &event.CallCandidatesEventContent{},
+		}
+	}
+
+	invite := func(callID string) signaling.IncomingMessage {
+		msg := message(callID, 0)
+		msg.Type, msg.Content = event.CallInvite, &event.CallInviteEventContent{}
+		return msg
+	}
+
+	cases := []struct {
+		name string
+		// Messages added to the queue (by call) and how long after the start they arrive.
+		added []string
+		delay time.Duration
+		// When the invite of the call arrives.
+		inviteAt time.Duration
+		// The number of the messages that are replayed after the invite and how many were refused.
+		replayed int
+		refused  int
+	}{
+		{
+			name:     "replayed after the invite",
+			added:    []string{"call", "call", "call"},
+			inviteAt: time.Second,
+			replayed: 3,
+		},
+		{
+			name:     "other calls are not replayed",
+			added:    []string{"call", "other", "call"},
+			inviteAt: time.Second,
+			replayed: 2,
+		},
+		{
+			name:     "expired before the invite",
+			added:    []string{"call", "call"},
+			inviteAt: routing.PendingTimeout + time.Second,
+			replayed: 0,
+		},
+		{
+			name:     "the timeout starts with the first message",
+			added:    []string{"call", "call"},
+			delay:    routing.PendingTimeout / 2,
+			inviteAt: routing.PendingTimeout + time.Second,
+			replayed: 0,
+		},
+		{
+			name:     "too many messages for the call",
+			added:    repeat("call", routing.MaxPendingPerCall+2),
+			inviteAt: time.Second,
+			replayed: routing.MaxPendingPerCall,
+			refused:  2,
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			start := time.Now()
+			now := start
+			queue := routing.NewPendingQueue(func() time.Time { return now })
+
+			refused := 0
+			added := []signaling.IncomingMessage{}
+			for i, callID := range testCase.added {
+				now = start.Add(time.Duration(i) * testCase.delay)
+
+				msg := message(callID, i)
+				if !queue.Add(msg) {
+					refused++
+				} else if callID == "call" {
+					added = append(added, msg)
+				}
+			}
+
+			if refused != testCase.refused {
+				t.Errorf("expected %d refused messages, got %d", testCase.refused, refused)
+			}
+
+			now = start.Add(testCase.inviteAt)
+			replayed := queue.Take(invite("call"))
+			if len(replayed) != testCase.replayed {
+				t.Fatalf("expected %d replayed messages, got %d", testCase.replayed, len(replayed))
+			}
+
+			// The messages are replayed in the order they arrived.
+			for i, msg := range replayed {
+				if msg.CallID != "call" || msg.TransactionID != added[i].TransactionID {
+					t.Errorf("unexpected message %d: %s (%s)", i, msg.CallID, msg.TransactionID)
+				}
+			}
+
+			// The messages are only replayed once.
+			if again := queue.Take(invite("call")); len(again) != 0 {
+				t.Errorf("expected no messages after the replay, got %d", len(again))
+			}
+		})
+	}
+}
+
+func repeat(value string, count int) []string {
+	values := make([]string, count)
+	for i := range values {
+		values[i] = value
+	}
+
+	return values
+}
@@ -17,140 +17,618 @@ limitations under the License.
 package routing
 
 import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/auth"
 	conf "github.com/matrix-org/waterfall/pkg/conference"
 	"github.com/matrix-org/waterfall/pkg/conference/participant"
 	"github.com/matrix-org/waterfall/pkg/signaling"
 	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix/event"
-	"maunium.net/go/mautrix/id"
 )
 
 // The top-level state of the Router.
 type Router struct {
-	// Matrix matrix.
-	matrix *signaling.MatrixClient
+	// Signaling transport (Matrix by default).
+	transport signaling.Transport
 	// Sinks of all conferences (all calls that are currently forwarded by this SFU).
 	conferenceSinks map[string]*conferenceStage
 	// Configuration for the calls.
 	config conf.Config
-	// Channel for reading incoming Matrix SDK To-Device events and distributing them to the conferences.
-	matrixEvents <-chan *event.Event
+	// Channel for reading incoming signaling messages and distributing them to the conferences.
+	messages <-chan signaling.IncomingMessage
 	// Channel for handling conference ended events.
 	// Peer connection factory that can be used to create pre-configured peer connections.
 	connectionFactory *webrtc_ext.PeerConnectionFactory
+	// Channel for the migration requests (e.g. from the admin API).
+	migrations chan migrationRequest
+	// Conferences that have been moved to other foci, new participants are sent there.
+	migrated map[string]migratedConference
+	// The focus that all conferences are moved to if the SFU is being drained.
+	drainingTo *signaling.Focus
+	// Conferences that other foci are moving to us. Their participants may call us even though the room
+	// state still names the other focus (they only update it once they've moved).
+	handoffs map[string]handoff
+	// Recently seen messages (to drop the ones that are delivered again).
+	dedup *deduplicator
+	// Decides who may start and join the conferences.
+	authorizer *auth.Authorizer
+	// Messages that arrived before the invite of their call.
+	pending *pendingQueue
+	// Channel for the decisions on the invites that are checked in the background (e.g. the focus selection).
+	admissions chan admission
+	// Calls whose invites are being checked, their other messages are kept until the check is done.
+	admitting map[pendingKey]struct{}
+	// Calls whose invites have been passed to the conferences. The messages of the other calls are kept
+	// until their invites arrive. A call is forgotten when it's hung up or when its conference ends.
+	calls map[pendingKey]struct{}
+	// Channel for the shutdown request, the conferences that are being shut down are sent back.
+	shutdowns chan chan<- []<-chan struct{}
+	// Whether the SFU is shutting down (no new calls are accepted then).
+	shuttingDown bool
+	// Hangups that are being sent to the rejected callers. Replaced upon the shutdown,
+	// so that the shutdown only waits for the hangups that have been started before it.
+	rejections *sync.WaitGroup
 }
 
-// Creates a new instance of the SFU with the given configuration.
+var ErrUnknownConference = errors.New("unknown conference")
+
+// How long the new participants of a conference that has been moved to another focus are sent there.
+// The conference is forgotten afterwards, but not before it has ended on this SFU.
+const migratedConferenceTimeout = 10 * time.Minute
+
+// Conference that has been moved to another focus.
+type migratedConference struct {
+	focus   signaling.Focus
+	expires time.Time
+}
+
+// How long the participants of a conference that another focus has handed over to us may call us.
+const handoffTimeout = migratedConferenceTimeout
+
+// Conference that another focus is moving to us.
+type handoff struct {
+	participants []signaling.HandoffParticipant
+	expires      time.Time
+}
+
+// Request to move a conference (or all conferences if the conference ID is empty) to another focus.
+type migrationRequest struct {
+	conferenceID string
+	focus        signaling.Focus
+	result       chan<- error
+}
+
+// Decision on an invite that has been checked in the background.
+type admission struct {
+	invite signaling.IncomingMessage
+	// The hangup that is sent to the caller if the invite is rejected (nil if it's accepted).
+	rejection *signaling.Hangup
+	// Why the caller has not been authorized (nil if they have been).
+	err error
+}
+
+// Creates a new instance of the SFU with the given configuration. If the path is set,
+// the recently seen messages are remembered there, so that they are dropped after a restart.
 func StartRouter(
-	matrix *signaling.MatrixClient,
+	transport signaling.Transport,
 	connectionFactory *webrtc_ext.PeerConnectionFactory,
-	matrixEvents <-chan *event.Event,
+	messages <-chan signaling.IncomingMessage,
 	config conf.Config,
-) {
+	authorization auth.Config,
+	seenMessagesPath string,
+) (*Router, error) {
+	dedup, err := newDeduplicator(seenMessagesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The room membership can only be checked if the transport knows about the rooms.
+	rooms, _ := transport.(signaling.MembershipChecker)
+
 	router := &Router{
-		matrix:            matrix,
+		transport:         transport,
 		conferenceSinks:   make(map[string]*conferenceStage),
 		config:            config,
-		matrixEvents:      matrixEvents,
+		messages:          messages,
 		connectionFactory: connectionFactory,
+		migrations:        make(chan migrationRequest),
+		migrated:          make(map[string]migratedConference),
+		handoffs:          make(map[string]handoff),
+		dedup:             dedup,
+		authorizer:        auth.NewAuthorizer(authorization, rooms),
+		pending:           newPendingQueue(),
+		admissions:        make(chan admission),
+		admitting:         make(map[pendingKey]struct{}),
+		calls:             make(map[pendingKey]struct{}),
+		shutdowns:         make(chan chan<- []<-chan struct{}),
+		rejections:        &sync.WaitGroup{},
 	}
 
 	// Start the main loop of the Router.
 	go func() {
-		for msg := range router.matrixEvents {
-			// To-Device message received from the remote peer.
-			router.handleMatrixEvent(msg)
+		for {
+			select {
+			case msg, ok := <-router.messages:
+				if !ok {
+					return
+				}
+				// Signaling message received from the remote peer.
+				if router.dedup.isDuplicate(msg) {
+					logrus.WithField("type", msg.Type.Type).Warn("ignoring a message that we've already seen")
+					continue
+				}
+				router.handleMessage(msg)
+			case result := <-router.admissions:
+				router.handleAdmission(result)
+			case request := <-router.migrations:
+				request.result <- router.handleMigration(request)
+			case result := <-router.shutdowns:
+				result <- router.handleShutdown()
+			}
 		}
 	}()
+
+	return router, nil
+}
+
+// Moves a running conference to another focus.
+func (r *Router) Migrate(conferenceID string, focus signaling.Focus) error {
+	return r.requestMigration(migrationRequest{conferenceID: conferenceID, focus: focus})
+}
+
+// Moves all running conferences to another focus and sends all new participants there (draining).
+func (r *Router) MigrateAll(focus signaling.Focus) error {
+	return r.requestMigration(migrationRequest{focus: focus})
+}
+
+func (r *Router) requestMigration(request migrationRequest) error {
+	result := make(chan error, 1)
+	request.result = result
+	r.migrations <- request
+	return <-result
+}
+
+func (r *Router) handleMigration(request migrationRequest) error {
+	if request.conferenceID == "" {
+		logrus.Infof("draining, moving all conferences to %s (%s)", request.focus.UserID, request.focus.DeviceID)
+		r.drainingTo = &request.focus
+
+		for conferenceID := range r.conferenceSinks {
+			r.migrateConference(conferenceID, request.focus)
+		}
+
+		return nil
+	}
+
+	if !r.migrateConference(request.conferenceID, request.focus) {
+		return ErrUnknownConference
+	}
+
+	return nil
+}
+
+// Asks a conference to move to another focus. Returns false if the conference is not running.
+func (r *Router) migrateConference(conferenceID string, focus signaling.Focus) bool {
+	conference := r.conferenceSinks[conferenceID]
+	if conference == nil {
+		return false
+	}
+
+	select {
+	case <-conference.done:
+		r.removeConference(conferenceID)
+		return false
+	case conference.control <- conf.MigrateTo{Focus: focus}:
+		r.migrated[conferenceID] = migratedConference{focus: focus, expires: time.Now().Add(migratedConferenceTimeout)}
+		return true
+	}
+}
+
+// Hangs up on all participants of all conferences and waits (up to the timeout) until the hangups
+// (including the ones for the rejected callers) are sent. No new calls are accepted afterwards.
+func (r *Router) Shutdown(timeout time.Duration) {
+	result := make(chan []<-chan struct{}, 1)
+	deadline := time.After(timeout)
+
+	select {
+	case r.shutdowns <- result:
+	case <-deadline:
+		logrus.Warn("timed out waiting for the router to shut down")
+		return
+	}
+
+	var pending []<-chan struct{}
+	select {
+	case pending = <-result:
+	case <-deadline:
+		logrus.Warn("timed out waiting for the router to shut down")
+		return
+	}
+
+	for _, flushed := range pending {
+		select {
+		case <-flushed:
+		case <-deadline:
+			logrus.Warn("timed out waiting for the conferences to hang up")
+			return
+		}
+	}
+}
+
+func (r *Router) handleShutdown() []<-chan struct{} {
+	logrus.Infof("shutting down %d conferences", len(r.conferenceSinks))
+	r.shuttingDown = true
+
+	conferences := []<-chan struct{}{}
+	for conferenceID, conference := range r.conferenceSinks {
+		flushed := make(chan struct{})
+
+		select {
+		case <-conference.done:
+			r.removeConference(conferenceID)
+		case conference.control <- conf.Shutdown{Flushed: flushed}:
+			conferences = append(conferences, flushed)
+		}
+	}
+
+	// The hangups that are being sent to the rejected callers must be sent as well.
+	rejected := make(chan struct{})
+	rejections := r.rejections
+	r.rejections = &sync.WaitGroup{}
+	go func() {
+		rejections.Wait()
+		close(rejected)
+	}()
+
+	return append(conferences, rejected)
+}
+
+// Returns the focus that the participants of a given conference must use instead of us (if any).
+func (r *Router) migratedTo(conferenceID string) *signaling.Focus {
+	r.expireMigrated(time.Now())
+
+	if migrated, ok := r.migrated[conferenceID]; ok {
+		return &migrated.focus
+	}
+
+	return r.drainingTo
+}
+
+// Forgets the conferences that have been moved to other foci a while ago and that have ended here.
+func (r *Router) expireMigrated(now time.Time) {
+	for conferenceID, migrated := range r.migrated {
+		if now.After(migrated.expires) && !r.isRunning(conferenceID) {
+			delete(r.migrated, conferenceID)
+		}
+	}
+}
+
+// Remembers the participants that another focus hands over to us, so that their invites are accepted.
+func (r *Router) handleHandoff(msg signaling.IncomingMessage, content *signaling.CallHandoffEventContent) {
+	logger := logrus.WithFields(logrus.Fields{
+		"conf_id":   msg.ConferenceID,
+		"user_id":   msg.UserID,
+		"device_id": msg.DeviceID,
+	})
+
+	// Anyone could hand the participants over to us otherwise, so only the foci that we trust may do it.
+	if !r.config.Cascade.IsFocus(msg.UserID, msg.DeviceID) || !r.authenticatesSenders() {
+		logger.Warn("ignoring handoff from a sender that is not a trusted focus")
+		return
+	}
+
+	logger.Infof("the focus hands %d participants over to us", len(content.Participants))
+	r.expireHandoffs(time.Now())
+	r.handoffs[msg.ConferenceID] = handoff{
+		participants: content.Participants,
+		expires:      time.Now().Add(handoffTimeout),
+	}
+
+	// The conference may come back to us after we've moved it away.
+	delete(r.migrated, msg.ConferenceID)
+}
+
+// Checks if another focus has handed the sender of a message over to us.
+func (r *Router) isHandedOver(msg signaling.IncomingMessage) bool {
+	r.expireHandoffs(time.Now())
+
+	for _, handedOver := range r.handoffs[msg.ConferenceID].participants {
+		if handedOver.UserID == msg.UserID && handedOver.DeviceID == msg.DeviceID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Passes the subscriptions that a participant has had on the focus that has handed it over to us to the conference
+// that the participant has just joined. They're only restored once, the participant takes care of them afterwards.
+func (r *Router) restoreSubscriptions(invite signaling.IncomingMessage, sender participant.ID) {
+	handoff := r.handoffs[invite.ConferenceID]
+	conference := r.conferenceSinks[invite.ConferenceID]
+
+	for i, handedOver := range handoff.participants {
+		if handedOver.UserID != invite.UserID || handedOver.DeviceID != invite.DeviceID {
+			continue
+		}
+
+		subscriptions := handedOver.Subscriptions
+		handoff.participants[i].Subscriptions = nil
+		if len(subscriptions) == 0 {
+			return
+		}
+
+		select {
+		case <-conference.done:
+		case conference.control <- conf.RestoreSubscriptions{Participant: sender, Subscriptions: subscriptions}:
+		}
+
+		return
+	}
 }
 
-// Handles incoming To-Device events that the SFU receives from clients.
-func (r *Router) handleMatrixEvent(evt *event.Event) {
-	var (
-		conferenceID string
-		callID       string
-		deviceID     string
-		userID       = evt.Sender
-	)
-
-	// Check if `conf_id` is present in the message (right messages do have it).
-	rawConferenceID, okConferenceId := evt.Content.Raw["conf_id"]
-	rawCallID, okCallId := evt.Content.Raw["call_id"]
-	rawDeviceID, okDeviceID := evt.Content.Raw["device_id"]
-
-	if okConferenceId && okCallId && okDeviceID {
-		// Extract the conference ID from the message.
-		conferenceID, okConferenceId = rawConferenceID.(string)
-		callID, okCallId = rawCallID.(string)
-		deviceID, okDeviceID = rawDeviceID.(string)
-
-		if !okConferenceId || !okCallId || !okDeviceID {
-			logrus.Warn("Ignoring invalid message without IDs")
+// Forgets the handoffs whose participants had enough time to call us.
+func (r *Router) expireHandoffs(now time.Time) {
+	for conferenceID, handoff := range r.handoffs {
+		if now.After(handoff.expires) {
+			delete(r.handoffs, conferenceID)
+		}
+	}
+}
+
+// Checks if a conference is still running on this SFU.
+func (r *Router) isRunning(conferenceID string) bool {
+	conference := r.conferenceSinks[conferenceID]
+	if conference == nil {
+		return false
+	}
+
+	select {
+	case <-conference.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Handles incoming signaling messages that the SFU receives from clients.
+func (r *Router) handleMessage(msg signaling.IncomingMessage) {
+	logger := logrus.WithFields(logrus.Fields{
+		"type":      msg.Type.Type,
+		"user_id":   msg.UserID,
+		"conf_id":   msg.ConferenceID,
+		"device_id": msg.DeviceID,
+	})
+
+	// Handoffs are not part of any call, they tell us about the calls to come.
+	if content, isHandoff := msg.Content.(*signaling.CallHandoffEventContent); isHandoff {
+		r.handleHandoff(msg, content)
+		return
+	}
+
+	_, isInvite := msg.Content.(*event.CallInviteEventContent)
+
+	// Invites start new calls, so they may come with any session ID. All other messages must be meant for this
+	// very process; an unknown session means that the sender talks to the SFU as it was before a restart. The
+	// session may be empty though, if the sender has not heard from us yet (e.g. candidates sent before our answer).
+	if !isInvite && msg.DestSessionID != "" && msg.DestSessionID != signaling.LocalSessionID {
+		// There is no point in hanging up on a hangup.
+		if _, isHangup := msg.Content.(*event.CallHangupEventContent); !isHangup {
+			logger.Info("rejecting message for an unknown session, the sender must re-invite")
+			r.reject(msg, signaling.Hangup{Reason: signaling.CallHangupSessionMismatch})
+		}
+		return
+	}
+
+	if isInvite {
+		isFocus := r.config.Cascade.IsFocus(msg.UserID, msg.DeviceID)
+
+		// The foci that we cascade with are trusted, but only if the transport makes sure that they are who
+		// they claim to be, anyone could pretend to be one of them otherwise.
+		if isFocus && !r.authenticatesSenders() {
+			logger.Warn("rejecting link from another focus since the transport does not authenticate the senders")
+			r.reject(msg, signaling.Hangup{Reason: signaling.CallHangupNotAllowed})
 			return
 		}
+
+		if !r.checkInvite(msg, isFocus, logger) {
+			return
+		}
+
+		// End-users must be authorized to start or join a conference and they may only call us if we're the focus
+		// that they are supposed to use for this call. Both may need network requests, so they're checked in the
+		// background.
+		if !isFocus {
+			r.admit(msg)
+			return
+		}
+	} else if _, found := r.admitting[pendingKeyOf(msg)]; found {
+		// The call may not be handled before we know if its invite is accepted.
+		if !r.pending.add(msg) {
+			logger.Warnf("ignoring %s since there are too many messages for the call", msg.Type.Type)
+		}
+		return
+	}
+
+	r.route(msg)
+}
+
+// Checks if an invite may still be accepted (it may change while the invite is being checked in the background).
+// Rejects the invite and returns false if not.
+func (r *Router) checkInvite(msg signaling.IncomingMessage, isFocus bool, logger *logrus.Entry) bool {
+	conferenceID := msg.ConferenceID
+
+	if r.shuttingDown {
+		logger.Info("rejecting invite since the SFU is shutting down")
+		r.reject(msg, signaling.Hangup{Reason: signaling.CallHangupFocusShutdown})
+		return false
 	}
 
+	// Nobody may join a conference that is being moved away from us.
+	if focus := r.migratedTo(conferenceID); focus != nil {
+		logger.Infof("rejecting invite since the conference moved to %s (%s)", focus.UserID, focus.DeviceID)
+		hangup := signaling.Hangup{Reason: signaling.CallHangupFocusMismatch, Focus: focus}
+		if isFocus {
+			hangup = signaling.Hangup{Reason: event.CallHangupUserHangup}
+		}
+		r.reject(msg, hangup)
+		return false
+	}
+
+	// Other foci may only link to the conferences that already exist on this SFU,
+	// there is no point in starting a conference that has no end-users.
+	if isFocus && r.conferenceSinks[conferenceID] == nil {
+		logger.Infof("rejecting link from another focus since the conference %s is unknown", conferenceID)
+		r.reject(msg, signaling.Hangup{
+			Reason: event.CallHangupUserHangup,
+		})
+		return false
+	}
+
+	return true
+}
+
+// Checks an invite of an end-user in the background and posts the decision back to the main loop.
+func (r *Router) admit(msg signaling.IncomingMessage) {
+	r.admitting[pendingKeyOf(msg)] = struct{}{}
+
+	// The participants that another focus has handed over to us still have that focus in the room state.
+	handedOver := r.isHandedOver(msg)
+
+	go func() {
+		result := admission{invite: msg}
+		if err := r.authorizer.Authorize(msg); err != nil {
+			result.rejection, result.err = &signaling.Hangup{Reason: rejectionReason(err)}, err
+		} else if !handedOver {
+			if focus, ok := r.checkFocus(msg); !ok {
+				result.rejection = &signaling.Hangup{Reason: signaling.CallHangupFocusMismatch, Focus: focus}
+			}
+		}
+
+		r.admissions <- result
+	}()
+}
+
+// Handles the decision on an invite that has been checked in the background.
+func (r *Router) handleAdmission(result admission) {
+	msg := result.invite
+	delete(r.admitting, pendingKeyOf(msg))
+
 	logger := logrus.WithFields(logrus.Fields{
-		"type":      evt.Type.Type,
-		"user_id":   userID,
+		"user_id":   msg.UserID,
+		"conf_id":   msg.ConferenceID,
+		"device_id": msg.DeviceID,
+	})
+
+	if result.rejection != nil {
+		if result.err != nil {
+			logger.WithError(result.err).Info("rejecting invite from an unauthorized caller")
+		} else if focus := result.rejection.Focus; focus != nil {
+			logger.Infof("rejecting invite since the caller must use %s (%s)", focus.UserID, focus.DeviceID)
+		} else {
+			logger.Info("rejecting invite since the focus of the caller is unknown")
+		}
+		r.reject(msg, *result.rejection)
+		return
+	}
+
+	if !r.checkInvite(msg, false, logger) {
+		return
+	}
+
+	r.route(msg)
+}
+
+// Starts a conference for an invite or sends a message to the conference that it belongs to.
+func (r *Router) route(msg signaling.IncomingMessage) {
+	conferenceID := msg.ConferenceID
+
+	logger := logrus.WithFields(logrus.Fields{
+		"type":      msg.Type.Type,
+		"user_id":   msg.UserID,
 		"conf_id":   conferenceID,
-		"device_id": deviceID,
+		"device_id": msg.DeviceID,
 	})
 
 	conference := r.conferenceSinks[conferenceID]
 
+	// Sender of the To-Device message.
+	sender := participant.ID{UserID: msg.UserID, DeviceID: msg.DeviceID, CallID: msg.CallID}
+
+	invite, isInvite := msg.Content.(*event.CallInviteEventContent)
+	call := pendingKeyOf(msg)
+
 	// Only ToDeviceCallInvite events are allowed to create a new conference, others
 	// are expected to operate on an existing conference that is running on the SFU.
-	if conference == nil && evt.Type.Type == event.ToDeviceCallInvite.Type {
+	if conference == nil && isInvite {
 		logger.Infof("creating new conference %s", conferenceID)
 
 		matrixEvents := make(chan conf.MatrixMessage)
+		controlMessages := make(chan conf.ControlMessage)
 
 		conferenceDone, err := conf.StartConference(
 			conferenceID,
 			r.config,
 			r.connectionFactory,
-			r.matrix.CreateForConference(conferenceID),
+			r.transport.CreateForConference(conferenceID),
 			matrixEvents,
-			userID,
-			evt.Content.AsCallInvite(),
+			controlMessages,
+			msg.UserID,
+			invite,
 		)
 		if err != nil {
 			logger.WithError(err).Errorf("failed to start conference %s", conferenceID)
 			return
 		}
 
-		r.conferenceSinks[conferenceID] = &conferenceStage{matrixEvents, conferenceDone}
+		r.conferenceSinks[conferenceID] = &conferenceStage{
+			sink:    matrixEvents,
+			control: controlMessages,
+			done:    conferenceDone,
+		}
+
+		r.calls[call] = struct{}{}
+		r.restoreSubscriptions(msg, sender)
+		r.replayPending(msg)
 		return
 	}
 
-	// All other events are expected to be handled by the existing conference.
-	if conference == nil {
-		logger.Warnf("ignoring %s since the conference is unknown", evt.Type.Type)
+	// All other events are expected to belong to a call that the conference already knows about. If it does not,
+	// the message may have overtaken the invite that starts the call (or even the conference), so we keep it for
+	// a while. The foci that we've linked to answer our own invites though, so their calls are always known.
+	_, isKnownCall := r.calls[call]
+	isFocus := r.config.Cascade.IsFocus(msg.UserID, msg.DeviceID)
+	if !isInvite && (conference == nil || !isKnownCall && !isFocus) {
+		if !r.pending.add(msg) {
+			logger.Warnf("ignoring %s since the call is unknown", msg.Type.Type)
+		} else {
+			logger.Debugf("keeping %s until the invite arrives", msg.Type.Type)
+		}
 		return
 	}
 
-	// Sender of the To-Device message.
-	sender := participant.ID{userID, id.DeviceID(deviceID), callID}
-
 	var content conf.MessageContent
-	switch evt.Type.Type {
+	switch msg.Content.(type) {
 	// Someone tries to participate in a call (join a call).
-	case event.ToDeviceCallInvite.Type:
-		// If there is an invitation sent and the conference does not exist, create one.
-		content = evt.Content.AsCallInvite()
-	case event.ToDeviceCallCandidates.Type:
+	case *event.CallInviteEventContent,
 		// Someone tries to send ICE candidates to the existing call.
-		content = evt.Content.AsCallCandidates()
-	case event.ToDeviceCallSelectAnswer.Type:
+		*event.CallCandidatesEventContent,
+		// A focus that we've linked to answers our invite.
+		*event.CallAnswerEventContent,
 		// Someone informs us about them accepting our (SFU's) SDP answer for an existing call.
-		content = evt.Content.AsCallSelectAnswer()
-	case event.ToDeviceCallHangup.Type:
+		*event.CallSelectAnswerEventContent,
 		// Someone tries to inform us about leaving an existing call.
-		content = evt.Content.AsCallHangup()
+		*event.CallHangupEventContent,
+		// Someone renegotiates or updates their metadata over Matrix since their data channel is not available.
+		*event.CallNegotiateEventContent,
+		*signaling.CallSDPStreamMetadataChangedEventContent:
+		content = msg.Content
 	default:
-		logger.Warnf("ignoring event that we must not receive: %s", evt.Type.Type)
+		logger.Warnf("ignoring event that we must not receive: %s", msg.Type.Type)
 		return
 	}
 
@@ -158,18 +636,88 @@ func (r *Router) handleMatrixEvent(evt *event.Event) {
 	select {
 	case <-conference.done:
 		// Conference has just gotten closed, let's remove it from the list of conferences.
-		delete(r.conferenceSinks, conferenceID)
-		close(conference.sink)
+		r.removeConference(conferenceID)
 
 		// Since we were not able to send the message, let's re-process it now.
-		r.handleMatrixEvent(evt)
+		r.route(msg)
 	case conference.sink <- conf.MatrixMessage{Content: content, Sender: sender}:
-		// Ok,sent!
-		return
+		// Ok,sent! The messages that overtook the invite can be handled now (after the invite).
+		switch msg.Content.(type) {
+		case *event.CallInviteEventContent:
+			r.calls[call] = struct{}{}
+			r.restoreSubscriptions(msg, sender)
+			r.replayPending(msg)
+		case *event.CallHangupEventContent:
+			delete(r.calls, call)
+		}
+	}
+}
+
+// Forgets a conference that has ended along with its calls.
+func (r *Router) removeConference(conferenceID string) {
+	conference := r.conferenceSinks[conferenceID]
+	delete(r.conferenceSinks, conferenceID)
+	close(conference.sink)
+	close(conference.control)
+
+	for call := range r.calls {
+		if call.conferenceID == conferenceID {
+			delete(r.calls, call)
+		}
+	}
+}
+
+// Handles the messages that arrived before the invite of their call (if any).
+func (r *Router) replayPending(invite signaling.IncomingMessage) {
+	for _, msg := range r.pending.take(invite) {
+		r.handleMessage(msg)
+	}
+}
+
+// Sends a hangup to the sender of a message that we don't want to accept. The hangup is sent in the
+// background, so that the retries (if the homeserver is not available) don't block the router.
+func (r *Router) reject(msg signaling.IncomingMessage, hangup signaling.Hangup) {
+	// The call won't take place, so the messages that overtook the invite are of no use.
+	r.pending.take(msg)
+
+	message := signaling.MatrixMessage{
+		Recipient: signaling.MatrixRecipient{
+			UserID:          msg.UserID,
+			DeviceID:        msg.DeviceID,
+			CallID:          msg.CallID,
+			RemoteSessionID: msg.SenderSessionID,
+		},
+		Message: hangup,
+	}
+
+	rejections := r.rejections
+	rejections.Add(1)
+	go func() {
+		defer rejections.Done()
+		r.transport.CreateForConference(msg.ConferenceID).SendMessages([]signaling.MatrixMessage{message})
+	}()
+}
+
+// Checks if the transport makes sure that the senders of the messages are who they claim to be.
+func (r *Router) authenticatesSenders() bool {
+	authenticator, ok := r.transport.(signaling.SenderAuthenticator)
+	return ok && authenticator.Authenticates()
+}
+
+// Returns the hangup reason that tells the caller why they have not been authorized.
+func rejectionReason(err error) event.CallHangupReason {
+	switch {
+	case errors.Is(err, auth.ErrNotRoomMember):
+		return signaling.CallHangupNotRoomMember
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return signaling.CallHangupInvalidCredentials
+	default:
+		return signaling.CallHangupNotAllowed
 	}
 }
 
 type conferenceStage struct {
-	sink chan<- conf.MatrixMessage
-	done <-chan struct{}
+	sink    chan<- conf.MatrixMessage
+	control chan<- conf.ControlMessage
+	done    <-chan struct{}
 }
@@ -0,0 +1,511 @@
+package routing_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/auth"
+	conf "github.com/matrix-org/waterfall/pkg/conference"
+	"github.com/matrix-org/waterfall/pkg/routing"
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Transport that records the messages that the router sends.
+type mockTransport struct {
+	sent chan signaling.MatrixMessage
+}
+
+func (t *mockTransport) Run(func(signaling.IncomingMessage)) error { return nil }
+func (t *mockTransport) Focus() signaling.Focus                    { return signaling.Focus{} }
+
+func (t *mockTransport) CreateForConference(string) signaling.MatrixSignaler {
+	return mockSignaler{t.sent}
+}
+
+type mockSignaler struct {
+	sent chan<- signaling.MatrixMessage
+}
+
+func (s mockSignaler) UserID() id.UserID     { return "@sfu:example.org" }
+func (s mockSignaler) DeviceID() id.DeviceID { return "SFU" }
+
+func (s mockSignaler) SendMessages(messages []signaling.MatrixMessage) []signaling.MatrixMessage {
+	for _, message := range messages {
+		s.sent <- message
+	}
+
+	return nil
+}
+
+func TestSessionMismatch(t *testing.T) {
+	transport := &mockTransport{sent: make(chan signaling.MatrixMessage, 10)}
+	messages := make(chan signaling.IncomingMessage)
+
+	if _, err := routing.StartRouter(transport, nil, messages, conf.Config{}, auth.Config{}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := func(callID string, session id.SessionID) signaling.IncomingMessage {
+		return signaling.IncomingMessage{
+			Type:          event.CallCandidates,
+			ConferenceID:  "conference",
+			CallID:        callID,
+			UserID:        "@alice:example.org",
+			DeviceID:      "ALICE",
+			DestSessionID: session,
+			Content:       &event.CallCandidatesEventContent{},
+		}
+	}
+
+	// The candidates that the caller trickles before our answer don't know our session yet. They are
+	// followed by the candidates of another call that were meant for the SFU before a restart.
+	messages <- candidates("new", "")
+	messages <- candidates("current", signaling.LocalSessionID)
+	messages <- candidates("stale", "previous-session")
+
+	select {
+	case message := <-transport.sent:
+		hangup, ok := message.Message.(signaling.Hangup)
+		if !ok || hangup.Reason != signaling.CallHangupSessionMismatch {
+			t.Fatalf("expected a session mismatch hangup, got %+v", message.Message)
+		}
+
+		if message.Recipient.CallID != "stale" {
+			t.Fatalf("expected the hangup for the stale call, got one for %s", message.Recipient.CallID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a hangup for the stale session")
+	}
+
+	select {
+	case message := <-transport.sent:
+		t.Fatalf("unexpected message to %s: %+v", message.Recipient.CallID, message.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Transport whose room state lookups block until they are released.
+type selectingTransport struct {
+	mockTransport
+	release chan struct{}
+}
+
+func (t *selectingTransport) GetCallMembers(id.RoomID) (signaling.CallMembers, error) {
+	<-t.release
+	return nil, errors.New("room state not available")
+}
+
+func TestFocusFallback(t *testing.T) {
+	for _, testCase := range []struct {
+		name   string
+		roomID id.RoomID
+		// Whether the room state is fetched for the invite.
+		fetchesState bool
+	}{
+		{name: "no room ID", roomID: ""},
+		{name: "lookup failure", roomID: "!room:example.org", fetchesState: true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			transport := &selectingTransport{
+				mockTransport: mockTransport{sent: make(chan signaling.MatrixMessage, 10)},
+				release:       make(chan struct{}),
+			}
+			messages := make(chan signaling.IncomingMessage)
+
+			config := conf.Config{FocusSelection: conf.FocusSelection{Fallback: conf.FocusFallbackReject}}
+			if _, err := routing.StartRouter(transport, nil, messages, config, auth.Config{}, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			messages <- signaling.IncomingMessage{
+				Type:         event.CallInvite,
+				RoomID:       testCase.roomID,
+				ConferenceID: "conference",
+				CallID:       "invite",
+				UserID:       "@alice:example.org",
+				DeviceID:     "ALICE",
+				Content:      &event.CallInviteEventContent{},
+			}
+
+			if !testCase.fetchesState {
+				expectHangup(t, transport.sent, "invite", signaling.CallHangupFocusMismatch)
+				return
+			}
+
+			// The router keeps handling the messages while the room state is being fetched.
+			messages <- signaling.IncomingMessage{
+				Type:          event.CallCandidates,
+				ConferenceID:  "conference",
+				CallID:        "stale",
+				UserID:        "@bob:example.org",
+				DeviceID:      "BOB",
+				DestSessionID: "previous-session",
+				Content:       &event.CallCandidatesEventContent{},
+			}
+
+			expectHangup(t, transport.sent, "stale", signaling.CallHangupSessionMismatch)
+			close(transport.release)
+			expectHangup(t, transport.sent, "invite", signaling.CallHangupFocusMismatch)
+		})
+	}
+}
+
+func expectHangup(t *testing.T, sent <-chan signaling.MatrixMessage, callID string, reason event.CallHangupReason) {
+	t.Helper()
+
+	select {
+	case message := <-sent:
+		hangup, ok := message.Message.(signaling.Hangup)
+		if !ok || hangup.Reason != reason {
+			t.Fatalf("expected a %s hangup, got %+v", reason, message.Message)
+		}
+
+		if message.Recipient.CallID != callID {
+			t.Fatalf("expected the hangup for %s, got one for %s", callID, message.Recipient.CallID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a hangup for %s", callID)
+	}
+}
+
+// Transport whose membership checks block until they're released, nobody is a member of any room.
+type membershipTransport struct {
+	mockTransport
+	release chan struct{}
+}
+
+func (t *membershipTransport) IsJoined(id.RoomID, id.UserID) (bool, error) {
+	<-t.release
+	return false, nil
+}
+
+func TestAuthorizationInBackground(t *testing.T) {
+	transport := &membershipTransport{
+		mockTransport: mockTransport{sent: make(chan signaling.MatrixMessage, 10)},
+		release:       make(chan struct{}),
+	}
+	messages := make(chan signaling.IncomingMessage)
+
+	authorization := auth.Config{RequireRoomMembership: true}
+	if _, err := routing.StartRouter(transport, nil, messages, conf.Config{}, authorization, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	messages <- signaling.IncomingMessage{
+		Type:         event.CallInvite,
+		RoomID:       "!room:example.org",
+		ConferenceID: "conference",
+		CallID:       "invite",
+		UserID:       "@alice:example.org",
+		DeviceID:     "ALICE",
+		Content:      &event.CallInviteEventContent{},
+	}
+
+	// The router keeps handling the messages while the membership is being checked.
+	messages <- signaling.IncomingMessage{
+		Type:          event.CallCandidates,
+		ConferenceID:  "conference",
+		CallID:        "stale",
+		UserID:        "@bob:example.org",
+		DeviceID:      "BOB",
+		DestSessionID: "previous-session",
+		Content:       &event.CallCandidatesEventContent{},
+	}
+
+	expectHangup(t, transport.sent, "stale", signaling.CallHangupSessionMismatch)
+	close(transport.release)
+	expectHangup(t, transport.sent, "invite", signaling.CallHangupNotRoomMember)
+}
+
+// Transport that may or may not authenticate the senders.
+type authenticatingTransport struct {
+	mockTransport
+	authenticates bool
+}
+
+func (t *authenticatingTransport) Authenticates() bool { return t.authenticates }
+
+func TestFocusLinkAuthentication(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		authenticates bool
+		reason        event.CallHangupReason
+	}{
+		// Anyone could pretend to be the other focus, so it's not trusted.
+		{name: "unauthenticated transport", authenticates: false, reason: signaling.CallHangupNotAllowed},
+		// The other focus is trusted, but it may only link to the conferences that we have.
+		{name: "authenticated transport", authenticates: true, reason: event.CallHangupUserHangup},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			transport := &authenticatingTransport{
+				mockTransport: mockTransport{sent: make(chan signaling.MatrixMessage, 10)},
+				authenticates: testCase.authenticates,
+			}
+			messages := make(chan signaling.IncomingMessage)
+
+			config := conf.Config{Cascade: conf.Cascade{Foci: []signaling.Focus{
+				{UserID: "@other-sfu:example.org", DeviceID: "OTHER"},
+			}}}
+			if _, err := routing.StartRouter(transport, nil, messages, config, auth.Config{}, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			messages <- signaling.IncomingMessage{
+				Type:         event.CallInvite,
+				ConferenceID: "conference",
+				CallID:       "link",
+				UserID:       "@other-sfu:example.org",
+				DeviceID:     "OTHER",
+				Content:      &event.CallInviteEventContent{},
+			}
+
+			expectHangup(t, transport.sent, "link", testCase.reason)
+		})
+	}
+}
+
+// Transport whose room state names another focus for everyone. Its membership checks block until they're released.
+type handoffTransport struct {
+	authenticatingTransport
+	release chan struct{}
+}
+
+func (t *handoffTransport) IsJoined(id.RoomID, id.UserID) (bool, error) {
+	<-t.release
+	return true, nil
+}
+
+func (t *handoffTransport) GetCallMembers(id.RoomID) (signaling.CallMembers, error) {
+	device := signaling.CallMemberDevice{
+		DeviceID:   "ALICE",
+		FociActive: []signaling.Focus{{UserID: "@old-sfu:example.org", DeviceID: "OLD"}},
+	}
+
+	return signaling.CallMembers{"@alice:example.org": signaling.CallMemberEventContent{
+		Calls: []signaling.CallMemberCall{{CallID: "conference", Devices: []signaling.CallMemberDevice{device}}},
+	}}, nil
+}
+
+func TestHandoff(t *testing.T) {
+	for _, testCase := range []struct {
+		name string
+		// The sender of the handoff (none if empty).
+		sender signaling.Focus
+		// The participant that is handed over.
+		handedOver id.DeviceID
+		// The reason of the hangup that the caller gets.
+		reason event.CallHangupReason
+	}{
+		{name: "no handoff", reason: signaling.CallHangupFocusMismatch},
+		{
+			name:       "handoff from a trusted focus",
+			sender:     signaling.Focus{UserID: "@old-sfu:example.org", DeviceID: "OLD"},
+			handedOver: "ALICE",
+			// The invite is accepted, only the shutdown (that happens meanwhile) rejects it.
+			reason: signaling.CallHangupFocusShutdown,
+		},
+		{
+			name:       "handoff of another participant",
+			sender:     signaling.Focus{UserID: "@old-sfu:example.org", DeviceID: "OLD"},
+			handedOver: "BOB",
+			reason:     signaling.CallHangupFocusMismatch,
+		},
+		{
+			name:       "handoff from an unknown focus",
+			sender:     signaling.Focus{UserID: "@mallory:example.org", DeviceID: "MALLORY"},
+			handedOver: "ALICE",
+			reason:     signaling.CallHangupFocusMismatch,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			transport := &handoffTransport{
+				authenticatingTransport: authenticatingTransport{
+					mockTransport: mockTransport{sent: make(chan signaling.MatrixMessage, 10)},
+					authenticates: true,
+				},
+				release: make(chan struct{}),
+			}
+			messages := make(chan signaling.IncomingMessage)
+
+			config := conf.Config{Cascade: conf.Cascade{Foci: []signaling.Focus{
+				{UserID: "@old-sfu:example.org", DeviceID: "OLD"},
+			}}}
+			authorization := auth.Config{RequireRoomMembership: true}
+			router, err := routing.StartRouter(transport, nil, messages, config, authorization, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if testCase.sender.UserID != "" {
+				messages <- signaling.IncomingMessage{
+					Type:         signaling.ToDeviceCallHandoff,
+					ConferenceID: "conference",
+					UserID:       testCase.sender.UserID,
+					DeviceID:     testCase.sender.DeviceID,
+					Content: &signaling.CallHandoffEventContent{Participants: []signaling.HandoffParticipant{
+						{UserID: "@alice:example.org", DeviceID: testCase.handedOver},
+					}},
+				}
+			}
+
+			messages <- signaling.IncomingMessage{
+				Type:         event.CallInvite,
+				RoomID:       "!room:example.org",
+				ConferenceID: "conference",
+				CallID:       "invite",
+				UserID:       "@alice:example.org",
+				DeviceID:     "ALICE",
+				Content:      &event.CallInviteEventContent{},
+			}
+
+			// The accepted invites would start a conference, so the router is shut down before.
+			router.Shutdown(time.Second)
+			close(transport.release)
+			expectHangup(t, transport.sent, "invite", testCase.reason)
+		})
+	}
+}
+
+func TestShutdownWaitsForRejections(t *testing.T) {
+	for _, testCase := range []struct {
+		name string
+		// Whether the hangup for the rejected caller gets sent before the timeout.
+		delivered bool
+	}{
+		{name: "hangup sent", delivered: true},
+		{name: "timeout", delivered: false},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			// Nobody reads the sent messages until the hangup is delivered, so sending it blocks.
+			transport := &mockTransport{sent: make(chan signaling.MatrixMessage)}
+			messages := make(chan signaling.IncomingMessage)
+
+			router, err := routing.StartRouter(transport, nil, messages, conf.Config{}, auth.Config{}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			messages <- signaling.IncomingMessage{
+				Type:          event.CallCandidates,
+				ConferenceID:  "conference",
+				CallID:        "stale",
+				UserID:        "@alice:example.org",
+				DeviceID:      "ALICE",
+				DestSessionID: "previous-session",
+				Content:       &event.CallCandidatesEventContent{},
+			}
+
+			done := make(chan struct{})
+			go func() {
+				router.Shutdown(200 * time.Millisecond)
+				close(done)
+			}()
+
+			if testCase.delivered {
+				select {
+				case <-done:
+					t.Fatal("the shutdown did not wait for the hangup")
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				expectHangup(t, transport.sent, "stale", signaling.CallHangupSessionMismatch)
+			}
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("the shutdown did not return")
+			}
+		})
+	}
+}
+
+func TestDuplicateMessages(t *testing.T) {
+	stale := func(raw string, transactionID string) signaling.IncomingMessage {
+		return signaling.IncomingMessage{
+			Type:          event.CallCandidates,
+			ConferenceID:  "conference",
+			CallID:        "stale",
+			UserID:        "@alice:example.org",
+			DeviceID:      "ALICE",
+			DestSessionID: "previous-session",
+			Content:       &event.CallCandidatesEventContent{},
+			RawContent:    []byte(raw),
+			TransactionID: transactionID,
+		}
+	}
+
+	for _, testCase := range []struct {
+		name     string
+		messages []signaling.IncomingMessage
+		// Whether the messages are delivered again after a restart.
+		restart bool
+		// How many of the messages are handled (each of them gets a hangup).
+		handled int
+	}{
+		{
+			name:     "same content",
+			messages: []signaling.IncomingMessage{stale(`{"a":1}`, ""), stale(`{"a":1}`, "")},
+			handled:  1,
+		},
+		{
+			name:     "different content",
+			messages: []signaling.IncomingMessage{stale(`{"a":1}`, ""), stale(`{"a":2}`, "")},
+			handled:  2,
+		},
+		{
+			name:     "same transaction with different content",
+			messages: []signaling.IncomingMessage{stale(`{"a":1}`, "txn1"), stale(`{"a":2}`, "txn1")},
+			handled:  1,
+		},
+		{
+			name:     "different transactions with same content",
+			messages: []signaling.IncomingMessage{stale(`{"a":1}`, "txn1"), stale(`{"a":1}`, "txn2")},
+			handled:  2,
+		},
+		{
+			name:     "delivered again after a restart",
+			messages: []signaling.IncomingMessage{stale(`{"a":1}`, "")},
+			restart:  true,
+			handled:  1,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			transport := &mockTransport{sent: make(chan signaling.MatrixMessage, 10)}
+			path := filepath.Join(t.TempDir(), "seen_messages")
+
+			deliver := func() {
+				messages := make(chan signaling.IncomingMessage)
+				if _, err := routing.StartRouter(transport, nil, messages, conf.Config{}, auth.Config{}, path); err != nil {
+					t.Fatal(err)
+				}
+
+				for _, msg := range testCase.messages {
+					messages <- msg
+				}
+				close(messages)
+			}
+
+			deliver()
+			if testCase.restart {
+				// Let the first router finish before the second one reads the seen messages.
+				time.Sleep(50 * time.Millisecond)
+				deliver()
+			}
+
+			for i := 0; i < testCase.handled; i++ {
+				expectHangup(t, transport.sent, "stale", signaling.CallHangupSessionMismatch)
+			}
+
+			select {
+			case message := <-transport.sent:
+				t.Fatalf("unexpected message to %s: %+v", message.Recipient.CallID, message.Message)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"maunium.net/go/mautrix/id"
+)
+
+// The type of the state event that describes which devices of a user participate in which calls (MSC3401).
+const CallMemberEventType = "m.call.member"
+
+// Content of the `m.call.member` state event.
+type CallMemberEventContent struct {
+	Calls []CallMemberCall `json:"m.calls"`
+}
+
+// A call that the member participates in.
+type CallMemberCall struct {
+	CallID  string             `json:"m.call_id"`
+	Devices []CallMemberDevice `json:"m.devices"`
+}
+
+// A device of the member that participates in the call, along with the foci that it uses (MSC3898).
+type CallMemberDevice struct {
+	DeviceID  id.DeviceID  `json:"device_id"`
+	SessionID id.SessionID `json:"session_id"`
+	// The foci that the device is currently connected to.
+	FociActive []Focus `json:"foci_active"`
+	// The foci that the device would like to use (in the order of preference).
+	FociPreferred []Focus `json:"foci_preferred"`
+}
+
+// The `m.call.member` state events of a room keyed by the user ID (the state key).
+type CallMembers map[id.UserID]CallMemberEventContent
+
+// Fetches the `m.call.member` state events of a given room.
+func (m *MatrixClient) GetCallMembers(roomID id.RoomID) (CallMembers, error) {
+	state, err := m.client.State(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the state of %s: %w", roomID, err)
+	}
+
+	members := make(CallMembers)
+	for eventType, events := range state {
+		if eventType.Type != CallMemberEventType {
+			continue
+		}
+
+		for stateKey, evt := range events {
+			var content CallMemberEventContent
+			if err := json.Unmarshal(evt.Content.VeryRaw, &content); err != nil {
+				logrus.WithError(err).WithField("user_id", stateKey).Warn("Ignoring invalid call member event")
+				continue
+			}
+
+			members[id.UserID(stateKey)] = content
+		}
+	}
+
+	return members, nil
+}
+
+// Returns the foci that a given device must use for a given call. If the device has already chosen its foci,
+// those are returned. Otherwise, we select one of the preferred foci of the device, giving the priority to the
+// foci that are already used by other participants of the call, so that we don't need to cascade. Returns an
+// empty list if the members don't tell us anything about the foci of the device (e.g. older clients).
+func (m CallMembers) ChosenFoci(callID string, userID id.UserID, deviceID id.DeviceID) []Focus {
+	device := m.getDevice(callID, userID, deviceID)
+	if device == nil {
+		return nil
+	}
+
+	if len(device.FociActive) != 0 {
+		return device.FociActive
+	}
+
+	if len(device.FociPreferred) == 0 {
+		return nil
+	}
+
+	for _, preferred := range device.FociPreferred {
+		if m.isFocusActive(callID, preferred) {
+			return []Focus{preferred}
+		}
+	}
+
+	return device.FociPreferred[:1]
+}
+
+func (m CallMembers) getDevice(callID string, userID id.UserID, deviceID id.DeviceID) *CallMemberDevice {
+	for _, call := range m[userID].Calls {
+		if call.CallID != callID {
+			continue
+		}
+
+		for i := range call.Devices {
+			if call.Devices[i].DeviceID == deviceID {
+				return &call.Devices[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks if any device in the call is connected to a given focus.
+func (m CallMembers) isFocusActive(callID string, focus Focus) bool {
+	for _, member := range m {
+		for _, call := range member.Calls {
+			if call.CallID != callID {
+				continue
+			}
+
+			for _, device := range call.Devices {
+				if slices.Contains(device.FociActive, focus) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
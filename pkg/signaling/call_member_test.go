@@ -0,0 +1,79 @@
+package signaling_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"golang.org/x/exp/slices"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestChosenFoci(t *testing.T) {
+	// Shortcuts for easy and descriptive test case definition.
+	sfu1 := signaling.Focus{UserID: "@sfu:one", DeviceID: "SFU1"}
+	sfu2 := signaling.Focus{UserID: "@sfu:two", DeviceID: "SFU2"}
+	sfu3 := signaling.Focus{UserID: "@sfu:three", DeviceID: "SFU3"}
+
+	foci := func(foci ...signaling.Focus) []signaling.Focus {
+		return foci
+	}
+
+	member := func(deviceID id.DeviceID, active, preferred []signaling.Focus) signaling.CallMemberEventContent {
+		return signaling.CallMemberEventContent{
+			Calls: []signaling.CallMemberCall{{
+				CallID: "conf",
+				Devices: []signaling.CallMemberDevice{{
+					DeviceID:      deviceID,
+					FociActive:    active,
+					FociPreferred: preferred,
+				}},
+			}},
+		}
+	}
+
+	cases := []struct {
+		members  signaling.CallMembers
+		expected []signaling.Focus
+	}{
+		// No member event at all (older clients).
+		{signaling.CallMembers{}, nil},
+		// Member event without any foci.
+		{signaling.CallMembers{"@alice:one": member("ALICE", nil, nil)}, nil},
+		// The device has already chosen its foci.
+		{signaling.CallMembers{"@alice:one": member("ALICE", foci(sfu2), foci(sfu1))}, foci(sfu2)},
+		// The device did not choose, so the most preferred one is chosen.
+		{signaling.CallMembers{"@alice:one": member("ALICE", nil, foci(sfu1, sfu2))}, foci(sfu1)},
+		// The preferred focus that is already used by others is chosen.
+		{
+			signaling.CallMembers{
+				"@alice:one": member("ALICE", nil, foci(sfu1, sfu2, sfu3)),
+				"@bob:two":   member("BOB", foci(sfu3), nil),
+			},
+			foci(sfu3),
+		},
+		// Foci used by others are ignored if they are not preferred by the device.
+		{
+			signaling.CallMembers{
+				"@alice:one": member("ALICE", nil, foci(sfu1, sfu2)),
+				"@bob:two":   member("BOB", foci(sfu3), nil),
+			},
+			foci(sfu1),
+		},
+	}
+
+	for i, c := range cases {
+		chosen := c.members.ChosenFoci("conf", "@alice:one", "ALICE")
+		if !slices.Equal(chosen, c.expected) {
+			t.Errorf("case %d: expected %v, got %v", i, c.expected, chosen)
+		}
+	}
+
+	// Other calls and other devices are not taken into account.
+	members := signaling.CallMembers{"@alice:one": member("ALICE", foci(sfu1), nil)}
+	if chosen := members.ChosenFoci("another", "@alice:one", "ALICE"); chosen != nil {
+		t.Errorf("expected no foci for another call, got %v", chosen)
+	}
+	if chosen := members.ChosenFoci("conf", "@alice:one", "PHONE"); chosen != nil {
+		t.Errorf("expected no foci for another device, got %v", chosen)
+	}
+}
@@ -2,22 +2,37 @@ package signaling
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 type MatrixClient struct {
 	client *mautrix.Client
+	// Olm encryption of the to-device messages (nil if disabled).
+	encryption encryption
 }
 
-func NewMatrixClient(config Config) *MatrixClient {
+// Creates a new Matrix client. If the data directory is set, the sync token
+// (and the crypto store if the encryption is enabled) is persisted there.
+func NewMatrixClient(config Config, dataDirectory string) *MatrixClient {
 	client, err := mautrix.NewClient(config.HomeserverURL, config.UserID, config.AccessToken)
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to create client")
 	}
 
+	if dataDirectory != "" {
+		store, err := NewFileStore(filepath.Join(dataDirectory, "sync.json"))
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to load sync store")
+		}
+
+		client.Store = store
+	}
+
 	whoami, err := client.Whoami()
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to identify SFU user")
@@ -30,20 +45,54 @@ func NewMatrixClient(config Config) *MatrixClient {
 	logrus.WithField("device_id", whoami.DeviceID).Info("Identified SFU as DeviceID")
 	client.DeviceID = whoami.DeviceID
 
+	var olm encryption
+	if config.Encryption {
+		if olm, err = newEncryption(client, filepath.Join(dataDirectory, "crypto.gob")); err != nil {
+			logrus.WithError(err).Fatal("Failed to initialize encryption")
+		}
+
+		logrus.Info("To-device messages are encrypted with Olm")
+	}
+
 	return &MatrixClient{
-		client: client,
+		client:     client,
+		encryption: olm,
+	}
+}
+
+// Returns the focus that this client represents (i.e. us).
+func (m *MatrixClient) Focus() Focus {
+	return Focus{UserID: m.client.UserID, DeviceID: m.client.DeviceID}
+}
+
+// The homeserver makes sure that the senders of the to-device messages are who they claim to be.
+func (m *MatrixClient) Authenticates() bool {
+	return true
+}
+
+// Checks if a given user is joined to a given room. The SFU must be able to see the state of the room.
+func (m *MatrixClient) IsJoined(roomID id.RoomID, userID id.UserID) (bool, error) {
+	var member event.MemberEventContent
+	if err := m.client.StateEvent(roomID, event.StateMember, userID.String(), &member); err != nil {
+		return false, fmt.Errorf("failed to get the membership of %s in %s: %w", userID, roomID, err)
 	}
+
+	return member.Membership == event.MembershipJoin, nil
 }
 
 // Starts the Matrix client and connects to the homeserver,
 // Returns only when the sync with Matrix stops or fails.
-func (m *MatrixClient) RunSync(callback func(*event.Event)) error {
+func (m *MatrixClient) Run(handler func(IncomingMessage)) error {
 	syncer, ok := m.client.Syncer.(*mautrix.DefaultSyncer)
 	if !ok {
 		return fmt.Errorf("syncer is not a DefaultSyncer")
 	}
 
 	syncer.ParseEventContent = true
+	if m.encryption != nil {
+		syncer.OnSync(m.encryption.processSync)
+	}
+
 	syncer.OnEvent(func(_ mautrix.EventSource, evt *event.Event) {
 		// We only care about to-device events but also receive m.presence and
 		// m.push_rules events; we can simply ignore those.
@@ -51,13 +100,29 @@ func (m *MatrixClient) RunSync(callback func(*event.Event)) error {
 			return
 		}
 
-		// We drop the messages if they are not meant for us.
-		if evt.Content.Raw["dest_session_id"] != LocalSessionID {
-			logrus.Warn("SessionID does not match our SessionID - ignoring")
+		// Encrypted messages are decrypted before anything else looks at them.
+		if evt.Type.Type == event.ToDeviceEncrypted.Type {
+			if m.encryption == nil {
+				logrus.WithField("sender", evt.Sender).Warn("Ignoring encrypted message, encryption is disabled")
+				return
+			}
+
+			decrypted, err := m.encryption.decrypt(evt)
+			if err != nil {
+				logrus.WithError(err).WithField("sender", evt.Sender).Warn("Failed to decrypt message")
+				return
+			}
+
+			evt = decrypted
+		}
+
+		message, err := parseCallEvent(evt)
+		if err != nil {
+			logrus.WithError(err).WithField("type", evt.Type.Type).Warn("Ignoring invalid message")
 			return
 		}
 
-		callback(evt)
+		handler(message)
 	})
 
 	// TODO: We may want to reconnect if `Sync()` fails instead of ending the SFU
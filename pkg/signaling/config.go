@@ -10,4 +10,7 @@ type Config struct {
 	HomeserverURL string `yaml:"homeserverUrl"`
 	// The access token for the Matrix SDK.
 	AccessToken string `yaml:"accessToken"`
+	// Whether the to-device messages should be encrypted with Olm. Requires the data directory
+	// (where the crypto store is kept) and the SFU built with the `olm` build tag.
+	Encryption bool `yaml:"encryption"`
 }
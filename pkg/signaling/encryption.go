@@ -0,0 +1,24 @@
+package signaling
+
+import (
+	"errors"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+var (
+	ErrEncryptionNotSupported = errors.New("the SFU was built without Olm support (use the `olm` build tag)")
+	ErrUnknownDevice          = errors.New("unknown device")
+)
+
+// End-to-end encryption (Olm) of the to-device messages. The implementation requires libolm,
+// so it's only available if the SFU is built with the `olm` build tag.
+type encryption interface {
+	// Decrypts an incoming `m.room.encrypted` to-device event into the event that it carries.
+	decrypt(evt *event.Event) (*event.Event, error)
+	// Encrypts a to-device event for the recipient device and sends it.
+	sendEncrypted(recipient MatrixRecipient, eventType event.Type, content *event.Content) error
+	// Keeps the device lists and the one-time keys up to date (called upon each sync response).
+	processSync(resp *mautrix.RespSync, since string) bool
+}
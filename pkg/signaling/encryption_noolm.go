@@ -0,0 +1,9 @@
+//go:build !olm
+
+package signaling
+
+import "maunium.net/go/mautrix"
+
+func newEncryption(client *mautrix.Client, storePath string) (encryption, error) {
+	return nil, ErrEncryptionNotSupported
+}
@@ -0,0 +1,222 @@
+//go:build olm
+
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// The Olm-encrypted payload was sent by another device than the one that claims to have sent it.
+var ErrSenderMismatch = errors.New("the encrypted payload does not belong to the sender")
+
+// The Olm-encrypted payload is not meant for us.
+var ErrRecipientMismatch = errors.New("the encrypted payload is not meant for us")
+
+// Olm encryption backed by the mautrix crypto machine and a file-based crypto store.
+type olmEncryption struct {
+	machine *crypto.OlmMachine
+	// The Olm account and sessions are not thread-safe, the mutex guards everything that uses them.
+	mutex sync.Mutex
+}
+
+func newEncryption(client *mautrix.Client, storePath string) (encryption, error) {
+	store, err := crypto.NewGobStore(storePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load crypto store: %w", err)
+	}
+
+	machine := crypto.NewOlmMachine(client, cryptoLogger{logrus.WithField("component", "olm")}, store, noRooms{})
+	if err := machine.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load Olm account: %w", err)
+	}
+
+	// Publish the device keys (if not published yet) and make sure that there are enough one-time keys.
+	// This also stores the account, so that it's available for decryption.
+	keys, err := client.UploadKeys(&mautrix.ReqUploadKeys{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the number of one-time keys: %w", err)
+	}
+
+	if err := machine.ShareKeys(keys.OneTimeKeyCounts.SignedCurve25519); err != nil {
+		return nil, fmt.Errorf("failed to share keys: %w", err)
+	}
+
+	return &olmEncryption{machine: machine}, nil
+}
+
+func (e *olmEncryption) processSync(resp *mautrix.RespSync, since string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// Note that we don't use `ProcessSyncResponse()` since it would decrypt (and
+	// thus consume) the to-device events that we decrypt ourselves.
+	e.machine.HandleDeviceLists(&resp.DeviceLists, since)
+	e.machine.HandleOTKCounts(&resp.DeviceOTKCount)
+	return true
+}
+
+func (e *olmEncryption) decrypt(evt *event.Event) (*event.Event, error) {
+	content, ok := evt.Content.Parsed.(*event.EncryptedEventContent)
+	if !ok {
+		return nil, crypto.IncorrectEncryptedContentType
+	} else if content.Algorithm != id.AlgorithmOlmV1 {
+		return nil, crypto.UnsupportedAlgorithm
+	}
+
+	// The machine does not export the decryption, so it's done with the account and the sessions in its store
+	// (the store holds the same account that the machine has loaded).
+	e.mutex.Lock()
+	account, err := e.machine.CryptoStore.GetAccount()
+	if err != nil || account == nil {
+		e.mutex.Unlock()
+		return nil, fmt.Errorf("failed to get the Olm account: %w", err)
+	}
+
+	ciphertext, ok := content.OlmCiphertext[account.IdentityKey()]
+	if !ok {
+		e.mutex.Unlock()
+		return nil, crypto.NotEncryptedForMe
+	}
+
+	plaintext, err := e.decryptCiphertext(account, content.SenderKey, ciphertext.Type, ciphertext.Body)
+	signingKey := account.SigningKey()
+	e.mutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the Olm event: %w", err)
+	}
+
+	var decrypted crypto.DecryptedOlmEvent
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil {
+		return nil, fmt.Errorf("failed to parse the Olm payload: %w", err)
+	}
+
+	if decrypted.Sender != evt.Sender {
+		return nil, ErrSenderMismatch
+	} else if decrypted.Recipient != e.machine.Client.UserID || decrypted.RecipientKeys.Ed25519 != signingKey {
+		return nil, ErrRecipientMismatch
+	}
+
+	// Make sure that the sender key belongs to the device that claims to have sent the event.
+	device, err := e.machine.GetOrFetchDeviceByKey(evt.Sender, content.SenderKey)
+	if err != nil || device == nil || device.DeviceID != decrypted.SenderDevice {
+		return nil, ErrSenderMismatch
+	}
+
+	eventType := event.Type{Type: decrypted.Type.Type, Class: event.ToDeviceEventType}
+	if err := decrypted.Content.ParseRaw(eventType); err != nil && !event.IsUnsupportedContentType(err) {
+		return nil, fmt.Errorf("failed to parse the content of the Olm payload: %w", err)
+	}
+
+	return &event.Event{
+		Sender:  evt.Sender,
+		Type:    eventType,
+		Content: decrypted.Content,
+	}, nil
+}
+
+// Decrypts an Olm message with one of the sessions that we have with the sender. If none of them matches,
+// a new (inbound) session is created from the message, which is possible if it's a pre-key message.
+// That's what the crypto machine does for the events that it handles itself.
+func (e *olmEncryption) decryptCiphertext(
+	account *crypto.OlmAccount,
+	senderKey id.SenderKey,
+	messageType id.OlmMsgType,
+	ciphertext string,
+) ([]byte, error) {
+	if messageType != id.OlmMsgTypePreKey && messageType != id.OlmMsgTypeMsg {
+		return nil, crypto.UnsupportedOlmMessageType
+	}
+
+	store := e.machine.CryptoStore
+	sessions, err := store.GetSessions(senderKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the sessions with %s: %w", senderKey, err)
+	}
+
+	for _, session := range sessions {
+		if messageType == id.OlmMsgTypePreKey {
+			if matches, err := session.Internal.MatchesInboundSession(ciphertext); err != nil || !matches {
+				continue
+			}
+		}
+
+		plaintext, err := session.Decrypt(ciphertext, messageType)
+		if err != nil {
+			if messageType == id.OlmMsgTypePreKey {
+				return nil, crypto.DecryptionFailedWithMatchingSession
+			}
+			continue
+		}
+
+		if err := store.UpdateSession(senderKey, session); err != nil {
+			logrus.WithError(err).Warn("Failed to update the Olm session")
+		}
+
+		return plaintext, nil
+	}
+
+	if messageType != id.OlmMsgTypePreKey {
+		return nil, crypto.DecryptionFailedForNormalMessage
+	}
+
+	session, err := account.NewInboundSessionFrom(senderKey, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a session from the pre-key message: %w", err)
+	}
+
+	// Creating the session uses up one of our one-time keys.
+	if err := store.PutAccount(account); err != nil {
+		logrus.WithError(err).Warn("Failed to store the Olm account")
+	}
+
+	plaintext, err := session.Decrypt(ciphertext, messageType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.AddSession(senderKey, session); err != nil {
+		logrus.WithError(err).Warn("Failed to store the Olm session")
+	}
+
+	return plaintext, nil
+}
+
+func (e *olmEncryption) sendEncrypted(recipient MatrixRecipient, eventType event.Type, content *event.Content) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	device, err := e.machine.GetOrFetchDevice(recipient.UserID, recipient.DeviceID)
+	if err != nil {
+		return fmt.Errorf("failed to get the keys of %s (%s): %w", recipient.UserID, recipient.DeviceID, err)
+	} else if device == nil {
+		return fmt.Errorf("%w: %s (%s)", ErrUnknownDevice, recipient.UserID, recipient.DeviceID)
+	}
+
+	return e.machine.SendEncryptedToDevice(device, eventType, *content)
+}
+
+// The SFU does not encrypt any room events, so it does not need to know anything about the rooms.
+type noRooms struct{}
+
+func (noRooms) IsEncrypted(id.RoomID) bool                                 { return false }
+func (noRooms) GetEncryptionEvent(id.RoomID) *event.EncryptionEventContent { return nil }
+func (noRooms) FindSharedRooms(id.UserID) []id.RoomID                      { return nil }
+
+// Adapts logrus to the logger of the crypto machine.
+type cryptoLogger struct {
+	logger *logrus.Entry
+}
+
+func (l cryptoLogger) Error(message string, args ...interface{}) { l.logger.Errorf(message, args...) }
+func (l cryptoLogger) Warn(message string, args ...interface{})  { l.logger.Warnf(message, args...) }
+func (l cryptoLogger) Debug(message string, args ...interface{}) { l.logger.Debugf(message, args...) }
+func (l cryptoLogger) Trace(message string, args ...interface{}) { l.logger.Tracef(message, args...) }
@@ -0,0 +1,256 @@
+//go:build olm
+
+package signaling_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// A device of the fake homeserver.
+type keysDevice struct {
+	userID   id.UserID
+	deviceID id.DeviceID
+	// Device keys and one-time keys as the device has uploaded them.
+	deviceKeys  json.RawMessage
+	oneTimeKeys map[string]json.RawMessage
+	// To-device events that wait for the next sync of the device.
+	inbox []json.RawMessage
+}
+
+// Homeserver that supports just enough of the API to exchange Olm-encrypted to-device events.
+type keysHomeserver struct {
+	mutex sync.Mutex
+	// Devices by their access tokens.
+	devices map[string]*keysDevice
+}
+
+func (h *keysHomeserver) find(userID id.UserID, deviceID id.DeviceID) *keysDevice {
+	for _, device := range h.devices {
+		if device.userID == userID && device.deviceID == deviceID {
+			return device
+		}
+	}
+
+	return nil
+}
+
+func (h *keysHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	device := h.devices[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if device == nil {
+		http.Error(w, `{"errcode": "M_UNKNOWN_TOKEN"}`, http.StatusUnauthorized)
+		return
+	}
+
+	respond := func(response interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+
+	switch path := r.URL.Path; {
+	case strings.HasSuffix(path, "/account/whoami"):
+		respond(map[string]interface{}{"user_id": device.userID, "device_id": device.deviceID})
+	case strings.HasSuffix(path, "/filter"):
+		respond(map[string]interface{}{"filter_id": "filter"})
+	case strings.HasSuffix(path, "/keys/upload"):
+		var request struct {
+			DeviceKeys  json.RawMessage            `json:"device_keys"`
+			OneTimeKeys map[string]json.RawMessage `json:"one_time_keys"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		if len(request.DeviceKeys) > 0 && string(request.DeviceKeys) != "null" {
+			device.deviceKeys = request.DeviceKeys
+		}
+		for keyID, key := range request.OneTimeKeys {
+			device.oneTimeKeys[keyID] = key
+		}
+
+		respond(map[string]interface{}{
+			"one_time_key_counts": map[string]int{"signed_curve25519": len(device.oneTimeKeys)},
+		})
+	case strings.HasSuffix(path, "/keys/query"):
+		var request mautrix.ReqQueryKeys
+		json.NewDecoder(r.Body).Decode(&request)
+
+		deviceKeys := map[id.UserID]map[id.DeviceID]json.RawMessage{}
+		for userID := range request.DeviceKeys {
+			deviceKeys[userID] = map[id.DeviceID]json.RawMessage{}
+			for _, other := range h.devices {
+				if other.userID == userID && other.deviceKeys != nil {
+					deviceKeys[userID][other.deviceID] = other.deviceKeys
+				}
+			}
+		}
+
+		respond(map[string]interface{}{"device_keys": deviceKeys})
+	case strings.HasSuffix(path, "/keys/claim"):
+		var request mautrix.ReqClaimKeys
+		json.NewDecoder(r.Body).Decode(&request)
+
+		claimed := map[id.UserID]map[id.DeviceID]map[string]json.RawMessage{}
+		for userID, devices := range request.OneTimeKeys {
+			claimed[userID] = map[id.DeviceID]map[string]json.RawMessage{}
+			for deviceID := range devices {
+				other := h.find(userID, deviceID)
+				if other == nil {
+					continue
+				}
+
+				for keyID, key := range other.oneTimeKeys {
+					claimed[userID][deviceID] = map[string]json.RawMessage{keyID: key}
+					delete(other.oneTimeKeys, keyID)
+					break
+				}
+			}
+		}
+
+		respond(map[string]interface{}{"one_time_keys": claimed})
+	case strings.Contains(path, "/sendToDevice/"):
+		// The path is `.../sendToDevice/{eventType}/{txnId}`.
+		parts := strings.Split(path, "/")
+		eventType := parts[len(parts)-2]
+
+		var request struct {
+			Messages map[id.UserID]map[id.DeviceID]json.RawMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		for userID, devices := range request.Messages {
+			for deviceID, content := range devices {
+				if recipient := h.find(userID, deviceID); recipient != nil {
+					evt, _ := json.Marshal(map[string]interface{}{
+						"type":    eventType,
+						"sender":  device.userID,
+						"content": content,
+					})
+					recipient.inbox = append(recipient.inbox, evt)
+				}
+			}
+		}
+
+		respond(map[string]interface{}{})
+	case strings.HasSuffix(path, "/sync"):
+		events := device.inbox
+		device.inbox = nil
+		if events == nil {
+			events = []json.RawMessage{}
+		}
+
+		respond(map[string]interface{}{
+			"next_batch": time.Now().Format(time.RFC3339Nano),
+			"to_device":  map[string]interface{}{"events": events},
+		})
+
+		// Don't let the clients spin while there is nothing to sync.
+		if len(events) == 0 {
+			h.mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			h.mutex.Lock()
+		}
+	default:
+		http.Error(w, `{"errcode": "M_UNRECOGNIZED"}`, http.StatusNotFound)
+	}
+}
+
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Error(message string, args ...interface{}) { l.t.Logf("error: "+message, args...) }
+func (l testLogger) Warn(message string, args ...interface{})  { l.t.Logf("warn: "+message, args...) }
+func (l testLogger) Debug(string, ...interface{})              {}
+func (l testLogger) Trace(string, ...interface{})              {}
+
+type testNoRooms struct{}
+
+func (testNoRooms) IsEncrypted(id.RoomID) bool                                 { return false }
+func (testNoRooms) GetEncryptionEvent(id.RoomID) *event.EncryptionEventContent { return nil }
+func (testNoRooms) FindSharedRooms(id.UserID) []id.RoomID                      { return nil }
+
+func TestDecryptToDeviceEvent(t *testing.T) {
+	homeserver := &keysHomeserver{devices: map[string]*keysDevice{
+		"sfu":   {userID: "@sfu:example.org", deviceID: "SFU", oneTimeKeys: map[string]json.RawMessage{}},
+		"alice": {userID: "@alice:example.org", deviceID: "ALICE", oneTimeKeys: map[string]json.RawMessage{}},
+	}}
+	server := httptest.NewServer(homeserver)
+	defer server.Close()
+
+	// The SFU publishes its keys when it's created and decrypts the events that it syncs.
+	sfu := signaling.NewMatrixClient(signaling.Config{
+		HomeserverURL: server.URL,
+		UserID:        "@sfu:example.org",
+		AccessToken:   "sfu",
+		Encryption:    true,
+	}, t.TempDir())
+
+	received := make(chan signaling.IncomingMessage, 1)
+	go sfu.Run(func(msg signaling.IncomingMessage) { received <- msg })
+
+	// Alice's client encrypts the call event for the SFU's device.
+	client, err := mautrix.NewClient(server.URL, "@alice:example.org", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.DeviceID = "ALICE"
+
+	store, err := crypto.NewGobStore(filepath.Join(t.TempDir(), "alice.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine := crypto.NewOlmMachine(client, testLogger{t}, store, testNoRooms{})
+	if err := machine.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := machine.ShareKeys(0); err != nil {
+		t.Fatalf("failed to share Alice's keys: %v", err)
+	}
+
+	device, err := machine.GetOrFetchDevice("@sfu:example.org", "SFU")
+	if err != nil || device == nil {
+		t.Fatalf("failed to get the SFU's device: %v", err)
+	}
+
+	content := event.Content{Raw: map[string]interface{}{
+		"conf_id":   "conference",
+		"call_id":   "call",
+		"device_id": "ALICE",
+		"version":   "1",
+		"reason":    "user_hangup",
+	}}
+	if err := machine.SendEncryptedToDevice(device, event.CallHangup, content); err != nil {
+		t.Fatalf("failed to send the encrypted event: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.Type.Type != event.CallHangup.Type {
+			t.Errorf("expected %s, got %s", event.CallHangup.Type, msg.Type.Type)
+		}
+
+		if msg.UserID != "@alice:example.org" || msg.DeviceID != "ALICE" {
+			t.Errorf("unexpected sender %s (%s)", msg.UserID, msg.DeviceID)
+		}
+
+		if msg.ConferenceID != "conference" || msg.CallID != "call" {
+			t.Errorf("unexpected conference %s or call %s", msg.ConferenceID, msg.CallID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SFU did not receive the decrypted event")
+	}
+}
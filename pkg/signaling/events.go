@@ -0,0 +1,146 @@
+package signaling
+
+import (
+	"fmt"
+	"reflect"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// The metadata of the streams has changed. The event is usually sent over the data channel, but
+// it can also be sent as a to-device message if the data channel is not available.
+var ToDeviceCallSDPStreamMetadataChanged = event.Type{
+	Type:  "m.call.sdp_stream_metadata_changed",
+	Class: event.ToDeviceEventType,
+}
+
+type CallSDPStreamMetadataChangedEventContent struct {
+	event.BaseCallEventContent
+	SDPStreamMetadata event.CallSDPStreamMetadata `json:"org.matrix.msc3077.sdp_stream_metadata"`
+}
+
+// Another focus moves a conference to us, so the participants that it lists are going to call us. It's only
+// sent between the foci, the participants learn about the new focus from the focus that they're leaving.
+var ToDeviceCallHandoff = event.Type{
+	Type:  "m.call.handoff",
+	Class: event.ToDeviceEventType,
+}
+
+type CallHandoffEventContent struct {
+	event.BaseCallEventContent
+	Participants []HandoffParticipant `json:"participants"`
+}
+
+// A participant (device) that is handed over to another focus.
+type HandoffParticipant struct {
+	UserID   id.UserID   `json:"user_id"`
+	DeviceID id.DeviceID `json:"device_id"`
+	// The tracks that the participant is subscribed to, the new focus subscribes it to them once it calls.
+	Subscriptions []HandoffSubscription `json:"subscriptions,omitempty"`
+}
+
+// A track subscription along with the requirements that the subscriber has asked for.
+type HandoffSubscription struct {
+	TrackID      string `json:"track_id"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	MaxFrameRate int    `json:"max_frame_rate,omitempty"`
+}
+
+func init() {
+	// Let mautrix parse the events that it does not know about.
+	event.TypeMap[ToDeviceCallSDPStreamMetadataChanged] = reflect.TypeOf(CallSDPStreamMetadataChangedEventContent{})
+	event.TypeMap[ToDeviceCallHandoff] = reflect.TypeOf(CallHandoffEventContent{})
+}
+
+// Converts a signaling message into the call event that carries it to the recipient.
+// The events are the same for all transports, only the way they are delivered differs.
+func newCallEvent(
+	conferenceID string,
+	deviceID id.DeviceID,
+	message MatrixMessage,
+) (event.Type, *event.Content, error) {
+	base := event.BaseCallEventContent{
+		CallID:          message.Recipient.CallID,
+		ConfID:          conferenceID,
+		DeviceID:        deviceID,
+		SenderSessionID: LocalSessionID,
+		DestSessionID:   message.Recipient.RemoteSessionID,
+		PartyID:         string(deviceID),
+		Version:         event.CallVersion("1"),
+	}
+
+	switch msg := message.Message.(type) {
+	case Invite:
+		return event.CallInvite, &event.Content{
+			Parsed: event.CallInviteEventContent{
+				BaseCallEventContent: base,
+				Lifetime:             inviteLifetime,
+				Offer:                event.CallData{Type: "offer", SDP: msg.SDP},
+				SDPStreamMetadata:    msg.StreamMetadata,
+			},
+		}, nil
+	case SdpAnswer:
+		return event.CallAnswer, &event.Content{
+			Parsed: event.CallAnswerEventContent{
+				BaseCallEventContent: base,
+				Answer:               event.CallData{Type: "answer", SDP: msg.SDP},
+				SDPStreamMetadata:    msg.StreamMetadata,
+			},
+		}, nil
+	case IceCandidates:
+		return event.CallCandidates, &event.Content{
+			Parsed: event.CallCandidatesEventContent{
+				BaseCallEventContent: base,
+				Candidates:           msg.Candidates,
+			},
+		}, nil
+	case CandidatesGatheringFinished:
+		return event.CallCandidates, &event.Content{
+			Parsed: event.CallCandidatesEventContent{
+				BaseCallEventContent: base,
+				Candidates:           []event.CallCandidate{{Candidate: ""}},
+			},
+		}, nil
+	case Negotiate:
+		return event.CallNegotiate, &event.Content{
+			Parsed: event.CallNegotiateEventContent{
+				BaseCallEventContent: base,
+				Lifetime:             inviteLifetime,
+				Description:          msg.Description,
+				SDPStreamMetadata:    msg.StreamMetadata,
+			},
+		}, nil
+	case StreamMetadataChanged:
+		return ToDeviceCallSDPStreamMetadataChanged, &event.Content{
+			Parsed: CallSDPStreamMetadataChangedEventContent{
+				BaseCallEventContent: base,
+				SDPStreamMetadata:    msg.StreamMetadata,
+			},
+		}, nil
+	case Hangup:
+		content := &event.Content{
+			Parsed: event.CallHangupEventContent{
+				BaseCallEventContent: base,
+				Reason:               msg.Reason,
+			},
+		}
+
+		// Tell the recipient which focus to use instead (MSC3898).
+		if msg.Focus != nil {
+			content.Raw = map[string]interface{}{"focus": msg.Focus}
+		}
+
+		return event.CallHangup, content, nil
+	case Handoff:
+		return ToDeviceCallHandoff, &event.Content{
+			Parsed: CallHandoffEventContent{
+				BaseCallEventContent: base,
+				Participants:         msg.Participants,
+			},
+		}, nil
+	default:
+		return event.Type{}, nil, fmt.Errorf("unknown signaling message type: %T", msg)
+	}
+}
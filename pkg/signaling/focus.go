@@ -0,0 +1,26 @@
+package signaling
+
+import "maunium.net/go/mautrix/id"
+
+// A focus (e.g. an SFU) that is reachable via Matrix.
+type Focus struct {
+	// The Matrix ID (MXID) of the focus.
+	UserID id.UserID `yaml:"userId" json:"user_id"`
+	// The device ID of the focus.
+	DeviceID id.DeviceID `yaml:"deviceId" json:"device_id"`
+}
+
+// Checks if the focus is identified by a given user and device ID.
+func (f Focus) Is(userID id.UserID, deviceID id.DeviceID) bool {
+	return f.UserID == userID && f.DeviceID == deviceID
+}
+
+// Defines a stable order on the foci, so that two foci can agree on which one of them wins
+// should they try to do the same thing at the same time (e.g. to link to each other).
+func (f Focus) Less(other Focus) bool {
+	if f.UserID != other.UserID {
+		return f.UserID < other.UserID
+	}
+
+	return f.DeviceID < other.DeviceID
+}
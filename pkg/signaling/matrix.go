@@ -17,17 +17,39 @@ limitations under the License.
 package signaling
 
 import (
+	"errors"
+	"net/http"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util"
 )
 
-const LocalSessionID = "sfu"
+// The session ID of this SFU process. It changes with each restart, so that the clients that send
+// messages to an older session can tell that the SFU has lost the state of their calls.
+var LocalSessionID = id.SessionID(util.RandomString(16))
+
+// How long (in milliseconds) the invites that we send to other foci are valid.
+const inviteLifetime = 30000
+
+// The maximal number of recipients of a single `/sendToDevice` request.
+const maxBatchSize = 100
+
+// Retries of the failed `/sendToDevice` requests (with exponential backoff).
+const (
+	maxSendAttempts = 6
+	initialBackoff  = 500 * time.Millisecond
+)
 
 // Interface that abstracts sending Send-to-device messages for the conference.
 type MatrixSignaler interface {
-	SendMessage(MatrixMessage)
+	// Sends the messages to their recipients (retrying if necessary) and
+	// returns the messages that could not be delivered.
+	SendMessages([]MatrixMessage) []MatrixMessage
+	UserID() id.UserID
 	DeviceID() id.DeviceID
 }
 
@@ -44,6 +66,11 @@ type MatrixMessage struct {
 	Message   interface{}
 }
 
+type Invite struct {
+	StreamMetadata event.CallSDPStreamMetadata
+	SDP            string
+}
+
 type SdpAnswer struct {
 	StreamMetadata event.CallSDPStreamMetadata
 	SDP            string
@@ -55,127 +82,249 @@ type IceCandidates struct {
 
 type CandidatesGatheringFinished struct{}
 
+// Renegotiation (normally done over the data channel).
+type Negotiate struct {
+	Description    event.CallData
+	StreamMetadata event.CallSDPStreamMetadata
+}
+
+// Update of the available streams (normally sent over the data channel).
+type StreamMetadataChanged struct {
+	StreamMetadata event.CallSDPStreamMetadata
+}
+
 type Hangup struct {
 	Reason event.CallHangupReason
+	// The focus that the recipient should use instead of us (if any).
+	Focus *Focus
 }
 
+// Hands the participants of a conference over to another focus (only sent to the foci).
+type Handoff struct {
+	Participants []HandoffParticipant
+}
+
+// The recipient must use another focus for the call (the one specified in the `Hangup`, if known).
+const CallHangupFocusMismatch event.CallHangupReason = "focus_mismatch"
+
+// The reasons for rejecting the callers that may not use the SFU.
+const (
+	// The caller (or their server) is not allowed to use the SFU.
+	CallHangupNotAllowed event.CallHangupReason = "not_allowed"
+	// The caller is not a member of the room that the conference belongs to.
+	CallHangupNotRoomMember event.CallHangupReason = "not_room_member"
+	// The caller has not provided a valid shared secret or OpenID token.
+	CallHangupInvalidCredentials event.CallHangupReason = "invalid_credentials"
+)
+
+// The message was sent to a session of the SFU that does not exist anymore (e.g. before a restart).
+// The recipient must send a new invite to continue the call.
+const CallHangupSessionMismatch event.CallHangupReason = "session_mismatch"
+
+// The SFU is shutting down, the recipient may call it (or another focus) again right away.
+const CallHangupFocusShutdown event.CallHangupReason = "focus_shutdown"
+
 // Matrix client scoped for a particular conference.
 type MatrixForConference struct {
 	client       *mautrix.Client
+	encryption   encryption
 	conferenceID string
 }
 
 // Create a new Matrix client that abstracts outgoing Matrix messages from a given conference.
-func (m *MatrixClient) CreateForConference(conferenceID string) *MatrixForConference {
+func (m *MatrixClient) CreateForConference(conferenceID string) MatrixSignaler {
 	return &MatrixForConference{
 		client:       m.client,
+		encryption:   m.encryption,
 		conferenceID: conferenceID,
 	}
 }
 
-func (m *MatrixForConference) SendMessage(message MatrixMessage) {
-	switch msg := message.Message.(type) {
-	case SdpAnswer:
-		m.sendSdpAnswer(message.Recipient, msg.StreamMetadata, msg.SDP)
-	case IceCandidates:
-		m.sendICECandidates(message.Recipient, msg.Candidates)
-	case CandidatesGatheringFinished:
-		m.sendCandidatesGatheringFinished(message.Recipient)
-	case Hangup:
-		m.sendHangup(message.Recipient, msg.Reason)
-	default:
-		logrus.Error("Unknown signaling message type")
-		return
+func (m *MatrixForConference) SendMessages(messages []MatrixMessage) []MatrixMessage {
+	if m.encryption != nil {
+		return m.sendEncrypted(messages)
 	}
+
+	failed := []MatrixMessage{}
+
+	for _, batch := range m.batchMessages(messages) {
+		failed = append(failed, m.sendBatch(batch)...)
+	}
+
+	return failed
+}
+
+// Sends a batch and returns the messages that could not be delivered. If the homeserver rejects the batch
+// (e.g. because of an invalid recipient), it's split and the parts are sent separately, so that only the
+// messages that the homeserver does not accept fail.
+func (m *MatrixForConference) sendBatch(batch *toDeviceBatch) []MatrixMessage {
+	err := m.sendToDevice(batch.eventType, batch.request())
+	if err == nil {
+		return nil
+	}
+
+	// The homeserver is not available (even after the retries), there is no point in trying the parts.
+	if isRetriable(err) || len(batch.messages) == 1 {
+		logrus.WithError(err).Errorf("failed to send %s to %d devices", batch.eventType.Type, len(batch.messages))
+		return batch.messages
+	}
+
+	logrus.WithError(err).Warnf("%s rejected for %d devices, sending in parts", batch.eventType.Type, len(batch.messages))
+
+	first, second := batch.split()
+	return append(m.sendBatch(first), m.sendBatch(second)...)
+}
+
+// Encrypted messages can't be batched, each of them is encrypted for its recipient and sent separately.
+func (m *MatrixForConference) sendEncrypted(messages []MatrixMessage) []MatrixMessage {
+	failed := []MatrixMessage{}
+
+	for _, message := range messages {
+		eventType, eventContent, err := newCallEvent(m.conferenceID, m.client.DeviceID, message)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to create a call event")
+			continue
+		}
+
+		err = withRetries(eventType, func() error {
+			return m.encryption.sendEncrypted(message.Recipient, eventType, eventContent)
+		})
+		if err != nil {
+			logrus.WithError(err).Errorf("failed to send encrypted %s to %s", eventType.Type, message.Recipient.UserID)
+			failed = append(failed, message)
+		}
+	}
+
+	return failed
+}
+
+func (m *MatrixForConference) UserID() id.UserID {
+	return m.client.UserID
 }
 
 func (m *MatrixForConference) DeviceID() id.DeviceID {
 	return m.client.DeviceID
 }
 
-func (m *MatrixForConference) sendSdpAnswer(
-	recipient MatrixRecipient,
-	streamMetadata event.CallSDPStreamMetadata,
-	sdp string,
-) {
-	eventContent := &event.Content{
-		Parsed: event.CallAnswerEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Answer: event.CallData{
-				Type: "answer",
-				SDP:  sdp,
-			},
-			SDPStreamMetadata: streamMetadata,
-		},
+// Messages of the same type that can be sent to different devices in a single request.
+type toDeviceBatch struct {
+	eventType event.Type
+	messages  []MatrixMessage
+	// The contents of the events, in the same order as the messages.
+	contents []*event.Content
+}
+
+// Returns the contents of the events by their recipients (as `/sendToDevice` wants them).
+func (b *toDeviceBatch) request() map[id.UserID]map[id.DeviceID]*event.Content {
+	contents := make(map[id.UserID]map[id.DeviceID]*event.Content)
+	for i, message := range b.messages {
+		userID, deviceID := message.Recipient.UserID, message.Recipient.DeviceID
+		if contents[userID] == nil {
+			contents[userID] = make(map[id.DeviceID]*event.Content)
+		}
+
+		contents[userID][deviceID] = b.contents[i]
 	}
 
-	m.sendToDevice(recipient, event.CallAnswer, eventContent)
+	return contents
+}
+
+// Splits the batch into two halves.
+func (b *toDeviceBatch) split() (*toDeviceBatch, *toDeviceBatch) {
+	half := len(b.messages) / 2
+	return &toDeviceBatch{eventType: b.eventType, messages: b.messages[:half], contents: b.contents[:half]},
+		&toDeviceBatch{eventType: b.eventType, messages: b.messages[half:], contents: b.contents[half:]}
 }
 
-func (m *MatrixForConference) sendICECandidates(recipient MatrixRecipient, candidates []event.CallCandidate) {
-	eventContent := &event.Content{
-		Parsed: event.CallCandidatesEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Candidates:           candidates,
-		},
+// Checks if the batch already has a message for a given device.
+func (b *toDeviceBatch) hasRecipient(recipient MatrixRecipient) bool {
+	for _, message := range b.messages {
+		if message.Recipient.UserID == recipient.UserID && message.Recipient.DeviceID == recipient.DeviceID {
+			return true
+		}
 	}
 
-	m.sendToDevice(recipient, event.CallCandidates, eventContent)
+	return false
 }
 
-func (m *MatrixForConference) sendCandidatesGatheringFinished(recipient MatrixRecipient) {
-	eventContent := &event.Content{
-		Parsed: event.CallCandidatesEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Candidates:           []event.CallCandidate{{Candidate: ""}},
-		},
+// Groups the messages into batches. Only consecutive messages of the same type to distinct devices
+// are put into the same batch, so that each device still gets its messages in the original order.
+func (m *MatrixForConference) batchMessages(messages []MatrixMessage) []*toDeviceBatch {
+	batches := []*toDeviceBatch{}
+
+	var current *toDeviceBatch
+	for _, message := range messages {
+		eventType, eventContent, err := newCallEvent(m.conferenceID, m.client.DeviceID, message)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to create a call event")
+			continue
+		}
+
+		if current == nil ||
+			current.eventType != eventType ||
+			len(current.messages) == maxBatchSize ||
+			current.hasRecipient(message.Recipient) {
+			current = &toDeviceBatch{eventType: eventType}
+			batches = append(batches, current)
+		}
+
+		current.contents = append(current.contents, eventContent)
+		current.messages = append(current.messages, message)
 	}
 
-	m.sendToDevice(recipient, event.CallCandidates, eventContent)
+	return batches
 }
 
-func (m *MatrixForConference) sendHangup(recipient MatrixRecipient, reason event.CallHangupReason) {
-	eventContent := &event.Content{
-		Parsed: event.CallHangupEventContent{
-			BaseCallEventContent: m.createBaseEventContent(recipient.CallID, recipient.RemoteSessionID),
-			Reason:               reason,
-		},
+// Sends to-device events, retrying with exponential backoff if the homeserver is not available. The transaction
+// ID stays the same across the retries, so that the homeserver does not deliver the same events twice.
+func (m *MatrixForConference) sendToDevice(
+	eventType event.Type,
+	contents map[id.UserID]map[id.DeviceID]*event.Content,
+) error {
+	request := mautrix.FullRequest{
+		Method:      http.MethodPut,
+		URL:         m.client.BuildClientURL("v3", "sendToDevice", eventType.String(), m.client.TxnID()),
+		RequestJSON: &mautrix.ReqSendToDevice{Messages: contents},
+		MaxAttempts: 1,
 	}
 
-	m.sendToDevice(recipient, event.CallHangup, eventContent)
-}
-
-func (m *MatrixForConference) createBaseEventContent(
-	callID string,
-	destSessionID id.SessionID,
-) event.BaseCallEventContent {
-	return event.BaseCallEventContent{
-		CallID:          callID,
-		ConfID:          m.conferenceID,
-		DeviceID:        m.client.DeviceID,
-		SenderSessionID: LocalSessionID,
-		DestSessionID:   destSessionID,
-		PartyID:         string(m.client.DeviceID),
-		Version:         event.CallVersion("1"),
-	}
+	return withRetries(eventType, func() error {
+		_, err := m.client.MakeFullRequest(request)
+		return err
+	})
 }
 
-// Sends a to-device event to the given user.
-func (m *MatrixForConference) sendToDevice(user MatrixRecipient, eventType event.Type, eventContent *event.Content) {
-	logger := logrus.WithFields(logrus.Fields{
-		"user_id":   user.UserID,
-		"device_id": user.DeviceID,
-	})
+// Calls the send function until it succeeds, fails with a non-retriable error or runs out of attempts.
+func withRetries(eventType event.Type, send func() error) error {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
 
-	sendRequest := &mautrix.ReqSendToDevice{
-		Messages: map[id.UserID]map[id.DeviceID]*event.Content{
-			user.UserID: {
-				user.DeviceID: eventContent,
-			},
-		},
+		if attempt == maxSendAttempts || !isRetriable(err) {
+			return err
+		}
+
+		logrus.WithError(err).Warnf("failed to send %s, retrying in %s", eventType.Type, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
+}
 
-	if _, err := m.client.SendToDevice(eventType, sendRequest); err != nil {
-		logger.Errorf("failed to send to-device event: %v", err)
+// Checks if it makes sense to repeat a failed request, i.e. if the homeserver is
+// unreachable or overloaded (as opposed to rejecting the request as invalid).
+func isRetriable(err error) bool {
+	if errors.Is(err, ErrUnknownDevice) {
+		return false
 	}
+
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Response != nil {
+		status := httpErr.Response.StatusCode
+		return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+	}
+
+	return true
 }
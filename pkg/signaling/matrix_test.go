@@ -0,0 +1,182 @@
+package signaling_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Homeserver that records the `/sendToDevice` requests and responds with the status that the test wants.
+type homeserver struct {
+	mutex sync.Mutex
+	// The event type and the recipient devices of each request.
+	requests []string
+	// Returns the status of the response to a request with a given index for the given devices.
+	respond func(index int, devices []string) int
+}
+
+func (h *homeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/account/whoami") {
+		json.NewEncoder(w).Encode(map[string]string{"user_id": "@sfu:example.org", "device_id": "SFU"})
+		return
+	}
+
+	var body struct {
+		Messages map[string]map[string]json.RawMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices := []string{}
+	for _, userDevices := range body.Messages {
+		for device := range userDevices {
+			devices = append(devices, device)
+		}
+	}
+	sort.Strings(devices)
+
+	// The path is `.../sendToDevice/{eventType}/{txnId}`.
+	parts := strings.Split(r.URL.Path, "/")
+	eventType := parts[len(parts)-2]
+
+	h.mutex.Lock()
+	index := len(h.requests)
+	h.requests = append(h.requests, eventType+" "+strings.Join(devices, ","))
+	h.mutex.Unlock()
+
+	status := h.respond(index, devices)
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		w.Write([]byte("{}"))
+	} else {
+		json.NewEncoder(w).Encode(map[string]string{"errcode": "M_UNKNOWN", "error": "failed"})
+	}
+}
+
+func hangupTo(deviceID id.DeviceID) signaling.MatrixMessage {
+	return signaling.MatrixMessage{
+		Recipient: signaling.MatrixRecipient{UserID: "@alice:example.org", DeviceID: deviceID, CallID: "call"},
+		Message:   signaling.Hangup{Reason: event.CallHangupUserHangup},
+	}
+}
+
+func candidatesTo(deviceID id.DeviceID) signaling.MatrixMessage {
+	return signaling.MatrixMessage{
+		Recipient: signaling.MatrixRecipient{UserID: "@alice:example.org", DeviceID: deviceID, CallID: "call"},
+		Message:   signaling.IceCandidates{},
+	}
+}
+
+func TestSendMessages(t *testing.T) {
+	ok := func(int, []string) int { return http.StatusOK }
+
+	for _, testCase := range []struct {
+		name     string
+		messages []signaling.MatrixMessage
+		respond  func(index int, devices []string) int
+		requests []string
+		// Devices whose messages could not be delivered.
+		failed []id.DeviceID
+	}{
+		{
+			name:     "messages to different devices are batched",
+			messages: []signaling.MatrixMessage{hangupTo("A"), hangupTo("B"), hangupTo("C")},
+			respond:  ok,
+			requests: []string{"m.call.hangup A,B,C"},
+		},
+		{
+			name:     "messages to the same device are not batched",
+			messages: []signaling.MatrixMessage{hangupTo("A"), hangupTo("B"), hangupTo("A")},
+			respond:  ok,
+			requests: []string{"m.call.hangup A,B", "m.call.hangup A"},
+		},
+		{
+			name:     "messages of different types are not batched",
+			messages: []signaling.MatrixMessage{candidatesTo("A"), hangupTo("B"), hangupTo("C")},
+			respond:  ok,
+			requests: []string{"m.call.candidates A", "m.call.hangup B,C"},
+		},
+		{
+			name:     "unavailable homeserver is retried",
+			messages: []signaling.MatrixMessage{hangupTo("A"), hangupTo("B")},
+			respond: func(index int, _ []string) int {
+				if index == 0 {
+					return http.StatusServiceUnavailable
+				}
+				return http.StatusOK
+			},
+			requests: []string{"m.call.hangup A,B", "m.call.hangup A,B"},
+		},
+		{
+			name:     "rate limited request is retried",
+			messages: []signaling.MatrixMessage{hangupTo("A")},
+			respond: func(index int, _ []string) int {
+				if index == 0 {
+					return http.StatusTooManyRequests
+				}
+				return http.StatusOK
+			},
+			requests: []string{"m.call.hangup A", "m.call.hangup A"},
+		},
+		{
+			name:     "rejected batch is split to find the failed recipients",
+			messages: []signaling.MatrixMessage{hangupTo("A"), hangupTo("BAD"), hangupTo("C")},
+			respond: func(_ int, devices []string) int {
+				for _, device := range devices {
+					if device == "BAD" {
+						return http.StatusBadRequest
+					}
+				}
+				return http.StatusOK
+			},
+			requests: []string{
+				"m.call.hangup A,BAD,C",
+				"m.call.hangup A",
+				"m.call.hangup BAD,C",
+				"m.call.hangup BAD",
+				"m.call.hangup C",
+			},
+			failed: []id.DeviceID{"BAD"},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := &homeserver{respond: testCase.respond}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+
+			client := signaling.NewMatrixClient(signaling.Config{
+				HomeserverURL: httpServer.URL,
+				UserID:        "@sfu:example.org",
+				AccessToken:   "token",
+			}, "")
+
+			failed := []id.DeviceID{}
+			for _, message := range client.CreateForConference("conference").SendMessages(testCase.messages) {
+				failed = append(failed, message.Recipient.DeviceID)
+			}
+
+			if testCase.failed == nil {
+				testCase.failed = []id.DeviceID{}
+			}
+
+			if !reflect.DeepEqual(failed, testCase.failed) {
+				t.Errorf("expected %v to fail, got %v", testCase.failed, failed)
+			}
+
+			if !reflect.DeepEqual(server.requests, testCase.requests) {
+				t.Errorf("expected requests %v, got %v", testCase.requests, server.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// Sync store that persists the sync token and the filter ID in a JSON file, so that the SFU continues
+// the sync where it stopped after a restart (instead of replaying or missing to-device events). The rooms
+// are not persisted since the SFU does not rely on them.
+type FileStore struct {
+	path  string
+	mutex sync.Mutex
+	state fileStoreState
+	rooms map[id.RoomID]*mautrix.Room
+}
+
+type fileStoreState struct {
+	Filters   map[id.UserID]string `json:"filters"`
+	NextBatch map[id.UserID]string `json:"next_batch"`
+}
+
+// Loads the store from a given file (the file is created on the first save if it does not exist).
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{
+		path: path,
+		state: fileStoreState{
+			Filters:   make(map[id.UserID]string),
+			NextBatch: make(map[id.UserID]string),
+		},
+		rooms: make(map[id.RoomID]*mautrix.Room),
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sync store: %w", err)
+	}
+
+	if err := json.Unmarshal(contents, &store.state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync store: %w", err)
+	}
+
+	return store, nil
+}
+
+func (s *FileStore) SaveFilterID(userID id.UserID, filterID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state.Filters[userID] = filterID
+	s.save()
+}
+
+func (s *FileStore) LoadFilterID(userID id.UserID) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.state.Filters[userID]
+}
+
+func (s *FileStore) SaveNextBatch(userID id.UserID, nextBatchToken string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state.NextBatch[userID] = nextBatchToken
+	s.save()
+}
+
+func (s *FileStore) LoadNextBatch(userID id.UserID) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.state.NextBatch[userID]
+}
+
+func (s *FileStore) SaveRoom(room *mautrix.Room) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rooms[room.ID] = room
+}
+
+func (s *FileStore) LoadRoom(roomID id.RoomID) *mautrix.Room {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.rooms[roomID]
+}
+
+// Writes the state into a temporary file first, so that a crash does not leave a corrupted store behind.
+// Must be called with the mutex held.
+func (s *FileStore) save() {
+	contents, err := json.Marshal(s.state)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to serialize sync store")
+		return
+	}
+
+	temporary, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		logrus.WithError(err).Error("Failed to save sync store")
+		return
+	}
+	defer os.Remove(temporary.Name())
+
+	if _, err := temporary.Write(contents); err != nil {
+		temporary.Close()
+		logrus.WithError(err).Error("Failed to save sync store")
+		return
+	}
+
+	if err := temporary.Sync(); err != nil {
+		temporary.Close()
+		logrus.WithError(err).Error("Failed to save sync store")
+		return
+	}
+
+	if err := temporary.Close(); err != nil {
+		logrus.WithError(err).Error("Failed to save sync store")
+		return
+	}
+
+	if err := os.Rename(temporary.Name(), s.path); err != nil {
+		logrus.WithError(err).Error("Failed to save sync store")
+	}
+}
@@ -0,0 +1,38 @@
+package signaling_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+)
+
+func TestFileStorePersistsSyncState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.json")
+
+	store, err := signaling.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	if token := store.LoadNextBatch("@sfu:server"); token != "" {
+		t.Errorf("expected no token in a new store, got %q", token)
+	}
+
+	store.SaveFilterID("@sfu:server", "filter")
+	store.SaveNextBatch("@sfu:server", "s1_2_3")
+	store.SaveNextBatch("@sfu:server", "s4_5_6")
+
+	reloaded, err := signaling.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("failed to reload store: %v", err)
+	}
+
+	if filterID := reloaded.LoadFilterID("@sfu:server"); filterID != "filter" {
+		t.Errorf("expected filter ID %q, got %q", "filter", filterID)
+	}
+
+	if token := reloaded.LoadNextBatch("@sfu:server"); token != "s4_5_6" {
+		t.Errorf("expected token %q, got %q", "s4_5_6", token)
+	}
+}
@@ -0,0 +1,131 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Names of the supported transports (in the configuration).
+const (
+	TransportMatrix    = "matrix"
+	TransportWebSocket = "websocket"
+)
+
+// Transport delivers the signaling messages between the SFU and the participants. Matrix (to-device
+// messages) is the default one, but any transport that carries the same payloads would do.
+type Transport interface {
+	// Runs the transport and calls the handler upon each incoming message.
+	// Returns only when the transport stops or fails.
+	Run(handler func(IncomingMessage)) error
+	// Creates a signaler that sends the messages of a given conference.
+	CreateForConference(conferenceID string) MatrixSignaler
+	// Returns the focus that this transport represents (i.e. us).
+	Focus() Focus
+}
+
+// Optional interface of the transports that know which foci the participants have chosen (MSC3898).
+type FocusSelector interface {
+	GetCallMembers(roomID id.RoomID) (CallMembers, error)
+}
+
+// Optional interface of the transports that can tell if a user is in the room that the call belongs to.
+type MembershipChecker interface {
+	IsJoined(roomID id.RoomID, userID id.UserID) (bool, error)
+}
+
+// Optional interface of the transports that can tell if the senders of the incoming messages are who they
+// claim to be. The senders of the transports that don't implement it are not trusted.
+type SenderAuthenticator interface {
+	Authenticates() bool
+}
+
+// Signaling message received from a participant, regardless of the transport that delivered it.
+type IncomingMessage struct {
+	// The type of the message, e.g. `m.call.invite`.
+	Type event.Type
+	// The conference (group call) that the message belongs to.
+	ConferenceID string
+	// The call (within the conference) that the message belongs to.
+	CallID string
+	// The sender of the message.
+	UserID   id.UserID
+	DeviceID id.DeviceID
+	// The room that the conference belongs to (if known).
+	RoomID id.RoomID
+	// The session of the sender and the session of ours that the message is meant for.
+	SenderSessionID id.SessionID
+	DestSessionID   id.SessionID
+	// The parsed content of the message, e.g. `*event.CallInviteEventContent`.
+	Content interface{}
+	// The content as the sender has sent it (JSON), empty if not known.
+	RawContent []byte
+	// The ID that the sender has given to the message (unique for the sender's device), empty if not known.
+	// Matrix does not tell the transaction IDs of the to-device messages, but the WebSocket clients may.
+	TransactionID string
+	// The credentials that the sender has provided (if any), only used with the invites.
+	Credentials Credentials
+}
+
+// Credentials that prove that the caller may use the SFU.
+type Credentials struct {
+	// Shared secret (`sfu_secret`).
+	SharedSecret string
+	// OpenID token of the caller (`sfu_openid`), nil if not provided.
+	OpenID *OpenIDToken
+}
+
+// OpenID token that the caller's homeserver has issued, see `/_matrix/client/v3/user/{userId}/openid/request_token`.
+type OpenIDToken struct {
+	AccessToken      string
+	MatrixServerName string
+}
+
+var ErrMissingIDs = errors.New("message without conference, call or device ID")
+
+// Converts a call event (the payload that all transports carry) into an `IncomingMessage`.
+func parseCallEvent(evt *event.Event) (IncomingMessage, error) {
+	if evt.Content.Parsed == nil {
+		if err := evt.Content.ParseRaw(evt.Type); err != nil {
+			return IncomingMessage{}, fmt.Errorf("failed to parse %s: %w", evt.Type.Type, err)
+		}
+	}
+
+	conferenceID, okConferenceID := evt.Content.Raw["conf_id"].(string)
+	callID, okCallID := evt.Content.Raw["call_id"].(string)
+	deviceID, okDeviceID := evt.Content.Raw["device_id"].(string)
+
+	if !okConferenceID || !okCallID || !okDeviceID {
+		return IncomingMessage{}, ErrMissingIDs
+	}
+
+	// The room that the call belongs to (optional, only needed to select the focus).
+	roomID, _ := evt.Content.Raw["room_id"].(string)
+
+	senderSessionID, _ := evt.Content.Raw["sender_session_id"].(string)
+	destSessionID, _ := evt.Content.Raw["dest_session_id"].(string)
+
+	var credentials Credentials
+	credentials.SharedSecret, _ = evt.Content.Raw["sfu_secret"].(string)
+	if openID, ok := evt.Content.Raw["sfu_openid"].(map[string]interface{}); ok {
+		accessToken, _ := openID["access_token"].(string)
+		serverName, _ := openID["matrix_server_name"].(string)
+		credentials.OpenID = &OpenIDToken{AccessToken: accessToken, MatrixServerName: serverName}
+	}
+
+	return IncomingMessage{
+		Type:            evt.Type,
+		ConferenceID:    conferenceID,
+		CallID:          callID,
+		UserID:          evt.Sender,
+		DeviceID:        id.DeviceID(deviceID),
+		RoomID:          id.RoomID(roomID),
+		SenderSessionID: id.SessionID(senderSessionID),
+		DestSessionID:   id.SessionID(destSessionID),
+		Content:         evt.Content.Parsed,
+		RawContent:      evt.Content.VeryRaw,
+		Credentials:     credentials,
+	}, nil
+}
@@ -0,0 +1,262 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Configuration of the WebSocket signaling transport.
+type WebSocketConfig struct {
+	// The address that the WebSocket server listens on, e.g. "0.0.0.0:8091".
+	ListenAddress string `yaml:"listen"`
+	// The identity of the SFU on this transport (the sender of the messages and the focus that clients call).
+	UserID   id.UserID   `yaml:"userId"`
+	DeviceID id.DeviceID `yaml:"deviceId"`
+	// The secret that the tokens of the clients are signed with, see `WebSocketToken()`. Required, since the
+	// clients could claim any identity otherwise.
+	Secret string `yaml:"secret"`
+}
+
+// Returns the token that a given device must connect with if the server is configured with a given secret, i.e.
+// the hex-encoded HMAC-SHA256 of `<user_id>\0<device_id>`. It's meant to be issued to the clients by a service
+// that knows who they are (e.g. the backend of the app).
+func WebSocketToken(secret string, userID id.UserID, deviceID id.DeviceID) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\x00%s", userID, deviceID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// A message as it is sent over the WebSocket (in both directions). The content is the same as
+// the content of the corresponding Matrix to-device event, e.g. of `m.call.invite`.
+type webSocketMessage struct {
+	Type    string         `json:"type"`
+	Content *event.Content `json:"content"`
+	// Optional ID of the message (unique for the sender's device), so that a message that
+	// the client sends again (e.g. after a reconnect) could be recognized.
+	TransactionID string `json:"txn_id,omitempty"`
+}
+
+// Plain WebSocket (JSON) signaling server. The clients connect to `/signaling?user_id=...&device_id=...&token=...`
+// (or send the token in the `Authorization: Bearer` header) and exchange the same call events as they would over
+// Matrix. The endpoint is not served if the secret is not configured, since the clients could not be authenticated.
+type WebSocketServer struct {
+	config WebSocketConfig
+	// Connected clients (the last connection of each device wins).
+	mutex       sync.Mutex
+	connections map[id.UserID]map[id.DeviceID]*websocket.Conn
+}
+
+func NewWebSocketServer(config WebSocketConfig) *WebSocketServer {
+	return &WebSocketServer{
+		config:      config,
+		connections: make(map[id.UserID]map[id.DeviceID]*websocket.Conn),
+	}
+}
+
+// Returns the focus that this server represents (i.e. us).
+func (s *WebSocketServer) Focus() Focus {
+	return Focus{UserID: s.config.UserID, DeviceID: s.config.DeviceID}
+}
+
+// Starts the WebSocket server. Returns only when the server fails.
+func (s *WebSocketServer) Run(handler func(IncomingMessage)) error {
+	logrus.Infof("starting WebSocket signaling on %s", s.config.ListenAddress)
+	return http.ListenAndServe(s.config.ListenAddress, s.Handler(handler))
+}
+
+// Returns the HTTP handler that serves the WebSocket clients and calls the handler upon each incoming message.
+func (s *WebSocketServer) Handler(handler func(IncomingMessage)) http.Handler {
+	mux := http.NewServeMux()
+
+	if !s.Authenticates() {
+		logrus.Error("not serving the WebSocket clients since websocket.secret is not set")
+		return mux
+	}
+
+	mux.Handle("/signaling", websocket.Server{
+		Handler: func(conn *websocket.Conn) {
+			s.serveConnection(conn, handler)
+		},
+		Handshake: acceptAnyOrigin,
+	})
+
+	return mux
+}
+
+// The clients are authenticated by their tokens, not by the origin, so the connections are accepted from any
+// origin, including the native clients that don't send one (the default handshake rejects them).
+func acceptAnyOrigin(config *websocket.Config, request *http.Request) error {
+	config.Origin, _ = websocket.Origin(config, request)
+	return nil
+}
+
+// Whether the server makes sure that the clients are who they claim to be.
+func (s *WebSocketServer) Authenticates() bool {
+	return s.config.Secret != ""
+}
+
+// Checks the token that the client has connected with.
+func (s *WebSocketServer) authenticate(request *http.Request, userID id.UserID, deviceID id.DeviceID) bool {
+	token := request.URL.Query().Get("token")
+	if header := request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	expected := WebSocketToken(s.config.Secret, userID, deviceID)
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+// Creates a signaler that abstracts outgoing messages from a given conference.
+func (s *WebSocketServer) CreateForConference(conferenceID string) MatrixSignaler {
+	return &webSocketForConference{server: s, conferenceID: conferenceID}
+}
+
+func (s *WebSocketServer) serveConnection(conn *websocket.Conn, handler func(IncomingMessage)) {
+	query := conn.Request().URL.Query()
+	userID, deviceID := id.UserID(query.Get("user_id")), id.DeviceID(query.Get("device_id"))
+
+	logger := logrus.WithFields(logrus.Fields{"user_id": userID, "device_id": deviceID})
+	if userID == "" || deviceID == "" {
+		logger.Warn("Rejecting WebSocket connection without user or device ID")
+		conn.Close()
+		return
+	}
+
+	if !s.authenticate(conn.Request(), userID, deviceID) {
+		logger.Warn("Rejecting WebSocket connection with an invalid token")
+		conn.Close()
+		return
+	}
+
+	s.addConnection(userID, deviceID, conn)
+	defer s.removeConnection(userID, deviceID, conn)
+	logger.Info("WebSocket client connected")
+
+	for {
+		var msg webSocketMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			logger.WithError(err).Info("WebSocket client disconnected")
+			return
+		}
+
+		if msg.Content == nil {
+			logger.WithField("type", msg.Type).Warn("Ignoring message without content")
+			continue
+		}
+
+		message, err := parseCallEvent(&event.Event{
+			Sender:  userID,
+			Type:    event.Type{Type: msg.Type, Class: event.ToDeviceEventType},
+			Content: *msg.Content,
+		})
+		if err != nil {
+			logger.WithError(err).WithField("type", msg.Type).Warn("Ignoring invalid message")
+			continue
+		}
+
+		// The clients may only speak for the device that they have connected as.
+		if message.DeviceID != deviceID {
+			logger.WithField("type", msg.Type).Warnf("Ignoring message sent on behalf of %s", message.DeviceID)
+			continue
+		}
+
+		message.TransactionID = msg.TransactionID
+		handler(message)
+	}
+}
+
+func (s *WebSocketServer) addConnection(userID id.UserID, deviceID id.DeviceID, conn *websocket.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	devices, ok := s.connections[userID]
+	if !ok {
+		devices = make(map[id.DeviceID]*websocket.Conn)
+		s.connections[userID] = devices
+	}
+
+	if previous, ok := devices[deviceID]; ok {
+		previous.Close()
+	}
+
+	devices[deviceID] = conn
+}
+
+func (s *WebSocketServer) removeConnection(userID id.UserID, deviceID id.DeviceID, conn *websocket.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	conn.Close()
+
+	// The connection might have been replaced by a newer one already.
+	if s.connections[userID][deviceID] == conn {
+		delete(s.connections[userID], deviceID)
+		if len(s.connections[userID]) == 0 {
+			delete(s.connections, userID)
+		}
+	}
+}
+
+func (s *WebSocketServer) getConnection(userID id.UserID, deviceID id.DeviceID) *websocket.Conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.connections[userID][deviceID]
+}
+
+// WebSocket signaler scoped for a particular conference.
+type webSocketForConference struct {
+	server       *WebSocketServer
+	conferenceID string
+}
+
+func (w *webSocketForConference) SendMessages(messages []MatrixMessage) []MatrixMessage {
+	failed := []MatrixMessage{}
+
+	for _, message := range messages {
+		logger := logrus.WithFields(logrus.Fields{
+			"user_id":   message.Recipient.UserID,
+			"device_id": message.Recipient.DeviceID,
+		})
+
+		eventType, eventContent, err := newCallEvent(w.conferenceID, w.server.config.DeviceID, message)
+		if err != nil {
+			logger.WithError(err).Error("Failed to create a call event")
+			continue
+		}
+
+		if err := w.send(message.Recipient, eventType, eventContent); err != nil {
+			logger.WithError(err).Error("Failed to send WebSocket message")
+			failed = append(failed, message)
+		}
+	}
+
+	return failed
+}
+
+func (w *webSocketForConference) send(recipient MatrixRecipient, eventType event.Type, content *event.Content) error {
+	conn := w.server.getConnection(recipient.UserID, recipient.DeviceID)
+	if conn == nil {
+		return fmt.Errorf("%s (%s) is not connected", recipient.UserID, recipient.DeviceID)
+	}
+
+	return websocket.JSON.Send(conn, webSocketMessage{Type: eventType.Type, Content: content})
+}
+
+func (w *webSocketForConference) UserID() id.UserID {
+	return w.server.config.UserID
+}
+
+func (w *webSocketForConference) DeviceID() id.DeviceID {
+	return w.server.config.DeviceID
+}
@@ -0,0 +1,153 @@
+package signaling_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matrix-org/waterfall/pkg/signaling"
+	"golang.org/x/net/websocket"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestWebSocketAuthentication(t *testing.T) {
+	const secret = "secret"
+
+	for _, testCase := range []struct {
+		name string
+		// Secret of the server.
+		secret string
+		// Token that the client connects with.
+		token string
+		// Device ID within the call event that the client sends.
+		contentDeviceID id.DeviceID
+		accepted        bool
+	}{
+		{
+			name:            "valid token",
+			secret:          secret,
+			token:           signaling.WebSocketToken(secret, "@alice:example.org", "ALICE"),
+			contentDeviceID: "ALICE",
+			accepted:        true,
+		},
+		{
+			name:            "missing token",
+			secret:          secret,
+			contentDeviceID: "ALICE",
+		},
+		{
+			name:            "token of another device",
+			secret:          secret,
+			token:           signaling.WebSocketToken(secret, "@alice:example.org", "OTHER"),
+			contentDeviceID: "ALICE",
+		},
+		{
+			name:            "token signed with another secret",
+			secret:          secret,
+			token:           signaling.WebSocketToken("other", "@alice:example.org", "ALICE"),
+			contentDeviceID: "ALICE",
+		},
+		{
+			name:            "message on behalf of another device",
+			secret:          secret,
+			token:           signaling.WebSocketToken(secret, "@alice:example.org", "ALICE"),
+			contentDeviceID: "OTHER",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := signaling.NewWebSocketServer(signaling.WebSocketConfig{Secret: testCase.secret})
+
+			received := make(chan signaling.IncomingMessage, 1)
+			httpServer := httptest.NewServer(server.Handler(func(msg signaling.IncomingMessage) { received <- msg }))
+			defer httpServer.Close()
+
+			query := url.Values{"user_id": {"@alice:example.org"}, "device_id": {"ALICE"}, "token": {testCase.token}}
+			address := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/signaling?" + query.Encode()
+
+			conn, err := websocket.Dial(address, "", httpServer.URL)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			hangup := map[string]interface{}{
+				"type": "m.call.hangup",
+				"content": map[string]interface{}{
+					"conf_id":   "conference",
+					"call_id":   "call",
+					"device_id": testCase.contentDeviceID,
+					"version":   "1",
+				},
+			}
+
+			// The server may have closed the connection already, so the result of sending does not matter.
+			_ = websocket.JSON.Send(conn, hangup)
+
+			select {
+			case msg := <-received:
+				if !testCase.accepted {
+					t.Fatalf("unexpected message from %s (%s)", msg.UserID, msg.DeviceID)
+				}
+
+				if msg.UserID != "@alice:example.org" || msg.DeviceID != "ALICE" {
+					t.Errorf("unexpected sender %s (%s)", msg.UserID, msg.DeviceID)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if testCase.accepted {
+					t.Fatal("expected the message to be received")
+				}
+			}
+		})
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	for _, testCase := range []struct {
+		name   string
+		secret string
+		origin string
+		// The expected status of the upgrade request.
+		status int
+	}{
+		{name: "browser", secret: "secret", origin: "https://app.example.org", status: http.StatusSwitchingProtocols},
+		{name: "native client without origin", secret: "secret", status: http.StatusSwitchingProtocols},
+		{name: "no secret", origin: "https://app.example.org", status: http.StatusNotFound},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := signaling.NewWebSocketServer(signaling.WebSocketConfig{Secret: testCase.secret})
+			httpServer := httptest.NewServer(server.Handler(func(signaling.IncomingMessage) {}))
+			defer httpServer.Close()
+
+			query := url.Values{
+				"user_id":   {"@alice:example.org"},
+				"device_id": {"ALICE"},
+				"token":     {signaling.WebSocketToken(testCase.secret, "@alice:example.org", "ALICE")},
+			}
+			request, err := http.NewRequest(http.MethodGet, httpServer.URL+"/signaling?"+query.Encode(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request.Header.Set("Connection", "Upgrade")
+			request.Header.Set("Upgrade", "websocket")
+			request.Header.Set("Sec-WebSocket-Version", "13")
+			request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			if testCase.origin != "" {
+				request.Header.Set("Origin", testCase.origin)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != testCase.status {
+				t.Errorf("expected status %d, got %d", testCase.status, response.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package webrtc_ext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+	"golang.org/x/exp/slices"
+)
+
+// The codecs that `RegisterDefaultCodecs()` registers.
+var defaultCodecs = []string{
+	webrtc.MimeTypeOpus,
+	webrtc.MimeTypeG722,
+	webrtc.MimeTypePCMU,
+	webrtc.MimeTypePCMA,
+	webrtc.MimeTypeVP8,
+	webrtc.MimeTypeVP9,
+	webrtc.MimeTypeH264,
+}
+
+// The codecs of one kind that are used if only the codecs of the other kind are configured,
+// i.e. those of Pion's default codecs that can be configured.
+var (
+	defaultAudioCodecs = []CodecConfig{{Name: "opus", FEC: true}}
+	defaultVideoCodecs = []CodecConfig{{Name: "vp8"}, {Name: "vp9"}, {Name: "h264"}}
+)
+
+// Properties of the codecs that can be configured.
+type codecDescription struct {
+	mimeType    string
+	kind        webrtc.RTPCodecType
+	clockRate   uint32
+	channels    uint16
+	payloadType webrtc.PayloadType
+	// Default profile (if the codec has profiles).
+	profile string
+}
+
+var supportedCodecs = map[string]codecDescription{
+	"opus": {webrtc.MimeTypeOpus, webrtc.RTPCodecTypeAudio, 48000, 2, 111, ""},
+	"vp8":  {webrtc.MimeTypeVP8, webrtc.RTPCodecTypeVideo, 90000, 0, 96, ""},
+	"vp9":  {webrtc.MimeTypeVP9, webrtc.RTPCodecTypeVideo, 90000, 0, 98, "0"},
+	"h264": {webrtc.MimeTypeH264, webrtc.RTPCodecTypeVideo, 90000, 0, 102, "42e01f"},
+	"av1":  {webrtc.MimeTypeAV1, webrtc.RTPCodecTypeVideo, 90000, 0, 45, ""},
+}
+
+// The same feedback that Pion uses for its default video codecs.
+var videoRTCPFeedback = []webrtc.RTCPFeedback{
+	{Type: "goog-remb"},
+	{Type: "ccm", Parameter: "fir"},
+	{Type: "nack"},
+	{Type: "nack", Parameter: "pli"},
+}
+
+// Registers the configured codecs (in the order of preference) or Pion's default ones if none are configured.
+func registerCodecs(mediaEngine *webrtc.MediaEngine, config CodecsConfig) error {
+	if len(config.Audio) == 0 && len(config.Video) == 0 {
+		if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+			return fmt.Errorf("failed to register default codecs: %w", err)
+		}
+
+		return nil
+	}
+
+	codecs, err := codecParameters(config)
+	if err != nil {
+		return err
+	}
+
+	for _, codec := range codecs {
+		kind := webrtc.RTPCodecTypeVideo
+		if strings.HasPrefix(codec.MimeType, "audio/") {
+			kind = webrtc.RTPCodecTypeAudio
+		}
+
+		if err := mediaEngine.RegisterCodec(codec, kind); err != nil {
+			return fmt.Errorf("failed to register codec %s: %w", codec.MimeType, err)
+		}
+	}
+
+	return nil
+}
+
+// Converts the configured codecs into Pion's codec parameters, assigning the payload types where needed.
+func codecParameters(config CodecsConfig) ([]webrtc.RTPCodecParameters, error) {
+	config = withDefaultCodecs(config)
+
+	configured := make([]CodecConfig, 0, len(config.Audio)+len(config.Video))
+	for _, codec := range config.Audio {
+		if description, ok := supportedCodecs[strings.ToLower(codec.Name)]; !ok || description.kind != webrtc.RTPCodecTypeAudio {
+			return nil, fmt.Errorf("unsupported audio codec: %s", codec.Name)
+		}
+
+		configured = append(configured, codec)
+	}
+
+	for _, codec := range config.Video {
+		if description, ok := supportedCodecs[strings.ToLower(codec.Name)]; !ok || description.kind != webrtc.RTPCodecTypeVideo {
+			return nil, fmt.Errorf("unsupported video codec: %s", codec.Name)
+		}
+
+		configured = append(configured, codec)
+	}
+
+	// The explicitly configured payload types are reserved first, so that the defaults don't clash with them.
+	usedPayloadTypes := make(map[webrtc.PayloadType]bool)
+	for _, codec := range configured {
+		if codec.PayloadType == 0 {
+			continue
+		}
+
+		payloadType := webrtc.PayloadType(codec.PayloadType)
+		if usedPayloadTypes[payloadType] {
+			return nil, fmt.Errorf("duplicate payload type: %d", payloadType)
+		}
+
+		usedPayloadTypes[payloadType] = true
+	}
+
+	result := make([]webrtc.RTPCodecParameters, 0, len(configured))
+	for _, codec := range configured {
+		description := supportedCodecs[strings.ToLower(codec.Name)]
+
+		payloadType := webrtc.PayloadType(codec.PayloadType)
+		if payloadType == 0 {
+			payloadType = description.payloadType
+			if usedPayloadTypes[payloadType] {
+				freeType, err := freePayloadType(usedPayloadTypes)
+				if err != nil {
+					return nil, err
+				}
+
+				payloadType = freeType
+			}
+
+			usedPayloadTypes[payloadType] = true
+		}
+
+		fmtp, err := codecFmtp(description, codec)
+		if err != nil {
+			return nil, err
+		}
+
+		var feedback []webrtc.RTCPFeedback
+		if description.kind == webrtc.RTPCodecTypeVideo {
+			feedback = videoRTCPFeedback
+		}
+
+		result = append(result, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     description.mimeType,
+				ClockRate:    description.clockRate,
+				Channels:     description.channels,
+				SDPFmtpLine:  fmtp,
+				RTCPFeedback: feedback,
+			},
+			PayloadType: payloadType,
+		})
+	}
+
+	return result, nil
+}
+
+// Fills in the default codecs of a kind if only the codecs of the other kind are configured,
+// so that the peers can still send both audio and video.
+func withDefaultCodecs(config CodecsConfig) CodecsConfig {
+	if len(config.Audio) == 0 {
+		config.Audio = defaultAudioCodecs
+	}
+
+	if len(config.Video) == 0 {
+		config.Video = defaultVideoCodecs
+	}
+
+	return config
+}
+
+// Generates the `a=fmtp` line of the codec.
+func codecFmtp(description codecDescription, codec CodecConfig) (string, error) {
+	if codec.Profile != "" && description.profile == "" {
+		return "", fmt.Errorf("codec %s has no profiles", codec.Name)
+	}
+
+	profile := codec.Profile
+	if profile == "" {
+		profile = description.profile
+	}
+
+	var parameters []string
+	switch description.mimeType {
+	case webrtc.MimeTypeOpus:
+		parameters = append(parameters, "minptime=10")
+		if codec.FEC {
+			parameters = append(parameters, "useinbandfec=1")
+		}
+		if codec.DTX {
+			parameters = append(parameters, "usedtx=1")
+		}
+		if codec.Stereo {
+			parameters = append(parameters, "stereo=1", "sprop-stereo=1")
+		}
+	case webrtc.MimeTypeVP9:
+		parameters = append(parameters, "profile-id="+profile)
+	case webrtc.MimeTypeH264:
+		parameters = append(parameters,
+			"level-asymmetry-allowed=1",
+			"packetization-mode=1",
+			"profile-level-id="+profile,
+		)
+	}
+
+	return strings.Join(parameters, ";"), nil
+}
+
+// Returns the first dynamic payload type that is not used yet.
+func freePayloadType(used map[webrtc.PayloadType]bool) (webrtc.PayloadType, error) {
+	for payloadType := webrtc.PayloadType(96); payloadType <= 127; payloadType++ {
+		if !used[payloadType] {
+			return payloadType, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free dynamic payload types left")
+}
+
+// Returns the MIME types of the codecs (in the order of preference) that the config enables.
+func configuredCodecs(config CodecsConfig) []string {
+	if len(config.Audio) == 0 && len(config.Video) == 0 {
+		return slices.Clone(defaultCodecs)
+	}
+
+	config = withDefaultCodecs(config)
+
+	result := make([]string, 0, len(config.Audio)+len(config.Video))
+	for _, codec := range append(slices.Clone(config.Audio), config.Video...) {
+		mimeType := supportedCodecs[strings.ToLower(codec.Name)].mimeType
+		if mimeType != "" && !slices.Contains(result, mimeType) {
+			result = append(result, mimeType)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,130 @@
+package webrtc_ext_test
+
+import (
+	"testing"
+
+	"github.com/matrix-org/waterfall/pkg/webrtc_ext"
+	"github.com/pion/webrtc/v3"
+	"golang.org/x/exp/slices"
+)
+
+func TestCodecPayloadTypes(t *testing.T) {
+	codec := func(name string, payloadType uint8) webrtc_ext.CodecConfig {
+		return webrtc_ext.CodecConfig{Name: name, PayloadType: payloadType}
+	}
+
+	// Every dynamic payload type taken by VP8 (configuring a codec more than once is allowed).
+	allDynamic := []webrtc_ext.CodecConfig{}
+	for payloadType := 96; payloadType <= 127; payloadType++ {
+		allDynamic = append(allDynamic, codec("vp8", uint8(payloadType)))
+	}
+
+	cases := []struct {
+		name   string
+		config webrtc_ext.CodecsConfig
+		// MIME types and payload types of the resulting codecs (in order).
+		mimeTypes    []string
+		payloadTypes []webrtc.PayloadType
+		fails        bool
+	}{
+		{
+			name: "usual payload types",
+			config: webrtc_ext.CodecsConfig{
+				Audio: []webrtc_ext.CodecConfig{codec("opus", 0)},
+				Video: []webrtc_ext.CodecConfig{codec("vp8", 0), codec("av1", 0)},
+			},
+			mimeTypes:    []string{webrtc.MimeTypeOpus, webrtc.MimeTypeVP8, webrtc.MimeTypeAV1},
+			payloadTypes: []webrtc.PayloadType{111, 96, 45},
+		},
+		{
+			name: "configured payload types are reserved first",
+			config: webrtc_ext.CodecsConfig{
+				Audio: []webrtc_ext.CodecConfig{codec("opus", 0)},
+				Video: []webrtc_ext.CodecConfig{codec("vp8", 0), codec("vp9", 111)},
+			},
+			mimeTypes:    []string{webrtc.MimeTypeOpus, webrtc.MimeTypeVP8, webrtc.MimeTypeVP9},
+			payloadTypes: []webrtc.PayloadType{96, 97, 111},
+		},
+		{
+			name: "same codec twice",
+			config: webrtc_ext.CodecsConfig{
+				Audio: []webrtc_ext.CodecConfig{codec("opus", 0)},
+				Video: []webrtc_ext.CodecConfig{
+					{Name: "h264", Profile: "42e01f"},
+					{Name: "h264", Profile: "42001f"},
+				},
+			},
+			mimeTypes:    []string{webrtc.MimeTypeOpus, webrtc.MimeTypeH264, webrtc.MimeTypeH264},
+			payloadTypes: []webrtc.PayloadType{111, 102, 96},
+		},
+		{
+			name: "duplicate configured payload types",
+			config: webrtc_ext.CodecsConfig{
+				Audio: []webrtc_ext.CodecConfig{codec("opus", 100)},
+				Video: []webrtc_ext.CodecConfig{codec("vp8", 100)},
+			},
+			fails: true,
+		},
+		{
+			name: "no free payload types left",
+			config: webrtc_ext.CodecsConfig{
+				Audio: []webrtc_ext.CodecConfig{codec("opus", 111)},
+				Video: append(slices.Clone(allDynamic[:15]), append(allDynamic[16:], codec("vp9", 0))...),
+			},
+			fails: true,
+		},
+		{
+			name: "video defaults if only audio is configured",
+			config: webrtc_ext.CodecsConfig{
+				Audio: []webrtc_ext.CodecConfig{codec("opus", 0)},
+			},
+			mimeTypes:    []string{webrtc.MimeTypeOpus, webrtc.MimeTypeVP8, webrtc.MimeTypeVP9, webrtc.MimeTypeH264},
+			payloadTypes: []webrtc.PayloadType{111, 96, 98, 102},
+		},
+		{
+			name: "audio defaults if only video is configured",
+			config: webrtc_ext.CodecsConfig{
+				Video: []webrtc_ext.CodecConfig{codec("vp8", 111)},
+			},
+			mimeTypes:    []string{webrtc.MimeTypeOpus, webrtc.MimeTypeVP8},
+			payloadTypes: []webrtc.PayloadType{96, 111},
+		},
+		{
+			name: "codec of the wrong kind",
+			config: webrtc_ext.CodecsConfig{
+				Audio: []webrtc_ext.CodecConfig{codec("vp8", 0)},
+			},
+			fails: true,
+		},
+	}
+
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			codecs, err := webrtc_ext.CodecParameters(testCase.config)
+			if testCase.fails {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", codecs)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mimeTypes, payloadTypes := []string{}, []webrtc.PayloadType{}
+			for _, codec := range codecs {
+				mimeTypes = append(mimeTypes, codec.MimeType)
+				payloadTypes = append(payloadTypes, codec.PayloadType)
+			}
+
+			if !slices.Equal(mimeTypes, testCase.mimeTypes) {
+				t.Errorf("expected codecs %v, got %v", testCase.mimeTypes, mimeTypes)
+			}
+
+			if !slices.Equal(payloadTypes, testCase.payloadTypes) {
+				t.Errorf("expected payload types %v, got %v", testCase.payloadTypes, payloadTypes)
+			}
+		})
+	}
+}
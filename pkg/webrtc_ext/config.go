@@ -6,4 +6,69 @@ type Config struct {
 	EnableSimulcast bool `yaml:"simulcast"`
 	// Pulibc IP address of the SFU.
 	PublicIPs []string `yaml:"ipAddresses"`
+	// STUN and TURN servers that the SFU uses to gather its ICE candidates.
+	ICEServers []ICEServer `yaml:"iceServers"`
+	// ICE transport policy: "all" (default) or "relay" (only the candidates of the TURN servers are used).
+	ICETransportPolicy string `yaml:"iceTransportPolicy"`
+	// Types of the local ICE candidates that are sent to the peers: "host", "srflx", "prflx" and/or "relay".
+	// All candidates are sent if empty.
+	CandidateTypes []string `yaml:"candidateTypes"`
+	// UDP port that all peer connections share for ICE (a separate port per peer connection if 0).
+	UDPMuxPort int `yaml:"udpMuxPort"`
+	// TCP port that all peer connections share for ICE-TCP (ICE-TCP is disabled if 0).
+	TCPMuxPort int `yaml:"tcpMuxPort"`
+	// Range of the ephemeral UDP ports that the peer connections use (any port if not set).
+	// Not used for the host candidates if `UDPMuxPort` is set.
+	PortRange PortRange `yaml:"portRange"`
+	// Names of the network interfaces that are used to gather the candidates (all if empty).
+	Interfaces []string `yaml:"interfaces"`
+	// IP addresses or CIDR ranges (e.g. "10.0.0.0/8") that the local host candidates must belong to
+	// in order to be sent to the peers (all if empty).
+	IPs []string `yaml:"ips"`
+	// Use ICE-lite: the SFU only offers its host candidates (which must be reachable by the peers, see
+	// `PublicIPs`) within the SDP and does not initiate the connectivity checks. STUN/TURN servers are not used.
+	ICELite bool `yaml:"iceLite"`
+	// Codecs that the SFU offers and accepts (Pion's default codecs if not set).
+	Codecs CodecsConfig `yaml:"codecs"`
+}
+
+// Codecs in the order of preference.
+type CodecsConfig struct {
+	Audio []CodecConfig `yaml:"audio"`
+	Video []CodecConfig `yaml:"video"`
+}
+
+type CodecConfig struct {
+	// Name of the codec: "opus" (audio), "vp8", "vp9", "h264" or "av1" (video).
+	Name string `yaml:"name"`
+	// RTP payload type (the usual one for the codec or a free dynamic one if 0).
+	PayloadType uint8 `yaml:"payloadType"`
+	// VP9 `profile-id` (e.g. "0") or H.264 `profile-level-id` (e.g. "42e01f").
+	Profile string `yaml:"profile"`
+	// Opus in-band forward error correction.
+	FEC bool `yaml:"fec"`
+	// Opus discontinuous transmission.
+	DTX bool `yaml:"dtx"`
+	// Opus stereo.
+	Stereo bool `yaml:"stereo"`
+}
+
+// Range of UDP ports (inclusive).
+type PortRange struct {
+	Min uint16 `yaml:"min"`
+	Max uint16 `yaml:"max"`
+}
+
+// STUN or TURN server.
+type ICEServer struct {
+	// URLs of the server, e.g. "stun:stun.example.org:3478" or "turn:turn.example.org:3478?transport=udp".
+	URLs []string `yaml:"urls"`
+	// Static credentials (TURN only).
+	Username   string `yaml:"username"`
+	Credential string `yaml:"credential"`
+	// Shared secret of the TURN REST API (e.g. `static-auth-secret` of coturn). If set, time-limited
+	// credentials are generated for each peer connection and the static credential is ignored.
+	SharedSecret string `yaml:"sharedSecret"`
+	// Lifetime of the time-limited credentials (in seconds, 86400 if not set).
+	CredentialLifetime int `yaml:"credentialLifetime"`
 }
@@ -0,0 +1,8 @@
+package webrtc_ext
+
+import "github.com/pion/webrtc/v3"
+
+// Exposes the conversion of the configured codecs to the tests.
+func CodecParameters(config CodecsConfig) ([]webrtc.RTPCodecParameters, error) {
+	return codecParameters(config)
+}
@@ -2,25 +2,85 @@ package webrtc_ext
 
 import (
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/pion/webrtc/v3"
 )
 
 // Peer connection factory is used to construct new (pre-configured) peer connections.
 type PeerConnectionFactory struct {
-	api *webrtc.API
+	api             *webrtc.API
+	config          Config
+	transportPolicy webrtc.ICETransportPolicy
+	// Types of the local candidates that may be sent to the peers (all if nil).
+	candidateTypes map[webrtc.ICECandidateType]bool
+	// Networks that the local host candidates must belong to (all if nil).
+	ips []*net.IPNet
 }
 
 func NewPeerConnectionFactory(config Config) (*PeerConnectionFactory, error) {
+	transportPolicy, err := parseICETransportPolicy(config.ICETransportPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ICELite && transportPolicy == webrtc.ICETransportPolicyRelay {
+		return nil, fmt.Errorf("ICE-lite can't be used with the relay ICE transport policy")
+	}
+
+	candidateTypes, err := parseCandidateTypes(config.CandidateTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := parseIPFilter(config.IPs)
+	if err != nil {
+		return nil, err
+	}
+
 	api, err := createWebRTCAPI(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create WebRTC API: %w", err)
 	}
 
-	return &PeerConnectionFactory{api}, nil
+	return &PeerConnectionFactory{api, config, transportPolicy, candidateTypes, ips}, nil
+}
+
+// Whether the peer connections support simulcast.
+func (f *PeerConnectionFactory) Simulcast() bool {
+	return f.config.EnableSimulcast
+}
+
+// Whether the peer connections use ICE-lite, i.e. the local candidates are sent within the SDP, not trickled.
+func (f *PeerConnectionFactory) ICELite() bool {
+	return f.config.ICELite
+}
+
+// Returns the MIME types of the codecs that the peer connections support.
+func (f *PeerConnectionFactory) Codecs() []string {
+	return configuredCodecs(f.config.Codecs)
 }
 
 // Creates a peer connection with a specifically configured API (with simulcast etc).
 func (f *PeerConnectionFactory) CreatePeerConnection() (*webrtc.PeerConnection, error) {
-	return f.api.NewPeerConnection(webrtc.Configuration{})
+	configuration := webrtc.Configuration{ICETransportPolicy: f.transportPolicy}
+	if !f.config.ICELite {
+		configuration.ICEServers = iceServers(f.config.ICEServers, time.Now())
+	}
+
+	return f.api.NewPeerConnection(configuration)
+}
+
+// Checks if a local candidate may be sent to the peers (see `Config.CandidateTypes` and `Config.IPs`).
+func (f *PeerConnectionFactory) IsCandidateAllowed(candidate *webrtc.ICECandidate) bool {
+	if f.candidateTypes != nil && !f.candidateTypes[candidate.Typ] {
+		return false
+	}
+
+	if f.ips != nil && candidate.Typ == webrtc.ICECandidateTypeHost {
+		return containsAddress(f.ips, candidate.Address)
+	}
+
+	return true
 }
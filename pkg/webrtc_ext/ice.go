@@ -0,0 +1,137 @@
+package webrtc_ext
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Default lifetime of the time-limited TURN credentials.
+const defaultCredentialLifetime = 24 * time.Hour
+
+// Converts the configured ICE servers into the Pion's ones, generating the time-limited credentials if needed.
+func iceServers(servers []ICEServer, now time.Time) []webrtc.ICEServer {
+	result := make([]webrtc.ICEServer, 0, len(servers))
+	for _, server := range servers {
+		username, credential := server.Username, server.Credential
+		if server.SharedSecret != "" {
+			lifetime := defaultCredentialLifetime
+			if server.CredentialLifetime > 0 {
+				lifetime = time.Duration(server.CredentialLifetime) * time.Second
+			}
+
+			username, credential = restCredentials(server.SharedSecret, server.Username, now.Add(lifetime))
+		}
+
+		iceServer := webrtc.ICEServer{URLs: server.URLs}
+		if username != "" || credential != "" {
+			iceServer.Username = username
+			iceServer.Credential = credential
+			iceServer.CredentialType = webrtc.ICECredentialTypePassword
+		}
+
+		result = append(result, iceServer)
+	}
+
+	return result
+}
+
+// Generates the credentials of the TURN REST API: the username is the expiry timestamp (optionally followed
+// by the user name) and the password is the base64-encoded HMAC-SHA1 of the username with the shared secret.
+func restCredentials(secret string, user string, expires time.Time) (string, string) {
+	username := strconv.FormatInt(expires.Unix(), 10)
+	if user != "" {
+		username += ":" + user
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func parseICETransportPolicy(policy string) (webrtc.ICETransportPolicy, error) {
+	switch policy {
+	case "", "all":
+		return webrtc.ICETransportPolicyAll, nil
+	case "relay":
+		return webrtc.ICETransportPolicyRelay, nil
+	default:
+		return 0, fmt.Errorf("unknown ICE transport policy: %s", policy)
+	}
+}
+
+func parseCandidateTypes(types []string) (map[webrtc.ICECandidateType]bool, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	result := make(map[webrtc.ICECandidateType]bool)
+	for _, name := range types {
+		candidateType, err := webrtc.NewICECandidateType(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown ICE candidate type: %s", name)
+		}
+
+		result[candidateType] = true
+	}
+
+	return result, nil
+}
+
+// Parses the IP addresses and CIDR ranges of `Config.IPs`.
+func parseIPFilter(entries []string) ([]*net.IPNet, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	result := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", entry)
+			}
+
+			bits := net.IPv6len * 8
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), net.IPv4len*8
+			}
+
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range: %s", entry)
+		}
+
+		result = append(result, network)
+	}
+
+	return result, nil
+}
+
+// Checks if the address of a candidate belongs to any of the networks.
+func containsAddress(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
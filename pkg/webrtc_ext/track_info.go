@@ -4,6 +4,9 @@ import (
 	"github.com/pion/webrtc/v3"
 )
 
+// URI of the AV1 dependency descriptor header extension that carries the layers of the frames.
+const DependencyDescriptorURI = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+
 type RTCPPacketType int
 
 const (
@@ -46,6 +49,33 @@ func SimulcastLayerToRID(layer SimulcastLayer) string {
 	}
 }
 
+// An SVC stream carries all of its spatial layers in a single encoding. The spatial layers are mapped onto
+// the simulcast layers from the highest one (each spatial layer usually halves the resolution, just like the
+// simulcast layers do), so that the layers are selected the same way for SVC and simulcast.
+func SpatialLayerToSimulcastLayer(spatialLayer, spatialLayers int) SimulcastLayer {
+	layer := SimulcastLayerHigh - SimulcastLayer(spatialLayers-1-spatialLayer)
+	if layer < SimulcastLayerLow {
+		return SimulcastLayerLow
+	}
+
+	return layer
+}
+
+// Returns the highest spatial layer of an SVC stream that corresponds to a given simulcast layer
+// (all of them if no particular layer is requested).
+func SimulcastLayerToSpatialLayer(layer SimulcastLayer, spatialLayers int) int {
+	if layer == SimulcastLayerNone {
+		return spatialLayers - 1
+	}
+
+	spatialLayer := spatialLayers - 1 - int(SimulcastLayerHigh-layer)
+	if spatialLayer < 0 {
+		return 0
+	}
+
+	return spatialLayer
+}
+
 func (s SimulcastLayer) String() string {
 	switch s {
 	case SimulcastLayerLow:
@@ -65,6 +95,8 @@ type TrackInfo struct {
 	StreamID string
 	Kind     webrtc.RTPCodecType
 	Codec    webrtc.RTPCodecCapability
+	// ID of the AV1 dependency descriptor header extension (0 if not negotiated).
+	DependencyDescriptorID uint8
 }
 
 func TrackInfoFromTrack(track *webrtc.TrackRemote) TrackInfo {
@@ -75,3 +107,14 @@ func TrackInfoFromTrack(track *webrtc.TrackRemote) TrackInfo {
 		Codec:    track.Codec().RTPCodecCapability,
 	}
 }
+
+// Returns the ID of the AV1 dependency descriptor header extension that the receiver negotiated (0 if none).
+func DependencyDescriptorID(receiver *webrtc.RTPReceiver) uint8 {
+	for _, extension := range receiver.GetParameters().HeaderExtensions {
+		if extension.URI == DependencyDescriptorURI {
+			return uint8(extension.ID)
+		}
+	}
+
+	return 0
+}
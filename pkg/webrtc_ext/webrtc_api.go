@@ -2,16 +2,20 @@ package webrtc_ext
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
 )
 
+// Number of the packets that the ICE-TCP mux buffers for a connection before it's assigned to a peer connection.
+const tcpMuxReadBufferSize = 8
+
 // Creates Pion's WebRTC API that has all required extensions configured (such as simulcast).
 func createWebRTCAPI(config Config) (*webrtc.API, error) {
 	mediaEngine := &webrtc.MediaEngine{}
-	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
-		return nil, fmt.Errorf("failed to register default codecs: %w", err)
+	if err := registerCodecs(mediaEngine, config.Codecs); err != nil {
+		return nil, err
 	}
 
 	// Enable extension headers needed for simulcast (if enabled).
@@ -36,6 +40,21 @@ func createWebRTCAPI(config Config) (*webrtc.API, error) {
 		settingsEngine.SetNAT1To1IPs(config.PublicIPs, webrtc.ICECandidateTypeHost)
 	}
 
+	if err := configureICENetwork(&settingsEngine, config); err != nil {
+		return nil, err
+	}
+
+	// ICE-lite agents only gather the host candidates.
+	settingsEngine.SetLite(config.ICELite)
+
+	// The AV1 dependency descriptor tells us the temporal layers of the frames.
+	if err := mediaEngine.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: DependencyDescriptorURI},
+		webrtc.RTPCodecTypeVideo,
+	); err != nil {
+		return nil, fmt.Errorf("failed to register dependency descriptor extension: %w", err)
+	}
+
 	// Create a InterceptorRegistry. This is the user configurable RTP/RTCP
 	// Pipeline. This provides NACKs, RTCP Reports and other features. If
 	// `webrtc.NewPeerConnection` is used, then it is enabled by default. If
@@ -55,3 +74,48 @@ func createWebRTCAPI(config Config) (*webrtc.API, error) {
 
 	return api, nil
 }
+
+// Configures the ports and the network interfaces that the peer connections use for ICE.
+// The mux sockets are shared by all peer connections and stay open for the lifetime of the process.
+func configureICENetwork(settingsEngine *webrtc.SettingEngine, config Config) error {
+	if config.PortRange.Min != 0 || config.PortRange.Max != 0 {
+		if err := settingsEngine.SetEphemeralUDPPortRange(config.PortRange.Min, config.PortRange.Max); err != nil {
+			return fmt.Errorf("invalid port range %d-%d: %w", config.PortRange.Min, config.PortRange.Max, err)
+		}
+	}
+
+	if len(config.Interfaces) != 0 {
+		interfaces := make(map[string]bool, len(config.Interfaces))
+		for _, name := range config.Interfaces {
+			interfaces[name] = true
+		}
+
+		settingsEngine.SetInterfaceFilter(func(name string) bool {
+			return interfaces[name]
+		})
+	}
+
+	if config.UDPMuxPort != 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.UDPMuxPort})
+		if err != nil {
+			return fmt.Errorf("failed to listen on UDP mux port %d: %w", config.UDPMuxPort, err)
+		}
+
+		settingsEngine.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	}
+
+	networkTypes := []webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6}
+	if config.TCPMuxPort != 0 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.TCPMuxPort})
+		if err != nil {
+			return fmt.Errorf("failed to listen on TCP mux port %d: %w", config.TCPMuxPort, err)
+		}
+
+		settingsEngine.SetICETCPMux(webrtc.NewICETCPMux(nil, listener, tcpMuxReadBufferSize))
+		networkTypes = append(networkTypes, webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6)
+	}
+
+	settingsEngine.SetNetworkTypes(networkTypes)
+
+	return nil
+}